@@ -0,0 +1,183 @@
+package modbusserver
+
+import (
+	"fmt"
+	"slices"
+)
+
+type (
+	// AliasWindow maps Quantity addresses of a slave table, starting from Address, onto the same table of
+	// the Target slave starting from TargetAddress.
+	AliasWindow struct {
+		Slave         uint8  `json:"slave"`
+		Table         Table  `json:"table"`
+		Address       uint16 `json:"address"`
+		Quantity      uint16 `json:"quantity"`
+		Target        uint8  `json:"target"`
+		TargetAddress uint16 `json:"target_address"`
+		ReadOnly      bool   `json:"read_only,omitempty"`
+	}
+	slaveAlias struct {
+		target   uint8
+		readOnly bool
+	}
+)
+
+// AliasSlave makes the slave share the whole memory of the target slave, so writes through either ID are
+// visible through both at once. Writes through a read-only alias are rejected with IllegalDataAddress, and
+// writes through the alias are validated against the write rules of the target too.
+func (s *Server) AliasSlave(id uint8, target uint8, readOnly bool) (err error) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	if id == target {
+		return fmt.Errorf("slave %d can't be an alias of itself", id)
+	}
+	if _, ok := s.Slaves[target]; !ok {
+		return fmt.Errorf("slave with %d ID didn't implemented on server", target)
+	}
+	if _, ok := s.slaveAliases[target]; ok {
+		return fmt.Errorf("slave %d is an alias itself, use its target %d", target, s.slaveAliases[target].target)
+	}
+	for alias, current := range s.slaveAliases {
+		if current.target == id {
+			return fmt.Errorf("slave %d is the target of alias %d", id, alias)
+		}
+	}
+	s.Slaves[id] = s.Slaves[target]
+	s.slaveAliases[id] = slaveAlias{target: target, readOnly: readOnly}
+	s.writeRulesMutex.Lock()
+	s.aliasRules[id] = target
+	s.writeRulesMutex.Unlock()
+	return
+}
+
+// AddAliasWindow maps an address window of a slave onto a table of another slave. Writes on either side
+// are mirrored to the other one; the window is filled with the target values when added. Both slaves
+// must be initialized.
+//
+// Only writes made by Modbus masters or through Server methods are mirrored, direct changes of the
+// SlaveData slices are not.
+func (s *Server) AddAliasWindow(window AliasWindow) (err error) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	if window.Table > TableInputRegisters {
+		return fmt.Errorf("unknown table %d", window.Table)
+	}
+	if _, err = s.checkRange(window.Slave, window.Table, window.Address, int(window.Quantity)); err != nil {
+		return
+	}
+	if _, err = s.checkRange(window.Target, window.Table, window.TargetAddress, int(window.Quantity)); err != nil {
+		return
+	}
+	if s.memoryRoot(window.Slave) == s.memoryRoot(window.Target) {
+		return fmt.Errorf("slaves %d and %d share the same memory", window.Slave, window.Target)
+	}
+	for _, current := range s.aliasWindows {
+		if s.memoryRoot(current.Slave) == s.memoryRoot(window.Slave) && current.Table == window.Table &&
+			int(current.Address) < int(window.Address)+int(window.Quantity) && int(window.Address) < int(current.Address)+int(current.Quantity) {
+			return fmt.Errorf("window overlaps window of slave %d at %s %d", current.Slave, current.Table, current.Address)
+		}
+	}
+	values, _ := s.readRaw(window.Target, window.Table, window.TargetAddress, int(window.Quantity))
+	s.storeWrite(WriteEvent{Slave: window.Slave, Table: window.Table, Address: window.Address, Values: values})
+	s.aliasWindows = append(s.aliasWindows, window)
+	return
+}
+
+// RemoveAliases removes the alias windows of the slave and, if the slave is an alias, gives it a copy of
+// the shared memory.
+func (s *Server) RemoveAliases(id uint8) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	s.aliasWindows = slices.DeleteFunc(s.aliasWindows, func(window AliasWindow) bool { return window.Slave == id })
+	if _, ok := s.slaveAliases[id]; !ok {
+		return
+	}
+	delete(s.slaveAliases, id)
+	s.writeRulesMutex.Lock()
+	delete(s.aliasRules, id)
+	s.writeRulesMutex.Unlock()
+	shared := s.Slaves[id]
+	s.Slaves[id] = SlaveData{
+		Coils:            slices.Clone(shared.Coils),
+		DiscreteInputs:   slices.Clone(shared.DiscreteInputs),
+		HoldingRegisters: slices.Clone(shared.HoldingRegisters),
+		InputRegisters:   slices.Clone(shared.InputRegisters),
+	}
+}
+
+// AliasWindows returns a copy of the alias windows.
+func (s *Server) AliasWindows() []AliasWindow {
+	s.memoryMutex.RLock()
+	defer s.memoryMutex.RUnlock()
+	return slices.Clone(s.aliasWindows)
+}
+
+// memoryRoot returns the ID of the slave owning the memory of the slave. The memory must be locked.
+func (s *Server) memoryRoot(id uint8) uint8 {
+	if alias, ok := s.slaveAliases[id]; ok {
+		return alias.target
+	}
+	return id
+}
+
+// relinkAliases makes the slave aliases share the memory of their targets again after it was replaced.
+// The memory must be locked.
+func (s *Server) relinkAliases() {
+	for id, alias := range s.slaveAliases {
+		if target, ok := s.Slaves[alias.target]; ok {
+			s.Slaves[id] = target
+		}
+	}
+}
+
+// aliasReadOnly reports whether the write touches a read-only alias. The memory must be locked.
+func (s *Server) aliasReadOnly(id uint8, table Table, address int, count int) bool {
+	if alias, ok := s.slaveAliases[id]; ok && alias.readOnly {
+		return true
+	}
+	for _, window := range s.aliasWindows {
+		if window.ReadOnly && window.Slave == id && window.Table == table &&
+			int(window.Address) < address+count && address < int(window.Address)+int(window.Quantity) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorWrites returns the writes copying the part of the written values falling into alias windows to
+// the other side of the windows. Each window is used once per write to prevent loops. The memory must
+// be locked.
+func (s *Server) mirrorWrites(event WriteEvent, used []bool) (writes []WriteEvent) {
+	root := s.memoryRoot(event.Slave)
+	for i, window := range s.aliasWindows {
+		if used[i] || window.Table != event.Table {
+			continue
+		}
+		var to uint8
+		var fromAddress, toAddress uint16
+		switch root {
+		case s.memoryRoot(window.Slave):
+			fromAddress, to, toAddress = window.Address, window.Target, window.TargetAddress
+		case s.memoryRoot(window.Target):
+			fromAddress, to, toAddress = window.TargetAddress, window.Slave, window.Address
+		default:
+			continue
+		}
+		start := max(int(event.Address), int(fromAddress))
+		end := min(int(event.Address)+len(event.Values), int(fromAddress)+int(window.Quantity))
+		if start >= end {
+			continue
+		}
+		used[i] = true
+		mirrored := WriteEvent{
+			Slave:   to,
+			Table:   event.Table,
+			Address: uint16(int(toAddress) + start - int(fromAddress)),
+			Values:  event.Values[start-int(event.Address) : end-int(event.Address)],
+		}
+		writes = append(writes, mirrored)
+		writes = append(writes, s.mirrorWrites(mirrored, used)...)
+	}
+	return
+}
@@ -0,0 +1,189 @@
+package modbusserver
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+func TestAliasSlave(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	if err := s.AliasSlave(2, 1, false); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := s.AliasSlave(3, 1, true); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	var frame TCPFrame
+	frame.Device = 2
+	SetDataWithRegisterAndNumber(&frame, 4, 42)
+	if _, exception := WriteHoldingRegister(s, &frame); exception != &Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	if s.Slaves[1].HoldingRegisters[4] != 42 || s.Slaves[3].HoldingRegisters[4] != 42 {
+		t.Errorf("expected write to be visible through all aliases")
+	}
+
+	frame.Device = 3
+	if _, exception := WriteHoldingRegister(s, &frame); exception != &IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+
+	if err := s.AliasSlave(4, 2, false); err == nil {
+		t.Errorf("expected error on alias of alias, got nil")
+	}
+
+	s.RemoveAliases(2)
+	s.WriteTable(2, TableHoldingRegisters, 4, []uint16{7})
+	if s.Slaves[1].HoldingRegisters[4] != 42 || s.Slaves[2].HoldingRegisters[4] != 7 {
+		t.Errorf("expected removed alias to own its memory")
+	}
+}
+
+func TestAliasWindow(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.InitSlave(2)
+	s.InitSlave(3)
+	s.Slaves[1].HoldingRegisters[0] = 5
+	if err := s.AddAliasWindow(AliasWindow{Slave: 2, Table: TableHoldingRegisters, Address: 100, Quantity: 10, Target: 1}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := s.AddAliasWindow(AliasWindow{Slave: 3, Table: TableHoldingRegisters, Address: 0, Quantity: 4, Target: 1, TargetAddress: 2, ReadOnly: true}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if got := s.Slaves[2].HoldingRegisters[100]; got != 5 {
+		t.Errorf("expected window to be filled with target values, got %v", got)
+	}
+
+	// Write through slave 2 overlapping the end of its window.
+	var frame TCPFrame
+	frame.Device = 2
+	SetDataWithRegisterAndNumberAndValues(&frame, 108, 3, []uint16{1, 2, 3})
+	if _, exception := WriteHoldingRegisters(s, &frame); exception != &Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	if got := s.Slaves[1].HoldingRegisters[8:11]; !slices.Equal(got, []uint16{1, 2, 0}) {
+		t.Errorf("expected %v, got %v", []uint16{1, 2, 0}, got)
+	}
+
+	// Write to the target is visible through both windows.
+	s.WriteTable(1, TableHoldingRegisters, 2, []uint16{9, 10})
+	if s.Slaves[2].HoldingRegisters[102] != 9 || s.Slaves[3].HoldingRegisters[0] != 9 || s.Slaves[3].HoldingRegisters[1] != 10 {
+		t.Errorf("expected target write to be mirrored to windows")
+	}
+
+	// Write through slave 2 reaches slave 3 through the target.
+	s.WriteTable(2, TableHoldingRegisters, 103, []uint16{11})
+	if got := s.Slaves[3].HoldingRegisters[1]; got != 11 {
+		t.Errorf("expected %v, got %v", 11, got)
+	}
+
+	frame.Device = 3
+	SetDataWithRegisterAndNumber(&frame, 3, 1)
+	if _, exception := WriteHoldingRegister(s, &frame); exception != &IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+	SetDataWithRegisterAndNumber(&frame, 4, 1)
+	if _, exception := WriteHoldingRegister(s, &frame); exception != &Success {
+		t.Errorf("expected Success outside the read-only window, got %v", exception.String())
+	}
+
+	if err := s.AddAliasWindow(AliasWindow{Slave: 2, Table: TableHoldingRegisters, Address: 105, Quantity: 10, Target: 3}); err == nil {
+		t.Errorf("expected overlap error, got nil")
+	}
+}
+
+func TestAliasRulesAndHooks(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.InitSlave(2)
+	if err := s.AliasSlave(3, 1, false); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	limit := 10
+	s.AddWriteRule(1, WriteRule{Table: TableHoldingRegisters, Address: 0, Quantity: 5, Max: &limit})
+	if err := s.WriteChecked(3, TableHoldingRegisters, 2, []uint16{11}); err != IllegalDataValue {
+		t.Errorf("expected the rules of the target on the alias, got %v", err)
+	}
+	s.RemoveAliases(3)
+	if err := s.WriteChecked(3, TableHoldingRegisters, 2, []uint16{11}); err != nil {
+		t.Errorf("expected no rules after the alias is removed, got %v", err)
+	}
+
+	if err := s.AddAliasWindow(AliasWindow{Slave: 2, Table: TableHoldingRegisters, Address: 100, Quantity: 10, Target: 1}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	var hooked []WriteEvent
+	s.AddWriteHook(func(event WriteEvent) error {
+		hooked = append(hooked, event)
+		return nil
+	})
+	events, cancel := s.SubscribeEvents(EventFilter{Types: []EventType{EventWrite}}, nil)
+	defer cancel()
+	s.WriteTable(2, TableHoldingRegisters, 101, []uint16{4, 5})
+	expected := []WriteEvent{
+		{Slave: 2, Table: TableHoldingRegisters, Address: 101, Values: []uint16{4, 5}},
+		{Slave: 1, Table: TableHoldingRegisters, Address: 1, Values: []uint16{4, 5}},
+	}
+	if len(hooked) != 2 || hooked[1].Slave != 1 || hooked[1].Address != 1 || !slices.Equal(hooked[1].Values, expected[1].Values) {
+		t.Errorf("expected hooks to see the mirrored write, got %+v", hooked)
+	}
+	for _, write := range expected {
+		if event := <-events; *event.Slave != write.Slave || *event.Address != write.Address || !slices.Equal(event.Values, write.Values) {
+			t.Errorf("expected event of %+v, got %+v", write, event)
+		}
+	}
+
+	// A hook rejecting the mirrored write cancels the whole write.
+	s.AddWriteHook(func(event WriteEvent) error {
+		if event.Slave == 1 {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+	if err := s.WriteTable(2, TableHoldingRegisters, 101, []uint16{6}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if values, _ := s.ReadTable(2, TableHoldingRegisters, 101, 1); values[0] != 4 {
+		t.Errorf("expected canceled write, got %v", values)
+	}
+}
+
+func TestAliasWindowRules(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.InitSlave(2)
+	if err := s.AddAliasWindow(AliasWindow{Slave: 2, Table: TableHoldingRegisters, Address: 100, Quantity: 10, Target: 1}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	limit := 10
+	s.AddWriteRule(1, WriteRule{Table: TableHoldingRegisters, Address: 5, Quantity: 1, Max: &limit})
+	s.AddWriteRule(2, WriteRule{Table: TableHoldingRegisters, Address: 100, Quantity: 1, ReadOnly: true})
+
+	// The rules of the other side of the window apply to the mirrored write, and reject it as a whole.
+	if err := s.WriteChecked(2, TableHoldingRegisters, 104, []uint16{1, 11}); err != IllegalDataValue {
+		t.Errorf("expected the rules of slave 1, got %v", err)
+	}
+	if err := s.WriteChecked(1, TableHoldingRegisters, 0, []uint16{1}); err != IllegalDataAddress {
+		t.Errorf("expected the rules of slave 2, got %v", err)
+	}
+	if exception := s.ValidateWrite(2, TableHoldingRegisters, 105, []uint16{11}); exception != &IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception)
+	}
+	if values, _ := s.ReadTable(1, TableHoldingRegisters, 0, 1); values[0] != 0 {
+		t.Errorf("expected no write, got %v", values)
+	}
+	if values, _ := s.ReadTable(2, TableHoldingRegisters, 104, 2); !slices.Equal(values, []uint16{0, 0}) {
+		t.Errorf("expected no write, got %v", values)
+	}
+	if err := s.WriteChecked(2, TableHoldingRegisters, 104, []uint16{1, 10}); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if values, _ := s.ReadTable(1, TableHoldingRegisters, 4, 2); !slices.Equal(values, []uint16{1, 10}) {
+		t.Errorf("expected the mirrored write, got %v", values)
+	}
+}
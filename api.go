@@ -0,0 +1,541 @@
+package modbusserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	// apiKeepalive is the interval of the keepalive messages of the event streams.
+	apiKeepalive    = 15 * time.Second
+	apiWriteTimeout = 10 * time.Second
+)
+
+type (
+	// APISlave is a slave as listed by the REST API.
+	APISlave struct {
+		ID         uint8 `json:"id"`
+		Responding bool  `json:"responding"`
+	}
+	// APIValues is a range of a slave table read or written through the REST API: raw Values, or a typed
+	// Value when Type is set.
+	APIValues struct {
+		Address   uint16    `json:"address"`
+		Values    []uint16  `json:"values,omitempty"`
+		Type      DataType  `json:"type,omitempty"`
+		ByteOrder ByteOrder `json:"byte_order,omitempty"`
+		Value     *float64  `json:"value,omitempty"`
+	}
+	// APIError is the body of the REST API error responses. Exception is set for writes rejected by the
+	// write rules.
+	APIError struct {
+		Error     string     `json:"error"`
+		Exception *Exception `json:"exception,omitempty"`
+	}
+)
+
+// NewAPIHandler returns the HTTP handler of the REST API of the server, under /api/. With a token,
+// requests need the header "Authorization: Bearer <token>", except for the OpenAPI description at
+// /api/openapi.json. The event stream at /api/events also takes the token as the token query parameter,
+// since browsers can't set headers on EventSource and WebSocket connections. The handler enables the
+// server events (see EnableEvents) so that streams can resume.
+func NewAPIHandler(s *Server, token string) http.Handler {
+	s.enableEvents()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(apiDescription))
+	})
+	authorized := func(r *http.Request) bool {
+		return token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+	}
+	unauthorized := func(w http.ResponseWriter) {
+		writeJSON(w, http.StatusUnauthorized, APIError{Error: "invalid or missing token"})
+	}
+	handle := func(pattern string, handler func(r *http.Request) (int, any)) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if !authorized(r) {
+				unauthorized(w)
+				return
+			}
+			status, body := handler(r)
+			writeJSON(w, status, body)
+		})
+	}
+	mux.HandleFunc("GET /api/events", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+			unauthorized(w)
+			return
+		}
+		s.apiEvents(w, r)
+	})
+	handle("GET /api/slaves", s.apiSlaves)
+	handle("PUT /api/slaves/{id}", s.apiCreateSlave)
+	handle("DELETE /api/slaves/{id}", s.apiRemoveSlave)
+	handle("POST /api/slaves/{id}/stop", s.apiSlaveResponse(s.SlaveStopResponse))
+	handle("POST /api/slaves/{id}/start", s.apiSlaveResponse(s.SlaveStartResponse))
+	handle("GET /api/slaves/{id}/{table}", s.apiRead)
+	handle("PUT /api/slaves/{id}/{table}", s.apiWrite)
+	handle("GET /api/listeners", func(r *http.Request) (int, any) {
+		return http.StatusOK, append([]string{}, s.ListenerAddresses()...)
+	})
+	handle("GET /api/connections", func(r *http.Request) (int, any) {
+		return http.StatusOK, s.Connections()
+	})
+	return mux
+}
+
+// ListenAPI serves the REST API on the address until the server is closed. See NewAPIHandler.
+func (s *Server) ListenAPI(address string, token string) error {
+	return s.listenHTTP(address, NewAPIHandler(s, token))
+}
+
+// listenHTTP serves the handler on the address until the server is closed.
+func (s *Server) listenHTTP(address string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	s.httpMutex.Lock()
+	s.httpServers = append(s.httpServers, server)
+	s.httpMutex.Unlock()
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error(fmt.Sprintf("HTTP server %s: %s", address, err.Error()))
+		}
+	}()
+	return nil
+}
+
+// closeHTTP stops the HTTP servers.
+func (s *Server) closeHTTP() {
+	s.httpMutex.Lock()
+	defer s.httpMutex.Unlock()
+	for _, server := range s.httpServers {
+		server.Close()
+	}
+	s.httpServers = nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func apiError(status int, err error) (int, any) {
+	var exception Exception
+	if errors.As(err, &exception) {
+		return status, APIError{Error: exception.String(), Exception: &exception}
+	}
+	return status, APIError{Error: err.Error()}
+}
+
+func apiSlaveID(r *http.Request) (uint8, error) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid slave ID %q", r.PathValue("id"))
+	}
+	return uint8(id), nil
+}
+
+func (s *Server) apiSlaves(r *http.Request) (int, any) {
+	s.memoryMutex.RLock()
+	defer s.memoryMutex.RUnlock()
+	ids := maps.Keys(s.Slaves)
+	slices.Sort(ids)
+	slaves := make([]APISlave, len(ids))
+	for i, id := range ids {
+		slaves[i] = APISlave{ID: id, Responding: !slices.Contains(s.SlavesStoppedResponse, id)}
+	}
+	return http.StatusOK, slaves
+}
+
+func (s *Server) apiCreateSlave(r *http.Request) (int, any) {
+	id, err := apiSlaveID(r)
+	if err != nil {
+		return apiError(http.StatusBadRequest, err)
+	}
+	s.memoryMutex.Lock()
+	_, exists := s.Slaves[id]
+	s.initSlave(id)
+	s.memoryMutex.Unlock()
+	status := http.StatusCreated
+	if exists {
+		status = http.StatusOK
+	}
+	return status, APISlave{ID: id, Responding: s.slaveResponds(id)}
+}
+
+func (s *Server) apiRemoveSlave(r *http.Request) (int, any) {
+	id, err := apiSlaveID(r)
+	if err != nil {
+		return apiError(http.StatusBadRequest, err)
+	}
+	if err = s.RemoveSlave(id); err != nil {
+		return apiError(http.StatusNotFound, err)
+	}
+	return http.StatusNoContent, nil
+}
+
+func (s *Server) apiSlaveResponse(set func(id uint8) error) func(r *http.Request) (int, any) {
+	return func(r *http.Request) (int, any) {
+		id, err := apiSlaveID(r)
+		if err != nil {
+			return apiError(http.StatusBadRequest, err)
+		}
+		if err = set(id); err != nil {
+			return apiError(http.StatusNotFound, err)
+		}
+		return http.StatusOK, APISlave{ID: id, Responding: s.slaveResponds(id)}
+	}
+}
+
+// apiRange parses the slave ID and table of the path, and the address, quantity, type and byte_order
+// query parameters.
+func (s *Server) apiRange(r *http.Request) (id uint8, table Table, values APIValues, quantity int, err error) {
+	if id, err = apiSlaveID(r); err != nil {
+		return
+	}
+	if table, err = ParseTable(r.PathValue("table")); err != nil {
+		return
+	}
+	query := r.URL.Query()
+	if text := query.Get("address"); text != "" {
+		address, parseErr := strconv.ParseUint(text, 10, 16)
+		if parseErr != nil {
+			err = fmt.Errorf("invalid address %q", text)
+			return
+		}
+		values.Address = uint16(address)
+	}
+	quantity = 1
+	if text := query.Get("quantity"); text != "" {
+		if quantity, err = strconv.Atoi(text); err != nil || quantity < 1 {
+			err = fmt.Errorf("invalid quantity %q", text)
+			return
+		}
+	}
+	values.Type, values.ByteOrder = DataType(query.Get("type")), ByteOrder(query.Get("byte_order"))
+	return
+}
+
+func (s *Server) apiRead(r *http.Request) (int, any) {
+	id, table, values, quantity, err := s.apiRange(r)
+	if err != nil {
+		return apiError(http.StatusBadRequest, err)
+	}
+	if values.Type != "" {
+		value, err := s.ReadValue(id, table, values.Address, values.Type, values.ByteOrder)
+		if err != nil {
+			return apiError(http.StatusBadRequest, err)
+		}
+		values.Value = &value
+		return http.StatusOK, values
+	}
+	if values.Values, err = s.ReadTable(id, table, values.Address, quantity); err != nil {
+		return apiError(http.StatusBadRequest, err)
+	}
+	return http.StatusOK, values
+}
+
+// apiWrite writes the body values at the address of the body, or of the query if the body has none,
+// and likewise for the type and byte order of a typed value.
+// With the query parameter checked=true, the write rules apply as to Modbus masters.
+func (s *Server) apiWrite(r *http.Request) (int, any) {
+	id, table, query, _, err := s.apiRange(r)
+	if err != nil {
+		return apiError(http.StatusBadRequest, err)
+	}
+	values := APIValues{Address: query.Address, Type: query.Type, ByteOrder: query.ByteOrder}
+	if err = json.NewDecoder(r.Body).Decode(&values); err != nil {
+		return apiError(http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+	}
+	registers := values.Values
+	if values.Value != nil {
+		if values.Type == "" {
+			values.Type = TypeUint16
+			if table == TableCoils || table == TableDiscreteInputs {
+				values.Type = TypeBool
+			}
+		}
+		if err = checkBitType(table, values.Type); err != nil {
+			return apiError(http.StatusBadRequest, err)
+		}
+		if registers, err = EncodeValue(*values.Value, values.Type, values.ByteOrder); err != nil {
+			return apiError(http.StatusBadRequest, err)
+		}
+	}
+	if len(registers) == 0 {
+		return apiError(http.StatusBadRequest, errors.New("no values"))
+	}
+	if r.URL.Query().Get("checked") == "true" {
+		err = s.WriteChecked(id, table, values.Address, registers)
+	} else {
+		err = s.WriteTable(id, table, values.Address, registers)
+	}
+	if errors.As(err, new(Exception)) {
+		return apiError(http.StatusUnprocessableEntity, err)
+	} else if err != nil {
+		return apiError(http.StatusBadRequest, err)
+	}
+	return http.StatusOK, APIValues{Address: values.Address, Values: registers}
+}
+
+// apiEventFilter parses the types, slave, table, address and quantity query parameters, and the
+// sequence number to resume from, given by the since query parameter or the Last-Event-ID header.
+func apiEventFilter(r *http.Request) (filter EventFilter, since *uint64, err error) {
+	query := r.URL.Query()
+	if text := query.Get("types"); text != "" {
+		for _, name := range strings.Split(text, ",") {
+			filter.Types = append(filter.Types, EventType(name))
+		}
+	}
+	if text := query.Get("slave"); text != "" {
+		id, parseErr := strconv.ParseUint(text, 10, 8)
+		if parseErr != nil {
+			return filter, nil, fmt.Errorf("invalid slave ID %q", text)
+		}
+		slave := uint8(id)
+		filter.Slave = &slave
+	}
+	if text := query.Get("table"); text != "" {
+		table, parseErr := ParseTable(text)
+		if parseErr != nil {
+			return filter, nil, parseErr
+		}
+		filter.Table = &table
+	}
+	if text := query.Get("address"); text != "" {
+		address, parseErr := strconv.ParseUint(text, 10, 16)
+		if parseErr != nil {
+			return filter, nil, fmt.Errorf("invalid address %q", text)
+		}
+		filter.Address = uint16(address)
+	}
+	if text := query.Get("quantity"); text != "" {
+		if filter.Quantity, err = strconv.Atoi(text); err != nil || filter.Quantity < 1 {
+			return filter, nil, fmt.Errorf("invalid quantity %q", text)
+		}
+	}
+	text := r.Header.Get("Last-Event-ID")
+	if text == "" {
+		text = query.Get("since")
+	}
+	if text != "" {
+		sequence, parseErr := strconv.ParseUint(text, 10, 64)
+		if parseErr != nil {
+			return filter, nil, fmt.Errorf("invalid sequence number %q", text)
+		}
+		since = &sequence
+	}
+	return
+}
+
+// apiEvents streams the server events as JSON, over a WebSocket connection if requested, otherwise as
+// Server-Sent Events. The stream ends when events are lost; the client then resumes from the last
+// sequence number it got.
+func (s *Server) apiEvents(w http.ResponseWriter, r *http.Request) {
+	filter, since, err := apiEventFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		s.apiEventsWebSocket(w, r, filter, since)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, APIError{Error: "streaming unsupported"})
+		return
+	}
+	events, cancel := s.SubscribeEvents(filter, since)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepalive := time.NewTicker(apiKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			w.Write([]byte(": keepalive\n\n"))
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(event)
+			if event.Sequence != 0 {
+				fmt.Fprintf(w, "id: %d\n", event.Sequence)
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *Server) apiEventsWebSocket(w http.ResponseWriter, r *http.Request, filter EventFilter, since *uint64) {
+	// The token authenticates the clients, so any origin is accepted.
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	events, cancel := s.SubscribeEvents(filter, since)
+	defer cancel()
+	// Reading handles the control messages and detects the closing of the connection.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	keepalive := time.NewTicker(apiKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-keepalive.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(apiWriteTimeout)) != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(apiWriteTimeout))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(apiWriteTimeout))
+			if conn.WriteJSON(event) != nil {
+				return
+			}
+		}
+	}
+}
+
+// apiDescription is the OpenAPI description of the REST API.
+const apiDescription = `{
+  "openapi": "3.0.3",
+  "info": {"title": "Modbus server REST API", "version": "1.0.0"},
+  "components": {
+    "securitySchemes": {"token": {"type": "http", "scheme": "bearer"}},
+    "parameters": {
+      "id": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 0, "maximum": 255}},
+      "table": {"name": "table", "in": "path", "required": true,
+        "schema": {"type": "string", "enum": ["coils", "discrete_inputs", "holding_registers", "input_registers"]}},
+      "address": {"name": "address", "in": "query", "schema": {"type": "integer", "minimum": 0, "maximum": 65535, "default": 0}},
+      "type": {"name": "type", "in": "query", "schema": {"$ref": "#/components/schemas/DataType"}},
+      "byte_order": {"name": "byte_order", "in": "query", "schema": {"$ref": "#/components/schemas/ByteOrder"}}
+    },
+    "schemas": {
+      "DataType": {"type": "string", "enum": ["bool", "uint16", "int16", "uint32", "int32", "float32", "uint64", "int64", "float64"]},
+      "ByteOrder": {"type": "string", "enum": ["ABCD", "CDAB", "BADC", "DCBA"]},
+      "Slave": {"type": "object", "properties": {"id": {"type": "integer"}, "responding": {"type": "boolean"}}},
+      "Values": {"type": "object", "properties": {
+        "address": {"type": "integer"},
+        "values": {"type": "array", "items": {"type": "integer", "minimum": 0, "maximum": 65535}},
+        "type": {"$ref": "#/components/schemas/DataType"},
+        "byte_order": {"$ref": "#/components/schemas/ByteOrder"},
+        "value": {"type": "number"}}},
+      "Connection": {"type": "object", "properties": {"listener": {"type": "string"}, "remote": {"type": "string"}}},
+      "Error": {"type": "object", "properties": {"error": {"type": "string"}, "exception": {"type": "integer"}}},
+      "Event": {"type": "object", "properties": {
+        "sequence": {"type": "integer"},
+        "time": {"type": "string", "format": "date-time"},
+        "type": {"type": "string", "enum": ["write", "connect", "disconnect", "slave_start", "slave_stop", "lost"]},
+        "slave": {"type": "integer"},
+        "table": {"type": "string"},
+        "address": {"type": "integer"},
+        "values": {"type": "array", "items": {"type": "integer"}},
+        "listener": {"type": "string"},
+        "remote": {"type": "string"}}}
+    },
+    "responses": {
+      "Slave": {"description": "The slave", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Slave"}}}},
+      "Values": {"description": "The values", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Values"}}}},
+      "Error": {"description": "The error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    }
+  },
+  "security": [{"token": []}],
+  "paths": {
+    "/api/slaves": {
+      "get": {"summary": "List the slaves", "responses": {"200": {"description": "The slaves",
+        "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Slave"}}}}}}}
+    },
+    "/api/slaves/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "put": {"summary": "Create the slave", "responses": {"200": {"$ref": "#/components/responses/Slave"}, "201": {"$ref": "#/components/responses/Slave"}, "400": {"$ref": "#/components/responses/Error"}}},
+      "delete": {"summary": "Remove the slave", "responses": {"204": {"description": "Removed"}, "404": {"$ref": "#/components/responses/Error"}}}
+    },
+    "/api/slaves/{id}/stop": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "post": {"summary": "Stop responding to requests for the slave", "responses": {"200": {"$ref": "#/components/responses/Slave"}, "404": {"$ref": "#/components/responses/Error"}}}
+    },
+    "/api/slaves/{id}/start": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "post": {"summary": "Respond to requests for the slave again", "responses": {"200": {"$ref": "#/components/responses/Slave"}, "404": {"$ref": "#/components/responses/Error"}}}
+    },
+    "/api/slaves/{id}/{table}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}, {"$ref": "#/components/parameters/table"}],
+      "get": {"summary": "Read raw values, or a typed value with the type parameter",
+        "parameters": [{"$ref": "#/components/parameters/address"},
+          {"name": "quantity", "in": "query", "schema": {"type": "integer", "minimum": 1, "default": 1}},
+          {"$ref": "#/components/parameters/type"}, {"$ref": "#/components/parameters/byte_order"}],
+        "responses": {"200": {"$ref": "#/components/responses/Values"}, "400": {"$ref": "#/components/responses/Error"}}},
+      "put": {"summary": "Write raw values, or a typed value, at the address of the body or of the query",
+        "parameters": [{"$ref": "#/components/parameters/address"},
+          {"name": "checked", "in": "query", "description": "Apply the write rules of Modbus masters", "schema": {"type": "boolean"}}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Values"}}}},
+        "responses": {"200": {"$ref": "#/components/responses/Values"}, "400": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}}}
+    },
+    "/api/listeners": {
+      "get": {"summary": "List the TCP listener addresses", "responses": {"200": {"description": "The addresses",
+        "content": {"application/json": {"schema": {"type": "array", "items": {"type": "string"}}}}}}}
+    },
+    "/api/connections": {
+      "get": {"summary": "List the open TCP connections", "responses": {"200": {"description": "The connections",
+        "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Connection"}}}}}}}
+    },
+    "/api/events": {
+      "get": {"summary": "Stream the events as Server-Sent Events, or over a WebSocket connection when upgraded",
+        "parameters": [
+          {"name": "types", "in": "query", "description": "Comma-separated event types",
+            "schema": {"type": "string", "example": "write,connect,disconnect,slave_start,slave_stop"}},
+          {"name": "slave", "in": "query", "schema": {"type": "integer", "minimum": 0, "maximum": 255}},
+          {"name": "table", "in": "query", "schema": {"type": "string", "enum": ["coils", "discrete_inputs", "holding_registers", "input_registers"]}},
+          {"$ref": "#/components/parameters/address"},
+          {"name": "quantity", "in": "query", "description": "Select the writes overlapping the range", "schema": {"type": "integer", "minimum": 1}},
+          {"name": "since", "in": "query", "description": "Resume after the sequence number, as the Last-Event-ID header", "schema": {"type": "integer"}},
+          {"name": "token", "in": "query", "description": "The token, for clients unable to set headers", "schema": {"type": "string"}}],
+        "responses": {"200": {"description": "The events", "content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/Event"}}}},
+          "400": {"$ref": "#/components/responses/Error"}}}
+    },
+    "/api/openapi.json": {
+      "get": {"summary": "This description", "security": [], "responses": {"200": {"description": "The OpenAPI description"}}}
+    }
+  }
+}
+`
@@ -0,0 +1,213 @@
+package modbusserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAPI(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.AddWriteRule(1, WriteRule{Table: TableHoldingRegisters, Address: 0, Quantity: 1, ReadOnly: true})
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	server := httptest.NewServer(NewAPIHandler(s, "secret"))
+	defer server.Close()
+
+	call := func(method string, path string, body string, token string, expected int, result any) {
+		t.Helper()
+		request, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s %s: expected nil, got %v", method, path, err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != expected {
+			content, _ := io.ReadAll(response.Body)
+			t.Errorf("%s %s: expected status %d, got %d: %s", method, path, expected, response.StatusCode, content)
+			return
+		}
+		if result != nil {
+			if err = json.NewDecoder(response.Body).Decode(result); err != nil {
+				t.Errorf("%s %s: invalid body: %v", method, path, err)
+			}
+		}
+	}
+
+	call("GET", "/api/slaves", "", "", http.StatusUnauthorized, nil)
+	call("GET", "/api/slaves", "", "wrong", http.StatusUnauthorized, nil)
+	var description map[string]any
+	call("GET", "/api/openapi.json", "", "", http.StatusOK, &description)
+	if description["openapi"] != "3.0.3" || len(description["paths"].(map[string]any)) != 9 {
+		t.Errorf("expected OpenAPI description, got %v", description)
+	}
+
+	call("PUT", "/api/slaves/3", "", "secret", http.StatusCreated, nil)
+	call("PUT", "/api/slaves/3", "", "secret", http.StatusOK, nil)
+	call("PUT", "/api/slaves/300", "", "secret", http.StatusBadRequest, nil)
+	call("POST", "/api/slaves/3/stop", "", "secret", http.StatusOK, nil)
+	var slaves []APISlave
+	call("GET", "/api/slaves", "", "secret", http.StatusOK, &slaves)
+	if !slices.Equal(slaves, []APISlave{{1, true}, {3, false}}) {
+		t.Errorf("expected slaves 1 and stopped 3, got %v", slaves)
+	}
+	call("POST", "/api/slaves/3/start", "", "secret", http.StatusOK, nil)
+	if !s.slaveResponds(3) {
+		t.Errorf("expected slave 3 responding")
+	}
+	call("DELETE", "/api/slaves/3", "", "secret", http.StatusNoContent, nil)
+	call("DELETE", "/api/slaves/3", "", "secret", http.StatusNotFound, nil)
+
+	var values APIValues
+	call("PUT", "/api/slaves/1/holding_registers?address=10", `{"values": [1, 2, 3]}`, "secret", http.StatusOK, nil)
+	call("GET", "/api/slaves/1/holding_registers?address=10&quantity=3", "", "secret", http.StatusOK, &values)
+	if values.Address != 10 || !slices.Equal(values.Values, []uint16{1, 2, 3}) {
+		t.Errorf("expected written registers, got %+v", values)
+	}
+	call("PUT", "/api/slaves/1/input_registers", `{"address": 4, "type": "float32", "byte_order": "CDAB", "value": 1.5}`, "secret", http.StatusOK, nil)
+	values = APIValues{}
+	call("GET", "/api/slaves/1/input_registers?address=4&type=float32&byte_order=CDAB", "", "secret", http.StatusOK, &values)
+	if values.Value == nil || *values.Value != 1.5 {
+		t.Errorf("expected typed value 1.5, got %+v", values)
+	}
+	call("PUT", "/api/slaves/1/coils?address=2", `{"value": 1}`, "secret", http.StatusOK, nil)
+	if coils, _ := s.ReadTable(1, TableCoils, 2, 1); coils[0] != 1 {
+		t.Errorf("expected coil written, got %v", coils)
+	}
+
+	var apiErr APIError
+	call("PUT", "/api/slaves/1/holding_registers?checked=true", `{"values": [5]}`, "secret", http.StatusUnprocessableEntity, &apiErr)
+	if apiErr.Exception == nil || *apiErr.Exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %+v", apiErr)
+	}
+	call("PUT", "/api/slaves/1/holding_registers", `{"values": [5]}`, "secret", http.StatusOK, nil)
+	call("GET", "/api/slaves/1/outputs", "", "secret", http.StatusBadRequest, nil)
+	call("GET", "/api/slaves/1/coils?address=65535&quantity=2", "", "secret", http.StatusBadRequest, nil)
+	call("PUT", "/api/slaves/1/coils", `{"values": []}`, "secret", http.StatusBadRequest, nil)
+
+	var listeners []string
+	call("GET", "/api/listeners", "", "secret", http.StatusOK, &listeners)
+	if !slices.Equal(listeners, []string{addr}) {
+		t.Errorf("expected %v, got %v", addr, listeners)
+	}
+	var connections []ConnectionInfo
+	call("GET", "/api/connections", "", "secret", http.StatusOK, &connections)
+}
+
+func TestListenAPI(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	addr := getFreePort()
+	if err := s.ListenAPI(addr, ""); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	response, err := http.Get("http://" + addr + "/api/slaves")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("expected slaves without token, got %v, %v", response, err)
+	}
+	response.Body.Close()
+	s.Close()
+	if _, err = http.Get("http://" + addr + "/api/slaves"); err == nil {
+		t.Errorf("expected API closed with the server")
+	}
+}
+
+// readSSE returns the lines of the next Server-Sent Event, skipping the comments.
+func readSSE(t *testing.T, reader *bufio.Reader) (lines []string) {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("expected an event, got %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(lines) != 0:
+			return
+		case line != "" && !strings.HasPrefix(line, ":"):
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestAPIEvents(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.InitSlave(2)
+	server := httptest.NewServer(NewAPIHandler(s, "secret"))
+	defer server.Close()
+
+	if response, err := http.Get(server.URL + "/api/events"); err != nil || response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized, got %v, %v", response, err)
+	}
+	if response, err := http.Get(server.URL + "/api/events?token=secret&slave=300"); err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %v, %v", response, err)
+	}
+	response, err := http.Get(server.URL + "/api/events?token=secret&slave=1&table=holding_registers&address=10&quantity=2")
+	if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected event stream, got %v, %v", response, err)
+	}
+	reader := bufio.NewReader(response.Body)
+	s.WriteTable(2, TableHoldingRegisters, 10, []uint16{1})
+	s.WriteTable(1, TableHoldingRegisters, 0, []uint16{1})
+	s.WriteTable(1, TableHoldingRegisters, 11, []uint16{7})
+	s.WriteTable(1, TableHoldingRegisters, 12, []uint16{8})
+	s.WriteTable(1, TableHoldingRegisters, 10, []uint16{9})
+	lines := readSSE(t, reader)
+	var event Event
+	if len(lines) != 3 || lines[0] != "id: 3" || lines[1] != "event: write" ||
+		json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event) != nil || *event.Address != 11 || event.Values[0] != 7 {
+		t.Errorf("expected write event 3, got %v", lines)
+	}
+	if lines = readSSE(t, reader); lines[0] != "id: 5" {
+		t.Errorf("expected write event 5, got %v", lines)
+	}
+	response.Body.Close()
+
+	// Resuming with the Last-Event-ID header replays the missed events.
+	request, _ := http.NewRequest("GET", server.URL+"/api/events?slave=2", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Header.Set("Last-Event-ID", "0")
+	if response, err = http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if lines = readSSE(t, bufio.NewReader(response.Body)); lines[0] != "id: 1" {
+		t.Errorf("expected replayed event 1, got %v", lines)
+	}
+	response.Body.Close()
+
+	header := http.Header{"Authorization": {"Bearer secret"}}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/events?types=slave_stop,slave_start&since=4"
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("expected WebSocket connection, got %v", err)
+	}
+	defer conn.Close()
+	s.WriteTable(1, TableCoils, 0, []uint16{1})
+	s.SlaveStopResponse(2)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err = conn.ReadJSON(&event); err != nil || event.Sequence != 7 || event.Type != EventSlaveStop || *event.Slave != 2 {
+		t.Errorf("expected slave stop event, got %+v, %v", event, err)
+	}
+	s.Close()
+	var closeErr *websocket.CloseError
+	if _, _, err = conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("expected stream closed with the server, got %v", err)
+	}
+}
@@ -186,7 +186,7 @@ func ExampleServer_RegisterFunctionHandler() {
 	// Override ReadDiscreteInputs function.
 	serv.RegisterFunctionHandler(2,
 		func(s *Server, frame Framer) ([]byte, *Exception) {
-			register, numRegs, endRegister := registerAddressAndNumber(frame)
+			_, numRegs, endRegister := registerAddressAndNumber(frame)
 			// Check the request is within the allocated memory
 			if endRegister > 65535 {
 				return []byte{}, &IllegalDataAddress
@@ -197,7 +197,7 @@ func ExampleServer_RegisterFunctionHandler() {
 			}
 			data := make([]byte, 1+dataSize)
 			data[0] = byte(dataSize)
-			for i := range s.Slaves[frame.GetSlaveId()].DiscreteInputs[register:endRegister] {
+			for i := range numRegs {
 				// Return all 1s, regardless of the value in the DiscreteInputs array.
 				shift := uint(i) % 8
 				data[1+i/8] |= byte(1 << shift)
@@ -0,0 +1,379 @@
+package modbusserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// pcapng block types, link types and options.
+const (
+	pcapngSectionHeader      = 0x0A0D0D0A
+	pcapngInterface          = 0x00000001
+	pcapngEnhancedPacket     = 0x00000006
+	pcapngByteOrderMagic     = 0x1A2B3C4D
+	pcapngOptionEnd          = 0
+	pcapngOptionName         = 2
+	pcapngOptionTimestampRes = 9
+
+	// linkTypeRaw carries IPv4 or IPv6 packets, used for Modbus TCP with synthesized TCP/IP headers.
+	linkTypeRaw = 101
+	// linkTypeUpperPDU carries Wireshark exported PDUs, used for RTU frames to name the Modbus RTU
+	// dissector.
+	linkTypeUpperPDU = 252
+
+	captureInterfaceTCP = 0
+	captureInterfaceRTU = 1
+)
+
+// Wireshark exported PDU tags.
+const (
+	exportedPDUEnd       = 0
+	exportedPDUProtoName = 12
+	exportedPDUIPv4Src   = 20
+	exportedPDUIPv4Dst   = 21
+	exportedPDUIPv6Src   = 22
+	exportedPDUIPv6Dst   = 23
+	exportedPDUPortType  = 24
+	exportedPDUSrcPort   = 25
+	exportedPDUDstPort   = 26
+	exportedPDUPortTCP   = 2
+)
+
+const (
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+type (
+	// capture writes the traffic of a listener or serial device in pcapng format.
+	capture struct {
+		writer  io.Writer
+		closer  io.Closer
+		streams map[string]*tcpStream
+		ipID    uint16
+	}
+	// tcpStream holds the sequence numbers of a synthesized TCP connection.
+	tcpStream struct {
+		clientSeq, serverSeq uint32
+	}
+)
+
+// StartCapture records every request and response of the listener or serial device with the address (see
+// ListenerAddresses) to w in pcapng format. Modbus TCP traffic gets synthesized TCP/IP headers with the
+// client and server addresses; RTU traffic is recorded as exported PDUs decoded by Wireshark's Modbus RTU
+// dissector.
+func (s *Server) StartCapture(address string, w io.Writer) error {
+	return s.startCapture(address, w, nil)
+}
+
+// StartCaptureFile records the traffic of the listener or serial device to a new pcapng file.
+func (s *Server) StartCaptureFile(address string, path string) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	if err = s.startCapture(address, file, file); err != nil {
+		file.Close()
+	}
+	return
+}
+
+func (s *Server) startCapture(address string, w io.Writer, closer io.Closer) (err error) {
+	s.captureMutex.Lock()
+	defer s.captureMutex.Unlock()
+	if _, ok := s.captures[address]; ok {
+		return fmt.Errorf("traffic of %s is already captured", address)
+	}
+	c := &capture{writer: w, closer: closer, streams: make(map[string]*tcpStream)}
+	if err = c.writeHeader(address); err != nil {
+		return
+	}
+	s.captures[address] = c
+	return
+}
+
+// StopCapture stops recording the traffic of the listener or serial device, closing the file of
+// StartCaptureFile.
+func (s *Server) StopCapture(address string) (err error) {
+	s.captureMutex.Lock()
+	defer s.captureMutex.Unlock()
+	c, ok := s.captures[address]
+	if !ok {
+		return
+	}
+	delete(s.captures, address)
+	if c.closer != nil {
+		err = c.closer.Close()
+	}
+	return
+}
+
+// StopCaptures stops all captures.
+func (s *Server) StopCaptures() (err error) {
+	s.captureMutex.Lock()
+	addresses := make([]string, 0, len(s.captures))
+	for address := range s.captures {
+		addresses = append(addresses, address)
+	}
+	s.captureMutex.Unlock()
+	for _, address := range addresses {
+		err = errors.Join(err, s.StopCapture(address))
+	}
+	return
+}
+
+// capturePacket records a packet of the request's connection, sent by the client if fromClient is true.
+func (s *Server) capturePacket(request *Request, packet []byte, fromClient bool) {
+	s.captureMutex.Lock()
+	defer s.captureMutex.Unlock()
+	c, ok := s.captures[request.address]
+	if !ok {
+		return
+	}
+	var client, server netip.AddrPort
+	if conn, ok := request.conn.(net.Conn); ok {
+		client, server = addrPort(conn.RemoteAddr()), addrPort(conn.LocalAddr())
+	}
+	var err error
+	if _, ok := request.frame.(*TCPFrame); ok && client.IsValid() && server.IsValid() {
+		err = c.writeTCP(client, server, packet, fromClient)
+	} else {
+		src, dst := client, server
+		if !fromClient {
+			src, dst = server, client
+		}
+		err = c.writeBlock(pcapngEnhancedPacket, c.enhancedPacket(captureInterfaceRTU, exportedPDU("mbrtu", src, dst, packet)))
+	}
+	if err != nil {
+		s.captureFailed(request.address, c, err)
+	}
+}
+
+// captureClose records the end of the TCP connection of the client to the listener with the address and
+// forgets its stream.
+func (s *Server) captureClose(address string, clientAddr net.Addr, serverAddr net.Addr) {
+	s.captureMutex.Lock()
+	defer s.captureMutex.Unlock()
+	c, ok := s.captures[address]
+	if !ok {
+		return
+	}
+	client, server := addrPort(clientAddr), addrPort(serverAddr)
+	if err := c.closeTCP(client, server); err != nil {
+		s.captureFailed(address, c, err)
+	}
+}
+
+// captureFailed stops the capture of the address after a write error. The capture mutex must be held.
+func (s *Server) captureFailed(address string, c *capture, err error) {
+	s.logger.Error(fmt.Sprintf("Server %s: error on capturing traffic: %s", address, err.Error()))
+	delete(s.captures, address)
+	if c.closer != nil {
+		c.closer.Close()
+	}
+}
+
+func addrPort(addr net.Addr) netip.AddrPort {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		addrPort := tcpAddr.AddrPort()
+		return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+	}
+	return netip.AddrPort{}
+}
+
+// writeHeader writes the section header and the interfaces for Modbus TCP and RTU traffic.
+func (c *capture) writeHeader(address string) (err error) {
+	header := binary.LittleEndian.AppendUint32(nil, pcapngByteOrderMagic)
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint16(header, 0)
+	header = binary.LittleEndian.AppendUint64(header, 0xFFFFFFFFFFFFFFFF)
+	if err = c.writeBlock(pcapngSectionHeader, header); err != nil {
+		return
+	}
+	for _, linkType := range []uint16{linkTypeRaw, linkTypeUpperPDU} {
+		description := binary.LittleEndian.AppendUint16(nil, linkType)
+		description = binary.LittleEndian.AppendUint16(description, 0)
+		description = binary.LittleEndian.AppendUint32(description, 0)
+		description = appendOption(description, pcapngOptionName, []byte(address))
+		description = appendOption(description, pcapngOptionTimestampRes, []byte{9})
+		description = appendOption(description, pcapngOptionEnd, nil)
+		if err = c.writeBlock(pcapngInterface, description); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// writeTCP writes the packet with synthesized TCP/IP headers, preceded by a handshake for a new connection.
+func (c *capture) writeTCP(client netip.AddrPort, server netip.AddrPort, payload []byte, fromClient bool) (err error) {
+	stream, ok := c.streams[client.String()]
+	if !ok {
+		stream = &tcpStream{}
+		c.streams[client.String()] = stream
+		for _, segment := range []struct {
+			fromClient bool
+			flags      uint8
+		}{{true, tcpFlagSYN}, {false, tcpFlagSYN | tcpFlagACK}, {true, tcpFlagACK}} {
+			if err = c.writeSegment(client, server, stream, segment.flags, nil, segment.fromClient); err != nil {
+				return
+			}
+		}
+	}
+	return c.writeSegment(client, server, stream, tcpFlagPSH|tcpFlagACK, payload, fromClient)
+}
+
+// closeTCP writes the FIN segments ending the connection of the client and removes its stream.
+func (c *capture) closeTCP(client netip.AddrPort, server netip.AddrPort) (err error) {
+	stream, ok := c.streams[client.String()]
+	if !ok {
+		return
+	}
+	delete(c.streams, client.String())
+	for _, segment := range []struct {
+		fromClient bool
+		flags      uint8
+	}{{true, tcpFlagFIN | tcpFlagACK}, {false, tcpFlagFIN | tcpFlagACK}, {true, tcpFlagACK}} {
+		if err = c.writeSegment(client, server, stream, segment.flags, nil, segment.fromClient); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (c *capture) writeSegment(client netip.AddrPort, server netip.AddrPort, stream *tcpStream, flags uint8, payload []byte, fromClient bool) error {
+	src, dst, seq, ack := client, server, &stream.clientSeq, stream.serverSeq
+	if !fromClient {
+		src, dst, seq, ack = server, client, &stream.serverSeq, stream.clientSeq
+	}
+	if flags&tcpFlagACK == 0 {
+		ack = 0
+	}
+	segment := binary.BigEndian.AppendUint16(nil, src.Port())
+	segment = binary.BigEndian.AppendUint16(segment, dst.Port())
+	segment = binary.BigEndian.AppendUint32(segment, *seq)
+	segment = binary.BigEndian.AppendUint32(segment, ack)
+	segment = append(segment, 5<<4, flags)
+	segment = binary.BigEndian.AppendUint16(segment, 65535)
+	segment = append(segment, 0, 0, 0, 0)
+	segment = append(segment, payload...)
+	binary.BigEndian.PutUint16(segment[16:18], tcpChecksum(src.Addr(), dst.Addr(), segment))
+	*seq += uint32(len(payload))
+	if flags&(tcpFlagSYN|tcpFlagFIN) != 0 {
+		*seq++
+	}
+	c.ipID++
+	return c.writeBlock(pcapngEnhancedPacket, c.enhancedPacket(captureInterfaceTCP, ipPacket(src.Addr(), dst.Addr(), c.ipID, segment)))
+}
+
+func (c *capture) enhancedPacket(interfaceID uint32, packet []byte) []byte {
+	timestamp := uint64(time.Now().UnixNano())
+	block := binary.LittleEndian.AppendUint32(nil, interfaceID)
+	block = binary.LittleEndian.AppendUint32(block, uint32(timestamp>>32))
+	block = binary.LittleEndian.AppendUint32(block, uint32(timestamp))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(packet)))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(packet)))
+	return append(block, pad4(packet)...)
+}
+
+// writeBlock writes a pcapng block with the body padded to 32 bits.
+func (c *capture) writeBlock(blockType uint32, body []byte) error {
+	body = pad4(body)
+	length := uint32(len(body) + 12)
+	block := binary.LittleEndian.AppendUint32(nil, blockType)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	_, err := c.writer.Write(block)
+	return err
+}
+
+func appendOption(options []byte, code uint16, value []byte) []byte {
+	options = binary.LittleEndian.AppendUint16(options, code)
+	options = binary.LittleEndian.AppendUint16(options, uint16(len(value)))
+	return append(options, pad4(value)...)
+}
+
+func pad4(data []byte) []byte {
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// exportedPDU wraps the packet in Wireshark exported PDU tags naming the dissector and, if known, the
+// TCP endpoints.
+func exportedPDU(dissector string, src netip.AddrPort, dst netip.AddrPort, packet []byte) []byte {
+	// Exported PDU tags are big endian, with the value padded to 32 bits and the padding counted in
+	// the length.
+	appendTag := func(pdu []byte, tag uint16, value []byte) []byte {
+		value = pad4(value)
+		pdu = binary.BigEndian.AppendUint16(pdu, tag)
+		pdu = binary.BigEndian.AppendUint16(pdu, uint16(len(value)))
+		return append(pdu, value...)
+	}
+	pdu := appendTag(nil, exportedPDUProtoName, []byte(dissector))
+	if src.IsValid() && dst.IsValid() {
+		srcTag, dstTag := uint16(exportedPDUIPv4Src), uint16(exportedPDUIPv4Dst)
+		if src.Addr().Is6() {
+			srcTag, dstTag = exportedPDUIPv6Src, exportedPDUIPv6Dst
+		}
+		pdu = appendTag(pdu, srcTag, src.Addr().AsSlice())
+		pdu = appendTag(pdu, dstTag, dst.Addr().AsSlice())
+		pdu = appendTag(pdu, exportedPDUPortType, binary.BigEndian.AppendUint32(nil, exportedPDUPortTCP))
+		pdu = appendTag(pdu, exportedPDUSrcPort, binary.BigEndian.AppendUint32(nil, uint32(src.Port())))
+		pdu = appendTag(pdu, exportedPDUDstPort, binary.BigEndian.AppendUint32(nil, uint32(dst.Port())))
+	}
+	pdu = appendTag(pdu, exportedPDUEnd, nil)
+	return append(pdu, packet...)
+}
+
+// ipPacket returns an IPv4 or IPv6 packet carrying the TCP segment.
+func ipPacket(src netip.Addr, dst netip.Addr, id uint16, segment []byte) []byte {
+	if src.Is6() {
+		packet := []byte{0x60, 0, 0, 0}
+		packet = binary.BigEndian.AppendUint16(packet, uint16(len(segment)))
+		packet = append(packet, 6, 64)
+		packet = append(packet, src.AsSlice()...)
+		packet = append(packet, dst.AsSlice()...)
+		return append(packet, segment...)
+	}
+	packet := []byte{0x45, 0}
+	packet = binary.BigEndian.AppendUint16(packet, uint16(20+len(segment)))
+	packet = binary.BigEndian.AppendUint16(packet, id)
+	packet = append(packet, 0x40, 0, 64, 6, 0, 0)
+	packet = append(packet, src.AsSlice()...)
+	packet = append(packet, dst.AsSlice()...)
+	binary.BigEndian.PutUint16(packet[10:12], ^internetSum(0, packet))
+	return append(packet, segment...)
+}
+
+// tcpChecksum returns the TCP checksum of the segment, including the pseudo header.
+func tcpChecksum(src netip.Addr, dst netip.Addr, segment []byte) uint16 {
+	pseudo := append(src.AsSlice(), dst.AsSlice()...)
+	pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(segment)))
+	pseudo = binary.BigEndian.AppendUint32(pseudo, 6)
+	return ^internetSum(internetSum(0, pseudo), segment)
+}
+
+// internetSum adds the data to the ones' complement sum of RFC 1071.
+func internetSum(sum uint16, data []byte) uint16 {
+	total := uint32(sum)
+	for i := 0; i+1 < len(data); i += 2 {
+		total += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		total += uint32(data[len(data)-1]) << 8
+	}
+	for total > 0xFFFF {
+		total = total>>16 + total&0xFFFF
+	}
+	return uint16(total)
+}
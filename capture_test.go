@@ -0,0 +1,185 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+type pcapngBlock struct {
+	blockType uint32
+	body      []byte
+}
+
+func readPcapngBlocks(t *testing.T, data []byte) (blocks []pcapngBlock) {
+	for len(data) != 0 {
+		length := binary.LittleEndian.Uint32(data[4:8])
+		if length%4 != 0 || int(length) > len(data) || binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatalf("invalid block length %d", length)
+		}
+		blocks = append(blocks, pcapngBlock{binary.LittleEndian.Uint32(data[0:4]), data[8 : length-4]})
+		data = data[length:]
+	}
+	return
+}
+
+// enhancedPacketData returns the interface and the packet of an enhanced packet block.
+func enhancedPacketData(block pcapngBlock) (uint32, []byte) {
+	return binary.LittleEndian.Uint32(block.body[0:4]), block.body[20 : 20+binary.LittleEndian.Uint32(block.body[12:16])]
+}
+
+func TestCaptureTCP(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	var buffer bytes.Buffer
+	if err := s.StartCapture(addr, &buffer); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := s.StartCapture(addr, &buffer); err == nil {
+		t.Errorf("expected already captured error, got nil")
+	}
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.SlaveId = 1
+	handler.Timeout = time.Second
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	if _, err := modbus.NewClient(handler).ReadHoldingRegisters(0, 2); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	s.StopCapture(addr)
+
+	blocks := readPcapngBlocks(t, buffer.Bytes())
+	if len(blocks) != 8 || blocks[0].blockType != pcapngSectionHeader || blocks[1].blockType != pcapngInterface ||
+		binary.LittleEndian.Uint16(blocks[1].body) != linkTypeRaw || binary.LittleEndian.Uint16(blocks[2].body) != linkTypeUpperPDU {
+		t.Fatalf("expected header, 2 interfaces, handshake, request and response, got %d blocks", len(blocks))
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	serverPort, _ := strconv.Atoi(port)
+	for i, fromClient := range []bool{true, false, true, true, false} {
+		interfaceID, packet := enhancedPacketData(blocks[3+i])
+		if interfaceID != captureInterfaceTCP || packet[0] != 0x45 || internetSum(0, packet[:20]) != 0xFFFF {
+			t.Fatalf("packet %d: expected valid IPv4 header, got %v", i, packet)
+		}
+		segment := packet[20:]
+		pseudo := append(append([]byte{}, packet[12:20]...), 0, 6, 0, byte(len(segment)))
+		if internetSum(internetSum(0, pseudo), segment) != 0xFFFF {
+			t.Errorf("packet %d: invalid TCP checksum", i)
+		}
+		srcPort, dstPort := binary.BigEndian.Uint16(segment[0:2]), binary.BigEndian.Uint16(segment[2:4])
+		if (fromClient && int(dstPort) != serverPort) || (!fromClient && int(srcPort) != serverPort) {
+			t.Errorf("packet %d: unexpected ports %d -> %d", i, srcPort, dstPort)
+		}
+	}
+	_, response := enhancedPacketData(blocks[7])
+	if payload := response[40:]; len(payload) != 13 || payload[7] != 3 || payload[8] != 4 {
+		t.Errorf("expected read holding registers response, got %v", payload)
+	}
+}
+
+func TestCaptureRTUOverTCP(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenRTUOverTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	var buffer bytes.Buffer
+	s.StartCapture(addr, &buffer)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	request := (&RTUFrame{SlaveId: 1, Function: 1, Data: []byte{0, 0, 0, 8}}).Bytes()
+	conn.Write(request)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response := make([]byte, 6)
+	if _, err = io.ReadFull(conn, response); err != nil {
+		t.Fatalf("failed to read, got %v", err)
+	}
+	s.StopCapture(addr)
+
+	blocks := readPcapngBlocks(t, buffer.Bytes())
+	if len(blocks) != 5 {
+		t.Fatalf("expected header, 2 interfaces, request and response, got %d blocks", len(blocks))
+	}
+	server, _ := netip.ParseAddrPort(addr)
+	client := addrPort(conn.LocalAddr())
+	for i, expected := range [][]byte{
+		exportedPDU("mbrtu", client, server, request),
+		exportedPDU("mbrtu", server, client, response),
+	} {
+		if interfaceID, packet := enhancedPacketData(blocks[3+i]); interfaceID != captureInterfaceRTU || !bytes.Equal(packet, expected) {
+			t.Errorf("packet %d: expected %v, got %v", i, expected, packet)
+		}
+	}
+	if pdu := exportedPDU("mbrtu", netip.AddrPort{}, netip.AddrPort{}, request); !bytes.Equal(pdu[:16], []byte{0, 12, 0, 8, 'm', 'b', 'r', 't', 'u', 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("expected dissector name and end tags, got %v", pdu)
+	}
+}
+
+func TestCaptureTCPClose(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	var buffer bytes.Buffer
+	if err := s.StartCapture(addr, &buffer); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.SlaveId = 1
+	handler.Timeout = time.Second
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	if _, err := modbus.NewClient(handler).ReadHoldingRegisters(0, 2); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	handler.Close()
+	for deadline := time.Now().Add(time.Second); len(s.Connections()) != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected connection to be closed")
+		}
+	}
+	s.captureMutex.Lock()
+	streams := len(s.captures[addr].streams)
+	s.captureMutex.Unlock()
+	if streams != 0 {
+		t.Errorf("expected closed stream to be removed, got %d streams", streams)
+	}
+	s.StopCapture(addr)
+
+	blocks := readPcapngBlocks(t, buffer.Bytes())
+	if len(blocks) != 11 {
+		t.Fatalf("expected header, 2 interfaces, handshake, request, response and teardown, got %d blocks", len(blocks))
+	}
+	for i, flags := range []uint8{tcpFlagFIN | tcpFlagACK, tcpFlagFIN | tcpFlagACK, tcpFlagACK} {
+		_, packet := enhancedPacketData(blocks[8+i])
+		if packet[20+13] != flags {
+			t.Errorf("packet %d: expected flags %#x, got %#x", 8+i, flags, packet[20+13])
+		}
+	}
+}
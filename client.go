@@ -0,0 +1,426 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// defaultClientTimeout is the response timeout of a Client without one.
+const defaultClientTimeout = time.Second
+
+// ErrClientTimeout is returned by a Client without a complete response within the timeout.
+var ErrClientTimeout = errors.New("modbus client: response timeout")
+
+type (
+	// ClientFraming is the framing of the requests and responses of a Client.
+	ClientFraming string
+	// Client is a Modbus master sending TCPFrame, RTUFrame or ASCIIFrame requests, one at a time. Exception
+	// responses are returned as Exception errors.
+	Client struct {
+		conn    *clientConn
+		timeout time.Duration
+	}
+	// clientConn is the connection shared by the clients with different timeouts.
+	clientConn struct {
+		port          io.ReadWriteCloser
+		framing       ClientFraming
+		mutex         sync.Mutex
+		transactionID uint16
+		// pending are the received bytes not yet part of a response.
+		pending   []byte
+		received  chan []byte
+		closeChan chan struct{}
+		closeOnce sync.Once
+		wg        sync.WaitGroup
+	}
+)
+
+const (
+	// FramingTCP is Modbus TCP, with MBAP headers.
+	FramingTCP ClientFraming = "tcp"
+	// FramingRTU is Modbus RTU, on a serial line or over TCP.
+	FramingRTU ClientFraming = "rtu"
+	// FramingASCII is Modbus ASCII.
+	FramingASCII ClientFraming = "ascii"
+)
+
+// NewClient returns a client sending requests with the framing on the port, with a response timeout of
+// 1s. The client owns the port.
+func NewClient(port io.ReadWriteCloser, framing ClientFraming) *Client {
+	conn := &clientConn{
+		port:      port,
+		framing:   framing,
+		received:  make(chan []byte, 16),
+		closeChan: make(chan struct{}),
+	}
+	conn.wg.Add(1)
+	go conn.readLoop()
+	return &Client{conn: conn, timeout: defaultClientTimeout}
+}
+
+// DialTCPClient connects a Modbus TCP client to the server address.
+func DialTCPClient(address string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, defaultClientTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, FramingTCP), nil
+}
+
+// DialRTUOverTCPClient connects an RTU over TCP client to the server address.
+func DialRTUOverTCPClient(address string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, defaultClientTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, FramingRTU), nil
+}
+
+// OpenRTUClient opens an RTU client on the serial device.
+func OpenRTUClient(config *serial.Config) (*Client, error) {
+	port, err := serial.Open(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(port, FramingRTU), nil
+}
+
+// OpenASCIIClient opens an ASCII client on the serial device.
+func OpenASCIIClient(config *serial.Config) (*Client, error) {
+	port, err := serial.Open(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(port, FramingASCII), nil
+}
+
+// WithTimeout returns a client sharing the connection with a different response timeout.
+func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	return &Client{conn: c.conn, timeout: timeout}
+}
+
+// Close closes the connection of the client.
+func (c *Client) Close() (err error) {
+	c.conn.closeOnce.Do(func() {
+		close(c.conn.closeChan)
+		err = c.conn.port.Close()
+		c.conn.wg.Wait()
+	})
+	return
+}
+
+// Frame returns a request of the client framing. TCP requests get the next transaction ID.
+func (c *Client) Frame(slave uint8, function uint8, data []byte) Framer {
+	switch c.conn.framing {
+	case FramingTCP:
+		c.conn.mutex.Lock()
+		c.conn.transactionID++
+		frame := &TCPFrame{TransactionIdentifier: c.conn.transactionID, Device: slave, Function: function}
+		c.conn.mutex.Unlock()
+		frame.SetData(data)
+		return frame
+	case FramingASCII:
+		return &ASCIIFrame{SlaveId: slave, Function: function, Data: data}
+	}
+	return &RTUFrame{SlaveId: slave, Function: function, Data: data}
+}
+
+// Send sends the request and returns the response, nil for RTU and ASCII broadcasts to slave 0. TCP
+// responses of other transactions are skipped.
+func (c *Client) Send(request Framer) (Framer, error) {
+	c.conn.mutex.Lock()
+	defer c.conn.mutex.Unlock()
+	if err := c.conn.write(request.Bytes()); err != nil {
+		return nil, err
+	}
+	if c.conn.framing != FramingTCP && request.GetSlaveId() == 0 {
+		return nil, nil
+	}
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	for {
+		packet, err := c.conn.receive(timer)
+		if err != nil {
+			return nil, err
+		}
+		var response Framer
+		switch c.conn.framing {
+		case FramingTCP:
+			frame, err := NewTCPFrame(packet)
+			if err != nil {
+				return nil, err
+			}
+			if tcpRequest, ok := request.(*TCPFrame); ok && frame.TransactionIdentifier != tcpRequest.TransactionIdentifier {
+				continue
+			}
+			response = frame
+		case FramingASCII:
+			if response, err = NewASCIIFrame(packet); err != nil {
+				return nil, err
+			}
+		default:
+			if response, err = NewRTUFrame(packet); err != nil {
+				return nil, err
+			}
+		}
+		if response.GetSlaveId() != request.GetSlaveId() {
+			return nil, fmt.Errorf("modbus client: response of slave %d to a request for slave %d", response.GetSlaveId(), request.GetSlaveId())
+		}
+		return response, nil
+	}
+}
+
+// SendPDU sends the protocol data unit, function code and data, to the slave and returns the PDU of
+// the response, nil for RTU and ASCII broadcasts.
+func (c *Client) SendPDU(slave uint8, pdu []byte) ([]byte, error) {
+	if len(pdu) == 0 {
+		return nil, errors.New("modbus client: empty PDU")
+	}
+	response, err := c.Send(c.Frame(slave, pdu[0], bytes.Clone(pdu[1:])))
+	if err != nil || response == nil {
+		return nil, err
+	}
+	return append([]byte{response.GetFunction()}, response.GetData()...), nil
+}
+
+// SendRaw sends the packet as is, malformed or not, and returns the next complete response packet of
+// the client framing, whatever its transaction. ErrClientTimeout is returned without response.
+func (c *Client) SendRaw(packet []byte) ([]byte, error) {
+	c.conn.mutex.Lock()
+	defer c.conn.mutex.Unlock()
+	if err := c.conn.write(packet); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	return c.conn.receive(timer)
+}
+
+// ReadCoils function 1 returns the coils of the slave, one value 0 or 1 per coil.
+func (c *Client) ReadCoils(slave uint8, address uint16, quantity uint16) ([]uint16, error) {
+	return c.readBits(slave, 1, address, quantity)
+}
+
+// ReadDiscreteInputs function 2 returns the discrete inputs of the slave, one value 0 or 1 per input.
+func (c *Client) ReadDiscreteInputs(slave uint8, address uint16, quantity uint16) ([]uint16, error) {
+	return c.readBits(slave, 2, address, quantity)
+}
+
+// ReadHoldingRegisters function 3 returns the holding registers of the slave.
+func (c *Client) ReadHoldingRegisters(slave uint8, address uint16, quantity uint16) ([]uint16, error) {
+	return c.readRegisters(slave, 3, address, quantity)
+}
+
+// ReadInputRegisters function 4 returns the input registers of the slave.
+func (c *Client) ReadInputRegisters(slave uint8, address uint16, quantity uint16) ([]uint16, error) {
+	return c.readRegisters(slave, 4, address, quantity)
+}
+
+// WriteSingleCoil function 5 writes a coil of the slave.
+func (c *Client) WriteSingleCoil(slave uint8, address uint16, value bool) error {
+	var coil uint16
+	if value {
+		coil = 0xFF00
+	}
+	return c.write(slave, 5, address, coil, nil)
+}
+
+// WriteSingleRegister function 6 writes a holding register of the slave.
+func (c *Client) WriteSingleRegister(slave uint8, address uint16, value uint16) error {
+	return c.write(slave, 6, address, value, nil)
+}
+
+// WriteMultipleCoils function 15 writes coils of the slave, one value 0 or 1 per coil.
+func (c *Client) WriteMultipleCoils(slave uint8, address uint16, values []uint16) error {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value != 0 {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return c.write(slave, 15, address, uint16(len(values)), packed)
+}
+
+// WriteMultipleRegisters function 16 writes holding registers of the slave.
+func (c *Client) WriteMultipleRegisters(slave uint8, address uint16, values []uint16) error {
+	return c.write(slave, 16, address, uint16(len(values)), Uint16ToBytes(values))
+}
+
+// ReadDeviceIdentification function 43, MEI type 14, returns the identification objects of the slave by
+// stream (code 1 to 3, from the object ID) or individually (code 4). Streams are read until complete.
+func (c *Client) ReadDeviceIdentification(slave uint8, code uint8, objectID uint8) (map[uint8]string, error) {
+	objects := make(map[uint8]string)
+	for {
+		data, err := c.call(slave, 43, []byte{meiReadDeviceIdentification, code, objectID})
+		if err != nil || data == nil {
+			return nil, err
+		}
+		if len(data) < 6 || data[0] != meiReadDeviceIdentification {
+			return nil, errors.New("modbus client: invalid device identification response")
+		}
+		more, next, count := data[3], data[4], int(data[5])
+		data = data[6:]
+		for i := 0; i < count; i++ {
+			if len(data) < 2 || len(data) < 2+int(data[1]) {
+				return nil, errors.New("modbus client: truncated device identification object")
+			}
+			objects[data[0]] = string(data[2 : 2+int(data[1])])
+			data = data[2+int(data[1]):]
+		}
+		if more != 0xFF || code == 4 {
+			return objects, nil
+		}
+		objectID = next
+	}
+}
+
+// call sends a request and returns the response data, or the exception of the response.
+func (c *Client) call(slave uint8, function uint8, data []byte) ([]byte, error) {
+	response, err := c.Send(c.Frame(slave, function, data))
+	if err != nil || response == nil {
+		return nil, err
+	}
+	switch response.GetFunction() {
+	case function:
+		return response.GetData(), nil
+	case function | 0x80:
+		if len(response.GetData()) == 0 {
+			return nil, errors.New("modbus client: exception response without code")
+		}
+		return nil, Exception(response.GetData()[0])
+	}
+	return nil, fmt.Errorf("modbus client: response of function %d to function %d", response.GetFunction(), function)
+}
+
+func (c *Client) readBits(slave uint8, function uint8, address uint16, quantity uint16) ([]uint16, error) {
+	request := make([]byte, 4)
+	binary.BigEndian.PutUint16(request[0:2], address)
+	binary.BigEndian.PutUint16(request[2:4], quantity)
+	data, err := c.call(slave, function, request)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	if length := (int(quantity) + 7) / 8; len(data) != 1+length || int(data[0]) != length {
+		return nil, fmt.Errorf("modbus client: %d bytes of bits for quantity %d", len(data), quantity)
+	}
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = uint16(bitAtPosition(data[1+i/8], uint(i%8)))
+	}
+	return values, nil
+}
+
+func (c *Client) readRegisters(slave uint8, function uint8, address uint16, quantity uint16) ([]uint16, error) {
+	request := make([]byte, 4)
+	binary.BigEndian.PutUint16(request[0:2], address)
+	binary.BigEndian.PutUint16(request[2:4], quantity)
+	data, err := c.call(slave, function, request)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	if len(data) != 1+2*int(quantity) || int(data[0]) != 2*int(quantity) {
+		return nil, fmt.Errorf("modbus client: %d bytes of registers for quantity %d", len(data), quantity)
+	}
+	return BytesToUint16(data[1:]), nil
+}
+
+// write sends a write request of the address and value, followed by the byte count and the values of
+// multiple writes, and checks that the response echoes the address and value.
+func (c *Client) write(slave uint8, function uint8, address uint16, value uint16, values []byte) error {
+	request := make([]byte, 4, 5+len(values))
+	binary.BigEndian.PutUint16(request[0:2], address)
+	binary.BigEndian.PutUint16(request[2:4], value)
+	if function == 15 || function == 16 {
+		request = append(append(request, uint8(len(values))), values...)
+	}
+	data, err := c.call(slave, function, request)
+	if err != nil || data == nil {
+		return err
+	}
+	if len(data) != 4 || !bytes.Equal(data, request[0:4]) {
+		return fmt.Errorf("modbus client: write response %v to request %v", data, request[0:4])
+	}
+	return nil
+}
+
+// readLoop receives the bytes of the port until it is closed.
+func (conn *clientConn) readLoop() {
+	defer conn.wg.Done()
+	buffer := make([]byte, 512)
+	for {
+		n, err := conn.port.Read(buffer)
+		if n != 0 {
+			select {
+			case conn.received <- bytes.Clone(buffer[:n]):
+			case <-conn.closeChan:
+				return
+			}
+		}
+		if err != nil && !errors.Is(err, serial.ErrTimeout) {
+			return
+		}
+	}
+}
+
+// write discards the late responses to previous requests and writes the packet.
+func (conn *clientConn) write(packet []byte) error {
+	conn.pending = nil
+	for drained := false; !drained; {
+		select {
+		case <-conn.received:
+		default:
+			drained = true
+		}
+	}
+	_, err := conn.port.Write(packet)
+	return err
+}
+
+// receive returns the next complete packet of the framing, or ErrClientTimeout once the timer fires.
+func (conn *clientConn) receive(timer *time.Timer) ([]byte, error) {
+	for {
+		if conn.framing == FramingASCII {
+			if start := bytes.IndexByte(conn.pending, ':'); start > 0 {
+				conn.pending = conn.pending[start:]
+			}
+		}
+		if length := conn.packetLength(); length != 0 && len(conn.pending) >= length {
+			packet := conn.pending[:length]
+			conn.pending = conn.pending[length:]
+			return packet, nil
+		}
+		select {
+		case <-conn.closeChan:
+			return nil, net.ErrClosed
+		case <-timer.C:
+			return nil, ErrClientTimeout
+		case received := <-conn.received:
+			conn.pending = append(conn.pending, received...)
+		}
+	}
+}
+
+// packetLength returns the length of the packet starting the pending bytes, 0 if it can't be known yet.
+func (conn *clientConn) packetLength() int {
+	switch conn.framing {
+	case FramingTCP:
+		if len(conn.pending) < 6 {
+			return 0
+		}
+		return 6 + int(binary.BigEndian.Uint16(conn.pending[4:6]))
+	case FramingASCII:
+		if end := bytes.Index(conn.pending, []byte("\r\n")); end >= 0 {
+			return end + 2
+		}
+		return 0
+	}
+	return rtuResponseLength(conn.pending)
+}
@@ -0,0 +1,148 @@
+package modbusserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// serveASCIIDevice answers the ASCII requests received on the port with the memory of the device.
+// Broadcasts to slave 0 and the requests for other slaves get no response.
+func serveASCIIDevice(device *Server, port net.Conn) {
+	var pending []byte
+	buffer := make([]byte, 256)
+	for {
+		n, err := port.Read(buffer)
+		if err != nil {
+			return
+		}
+		pending = append(pending, buffer[:n]...)
+		end := bytes.Index(pending, []byte("\r\n"))
+		if end < 0 {
+			continue
+		}
+		request, err := NewASCIIFrame(pending[:end+2])
+		pending = pending[end+2:]
+		if err != nil || !device.slaveResponds(request.SlaveId) {
+			continue
+		}
+		response := device.respond(request)
+		port.Write(response.Bytes())
+	}
+}
+
+func TestClient(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.SetDeviceIdentification(1, map[uint8]string{ObjectVendorName: "Vendor", ObjectProductCode: "P1", ObjectMajorMinorRevision: "1.0"})
+	s.RegisterFunctionHandler(100, func(s *Server, frame Framer) ([]byte, *Exception) {
+		return append([]byte{0xAA}, frame.GetData()...), &Success
+	})
+	tcpAddr, rtuAddr := getFreePort(), getFreePort()
+	if err := s.ListenTCP(tcpAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	if err := s.ListenRTUOverTCP(rtuAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	tcpClient, err := DialTCPClient(tcpAddr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer tcpClient.Close()
+	rtuClient, err := DialRTUOverTCPClient(rtuAddr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer rtuClient.Close()
+
+	for name, client := range map[string]*Client{"tcp": tcpClient, "rtu": rtuClient} {
+		if err := client.WriteMultipleRegisters(1, 10, []uint16{1, 2, 3}); err != nil {
+			t.Errorf("%s: expected nil, got %v", name, err)
+		}
+		if err := client.WriteSingleRegister(1, 13, 4); err != nil {
+			t.Errorf("%s: expected nil, got %v", name, err)
+		}
+		if values, err := client.ReadHoldingRegisters(1, 10, 4); err != nil || !slices.Equal(values, []uint16{1, 2, 3, 4}) {
+			t.Errorf("%s: expected [1 2 3 4], got %v, %v", name, values, err)
+		}
+		if err := client.WriteMultipleCoils(1, 3, []uint16{1, 0, 1, 1, 0, 0, 0, 0, 1}); err != nil {
+			t.Errorf("%s: expected nil, got %v", name, err)
+		}
+		if err := client.WriteSingleCoil(1, 4, true); err != nil {
+			t.Errorf("%s: expected nil, got %v", name, err)
+		}
+		if values, err := client.ReadCoils(1, 3, 9); err != nil || !slices.Equal(values, []uint16{1, 1, 1, 1, 0, 0, 0, 0, 1}) {
+			t.Errorf("%s: expected coils, got %v, %v", name, values, err)
+		}
+		s.WriteTable(1, TableDiscreteInputs, 0, []uint16{0, 1})
+		s.WriteTable(1, TableInputRegisters, 0, []uint16{7})
+		if values, err := client.ReadDiscreteInputs(1, 0, 2); err != nil || !slices.Equal(values, []uint16{0, 1}) {
+			t.Errorf("%s: expected inputs, got %v, %v", name, values, err)
+		}
+		if values, err := client.ReadInputRegisters(1, 0, 1); err != nil || values[0] != 7 {
+			t.Errorf("%s: expected input register, got %v, %v", name, values, err)
+		}
+		objects, err := client.ReadDeviceIdentification(1, 1, 0)
+		if err != nil || len(objects) != 3 || objects[ObjectProductCode] != "P1" {
+			t.Errorf("%s: expected identification, got %v, %v", name, objects, err)
+		}
+
+		var exception Exception
+		if _, err := client.ReadHoldingRegisters(1, 65535, 2); !errors.As(err, &exception) || exception != IllegalDataAddress {
+			t.Errorf("%s: expected IllegalDataAddress, got %v", name, err)
+		}
+		if pdu, err := client.SendPDU(1, []byte{100, 1, 2}); err != nil || !bytes.Equal(pdu, []byte{100, 0xAA, 1, 2}) {
+			t.Errorf("%s: expected custom function response, got %v, %v", name, pdu, err)
+		}
+		if pdu, err := client.SendPDU(1, []byte{99, 0}); err != nil || !bytes.Equal(pdu, []byte{99 | 0x80, byte(IllegalFunction)}) {
+			t.Errorf("%s: expected IllegalFunction response, got %v, %v", name, pdu, err)
+		}
+	}
+
+	// Malformed frames get no response, a valid raw frame does.
+	request := tcpClient.Frame(1, 3, []byte{0, 10, 0, 1}).Bytes()
+	malformed := slices.Clone(request)
+	malformed[5]++
+	if _, err = tcpClient.WithTimeout(100 * time.Millisecond).SendRaw(malformed); !errors.Is(err, ErrClientTimeout) {
+		t.Errorf("expected timeout on wrong length, got %v", err)
+	}
+	if response, err := tcpClient.SendRaw(request); err != nil || !bytes.Equal(response[:2], request[:2]) || !bytes.Equal(response[7:], []byte{3, 2, 0, 1}) {
+		t.Errorf("expected response to raw frame, got %v, %v", response, err)
+	}
+	malformed = (&RTUFrame{SlaveId: 1, Function: 3, Data: []byte{0, 10, 0, 1}}).Bytes()
+	malformed[len(malformed)-1]++
+	if _, err = rtuClient.WithTimeout(100 * time.Millisecond).SendRaw(malformed); !errors.Is(err, ErrClientTimeout) {
+		t.Errorf("expected timeout on wrong CRC, got %v", err)
+	}
+	if values, err := rtuClient.ReadHoldingRegisters(1, 10, 1); err != nil || values[0] != 1 {
+		t.Errorf("expected client usable after timeout, got %v, %v", values, err)
+	}
+}
+
+func TestASCIIClient(t *testing.T) {
+	device := NewServer(slog.Logger{})
+	device.InitSlave(2)
+	device.WriteTable(2, TableHoldingRegisters, 0, []uint16{0x1234})
+	devicePort, clientPort := net.Pipe()
+	go serveASCIIDevice(device, devicePort)
+	client := NewClient(clientPort, FramingASCII)
+	defer client.Close()
+
+	if values, err := client.ReadHoldingRegisters(2, 0, 1); err != nil || values[0] != 0x1234 {
+		t.Errorf("expected 0x1234, got %v, %v", values, err)
+	}
+	if err := client.WriteSingleRegister(0, 0, 1); err != nil {
+		t.Errorf("expected no response to broadcast, got %v", err)
+	}
+	if _, err := client.WithTimeout(50*time.Millisecond).ReadHoldingRegisters(3, 0, 1); !errors.Is(err, ErrClientTimeout) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+// Command modbus-server runs a Modbus server described by a YAML or JSON config file, until it gets
+// SIGINT or SIGTERM.
+//
+//	modbus-server -config modbus-server.yaml
+//
+// With -check, it only validates the config file.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	modbusserver "github.com/Daniil-Kurganov/modbus-server"
+)
+
+func main() {
+	path := flag.String("config", "modbus-server.yaml", "path of the YAML or JSON config file")
+	check := flag.Bool("check", false, "validate the config file and exit")
+	flag.Parse()
+
+	config, err := modbusserver.LoadServerConfigFile(*path)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "modbus-server: invalid config %s:\n%v\n", *path, err)
+		os.Exit(2)
+	}
+	if *check {
+		fmt.Printf("%s: config is valid\n", *path)
+		return
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: config.LogLevel}))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server, err := modbusserver.NewServerFromConfig(config, *logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Unable to start: %s", err.Error()))
+		os.Exit(1)
+	}
+	logger.Info(fmt.Sprintf("Serving %d slaves on %d listeners", len(config.Slaves), len(config.Listeners)))
+
+	<-ctx.Done()
+	// A second signal terminates the process without waiting for the shutdown.
+	stop()
+	logger.Info("Shutting down")
+	server.Close()
+}
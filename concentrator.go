@@ -0,0 +1,300 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ConcentratorNotPolled is the quality of a concentrator poll before its first attempt.
+const ConcentratorNotPolled uint16 = 0xFFFF
+
+type (
+	// ConcentratorPoll copies an address block of a device on a backend into a block of the same table of
+	// a local slave, on a schedule.
+	ConcentratorPoll struct {
+		// Backend is the name of the gateway line or backend of the device.
+		Backend  string `json:"backend" yaml:"backend"`
+		Unit     uint8  `json:"unit" yaml:"unit"`
+		Table    Table  `json:"table" yaml:"table"`
+		Address  uint16 `json:"address" yaml:"address"`
+		Quantity uint16 `json:"quantity" yaml:"quantity"`
+		// Slave and LocalAddress are where the block is mapped, created if needed.
+		Slave        uint8    `json:"slave" yaml:"slave"`
+		LocalAddress uint16   `json:"local_address" yaml:"local_address"`
+		Interval     Duration `json:"interval" yaml:"interval"`
+		// Timeout is the response timeout of each attempt, 1s if zero.
+		Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+		// Retries is the number of attempts after the first one.
+		Retries int `json:"retries,omitempty" yaml:"retries,omitempty"`
+		// StatusRegister is the input register of the local slave holding the quality of the block: 0
+		// after a successful poll, the exception code of a failed one (GatewayPathUnavailable without
+		// the backend, GatewayTargetDeviceFailedtoRespond without response) and ConcentratorNotPolled
+		// before the first one.
+		StatusRegister *uint16 `json:"status_register,omitempty" yaml:"status_register,omitempty"`
+	}
+	// ConcentratorPollStatus is the state of a concentrator poll.
+	ConcentratorPollStatus struct {
+		ConcentratorPoll
+		// Quality is the value of the status register.
+		Quality uint16
+		// Updated is the time of the last successful poll.
+		Updated time.Time
+		// Error is the error of the last poll, nil if it succeeded.
+		Error error
+	}
+	// Concentrator aggregates the address blocks of many devices into the address space of local slaves.
+	// Writes of Modbus masters to mapped addresses are forwarded to the source device.
+	Concentrator struct {
+		server   *Server
+		hooks    map[uint8]*slaveHook
+		mutex    sync.Mutex
+		status   []ConcentratorPollStatus
+		closed   bool
+		stopChan chan struct{}
+		wg       sync.WaitGroup
+	}
+)
+
+// Validate checks the block, the interval and the retries of the poll.
+func (p *ConcentratorPoll) Validate() error {
+	limit := 125
+	if p.Table == TableCoils || p.Table == TableDiscreteInputs {
+		limit = 2000
+	}
+	switch {
+	case p.Table > TableInputRegisters:
+		return fmt.Errorf("poll of %s unit %d: unknown table %d", p.Backend, p.Unit, p.Table)
+	case p.Quantity == 0 || int(p.Quantity) > limit:
+		return fmt.Errorf("poll of %s unit %d %s %d: quantity %d out of 1-%d", p.Backend, p.Unit, p.Table, p.Address, p.Quantity, limit)
+	case int(p.Address)+int(p.Quantity) > 65536 || int(p.LocalAddress)+int(p.Quantity) > 65536:
+		return fmt.Errorf("poll of %s unit %d %s %d: block out of the address space", p.Backend, p.Unit, p.Table, p.Address)
+	case p.Interval <= 0 || p.Timeout < 0 || p.Retries < 0:
+		return fmt.Errorf("poll of %s unit %d %s %d: invalid interval, timeout or retries", p.Backend, p.Unit, p.Table, p.Address)
+	}
+	return nil
+}
+
+// contains returns true if the local block of the poll contains the range, and overlaps if it has
+// addresses in common with it.
+func (p *ConcentratorPoll) contains(address int, quantity int) (contains bool, overlaps bool) {
+	start, end := int(p.LocalAddress), int(p.LocalAddress)+int(p.Quantity)
+	return start <= address && address+quantity <= end, address < end && start < address+quantity
+}
+
+// LoadConcentratorFile reads concentrator polls from a JSON or YAML file, chosen by extension.
+// Unknown fields are errors.
+func LoadConcentratorFile(path string) ([]ConcentratorPoll, error) {
+	return loadConfigFile[[]ConcentratorPoll](path, "concentrator")
+}
+
+// NewConcentrator starts the polls of the devices through the server backends. The local blocks of the
+// polls must not overlap, nor contain a status register, and the backends may be added later. Writes of
+// functions 5, 6, 15 and 16 to mapped coils and holding registers are handled by the concentrator before
+// the function handlers: they are validated, sent to the device, and written locally if it accepts them.
+// Other requests wait for the device. All errors are reported together. Close must be called to stop
+// polling.
+func NewConcentrator(s *Server, polls []ConcentratorPoll) (*Concentrator, error) {
+	var errs []error
+	for i := range polls {
+		if err := polls[i].Validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for j := range polls[:i] {
+			if polls[j].Slave != polls[i].Slave || polls[j].Table != polls[i].Table {
+				continue
+			}
+			if _, overlaps := polls[j].contains(int(polls[i].LocalAddress), int(polls[i].Quantity)); overlaps {
+				errs = append(errs, fmt.Errorf("poll of %s unit %d: slave %d %s %d overlaps another poll", polls[i].Backend,
+					polls[i].Unit, polls[i].Slave, polls[i].Table, polls[i].LocalAddress))
+			}
+		}
+		if polls[i].StatusRegister == nil {
+			continue
+		}
+		for j := range polls {
+			if polls[j].Slave != polls[i].Slave || polls[j].Table != TableInputRegisters {
+				continue
+			}
+			if contains, _ := polls[j].contains(int(*polls[i].StatusRegister), 1); contains {
+				errs = append(errs, fmt.Errorf("poll of %s unit %d: status register %d of slave %d is in the block of a poll", polls[i].Backend,
+					polls[i].Unit, *polls[i].StatusRegister, polls[i].Slave))
+				break
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	c := &Concentrator{server: s, hooks: make(map[uint8]*slaveHook), status: make([]ConcentratorPollStatus, len(polls)), stopChan: make(chan struct{})}
+	for i, poll := range polls {
+		c.status[i] = ConcentratorPollStatus{ConcentratorPoll: poll, Quality: ConcentratorNotPolled}
+		s.InitSlave(poll.Slave)
+		if poll.StatusRegister != nil {
+			s.WriteTable(poll.Slave, TableInputRegisters, *poll.StatusRegister, []uint16{ConcentratorNotPolled})
+		}
+	}
+	for _, poll := range polls {
+		if _, ok := c.hooks[poll.Slave]; !ok {
+			c.hooks[poll.Slave] = s.addSlaveHook(poll.Slave, c.handle)
+		}
+	}
+	for i := range c.status {
+		c.wg.Add(1)
+		go c.poll(i)
+	}
+	return c, nil
+}
+
+// Close stops polling and forwarding writes. The local slaves are then written locally.
+func (c *Concentrator) Close() {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return
+	}
+	c.closed = true
+	c.mutex.Unlock()
+	for id, hook := range c.hooks {
+		c.server.removeSlaveHook(id, hook)
+	}
+	close(c.stopChan)
+	c.wg.Wait()
+}
+
+// Status returns the state of the polls.
+func (c *Concentrator) Status() []ConcentratorPollStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]ConcentratorPollStatus(nil), c.status...)
+}
+
+func (c *Concentrator) poll(index int) {
+	defer c.wg.Done()
+	poll := c.status[index].ConcentratorPoll
+	ticker := time.NewTicker(time.Duration(poll.Interval))
+	defer ticker.Stop()
+	for {
+		values, exception, err := c.read(poll)
+		if err == nil {
+			err = c.server.WriteTable(poll.Slave, poll.Table, poll.LocalAddress, values)
+		}
+		if err != nil && exception == Success {
+			exception = SlaveDeviceFailure
+		}
+		if poll.StatusRegister != nil {
+			c.server.WriteTable(poll.Slave, TableInputRegisters, *poll.StatusRegister, []uint16{uint16(exception)})
+		}
+		c.mutex.Lock()
+		c.status[index].Quality, c.status[index].Error = uint16(exception), err
+		if err == nil {
+			c.status[index].Updated = time.Now()
+		}
+		c.mutex.Unlock()
+		if err != nil {
+			c.server.logger.Debug(fmt.Sprintf("Concentrator: poll of %s unit %d %s %d failed: %s", poll.Backend, poll.Unit, poll.Table, poll.Address, err.Error()))
+		}
+		select {
+		case <-c.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// read reads the block of the poll from the device.
+func (c *Concentrator) read(poll ConcentratorPoll) (values []uint16, exception Exception, err error) {
+	request := make([]byte, 4)
+	binary.BigEndian.PutUint16(request[0:2], poll.Address)
+	binary.BigEndian.PutUint16(request[2:4], poll.Quantity)
+	function, data, err := c.call(poll, uint8(poll.Table)+1, request)
+	if err != nil {
+		var e Exception
+		errors.As(err, &e)
+		return nil, e, err
+	}
+	if len(data) == 0 || len(data)-1 != int(data[0]) {
+		return nil, SlaveDeviceFailure, fmt.Errorf("invalid response of function %d", function)
+	}
+	data = data[1:]
+	if poll.Table == TableCoils || poll.Table == TableDiscreteInputs {
+		if len(data) < (int(poll.Quantity)+7)/8 {
+			return nil, SlaveDeviceFailure, errors.New("short response")
+		}
+		values = make([]uint16, poll.Quantity)
+		for i := range values {
+			values[i] = uint16(bitAtPosition(data[i/8], uint(i%8)))
+		}
+		return values, Success, nil
+	}
+	if len(data) < 2*int(poll.Quantity) {
+		return nil, SlaveDeviceFailure, errors.New("short response")
+	}
+	return BytesToUint16(data[:2*int(poll.Quantity)]), Success, nil
+}
+
+// call sends a request to the device of the poll. An exception of the backend or of the device is
+// returned as an Exception error.
+func (c *Concentrator) call(poll ConcentratorPoll, function uint8, data []byte) (uint8, []byte, error) {
+	responseFunction, responseData, exception := c.server.transact(poll.Backend, poll.Unit, function, data, time.Duration(poll.Timeout), poll.Retries)
+	switch {
+	case exception != &Success:
+		return 0, nil, *exception
+	case responseFunction&0x80 != 0 && len(responseData) != 0:
+		return 0, nil, Exception(responseData[0])
+	case responseFunction&0x7F != function || responseData == nil:
+		return 0, nil, fmt.Errorf("unexpected response of function %d", responseFunction)
+	}
+	return responseFunction, responseData, nil
+}
+
+// handle is the slave hook of the concentrator: it forwards the writes to mapped addresses, leaving the
+// other requests to the function handlers. The device is called without the memory lock.
+func (c *Concentrator) handle(frame Framer) ([]byte, *Exception, bool) {
+	s, function := c.server, frame.GetFunction()
+	table, address, values, exception := writeRequest(frame)
+	if exception != &Success {
+		return nil, nil, false
+	}
+	var poll *ConcentratorPoll
+	for i := range c.status {
+		if c.status[i].Slave != frame.GetSlaveId() || c.status[i].Table != table {
+			continue
+		}
+		contains, overlaps := c.status[i].contains(address, len(values))
+		if overlaps && !contains {
+			return []byte{}, &IllegalDataAddress, true
+		}
+		if contains {
+			poll = &c.status[i].ConcentratorPoll
+			break
+		}
+	}
+	if poll == nil {
+		return nil, nil, false
+	}
+	if exception = s.ValidateWrite(poll.Slave, table, address, values); exception != &Success {
+		return []byte{}, exception, true
+	}
+	request := bytes.Clone(frame.GetData())
+	binary.BigEndian.PutUint16(request[0:2], uint16(address-int(poll.LocalAddress)+int(poll.Address)))
+	if _, _, err := c.call(*poll, function, request); err != nil {
+		var e Exception
+		if !errors.As(err, &e) {
+			e = GatewayTargetDeviceFailedtoRespond
+		}
+		return []byte{}, &e, true
+	}
+	s.memoryMutex.Lock()
+	exception = s.commitWrite(poll.Slave, table, address, values)
+	s.memoryMutex.Unlock()
+	if exception != &Success {
+		return []byte{}, exception, true
+	}
+	return frame.GetData()[0:4], &Success, true
+}
@@ -0,0 +1,136 @@
+package modbusserver
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestConcentrator(t *testing.T) {
+	remote := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	remote.InitSlave(1)
+	remote.InitSlave(2)
+	remote.WriteTable(1, TableHoldingRegisters, 0, []uint16{10, 11, 12, 13, 14})
+	remote.WriteTable(2, TableCoils, 0, []uint16{1, 1, 0, 1})
+	remoteAddr := getFreePort()
+	if err := remote.ListenTCP(remoteAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer remote.Close()
+
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	s.AddTCPBackend("remote", remoteAddr)
+	status := []uint16{0, 1, 2}
+	c, err := NewConcentrator(s, []ConcentratorPoll{
+		{Backend: "remote", Unit: 1, Table: TableHoldingRegisters, Address: 0, Quantity: 5, Slave: 1, LocalAddress: 100,
+			Interval: Duration(20 * time.Millisecond), Timeout: Duration(100 * time.Millisecond), StatusRegister: &status[0]},
+		{Backend: "remote", Unit: 2, Table: TableCoils, Address: 0, Quantity: 4, Slave: 1, LocalAddress: 10,
+			Interval: Duration(20 * time.Millisecond), StatusRegister: &status[1]},
+		{Backend: "missing", Unit: 3, Table: TableInputRegisters, Address: 0, Quantity: 1, Slave: 1, LocalAddress: 10,
+			Interval: Duration(20 * time.Millisecond), StatusRegister: &status[2]},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer c.Close()
+	waitQuality := func(expected []uint16) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for quality, _ := s.ReadTable(1, TableInputRegisters, 0, 3); !slices.Equal(quality, expected); quality, _ = s.ReadTable(1, TableInputRegisters, 0, 3) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected quality %v, got %v", expected, quality)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitQuality([]uint16{0, 0, uint16(GatewayPathUnavailable)})
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.SlaveId = 1
+	handler.Timeout = time.Second
+	if err = handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+	if results, err := client.ReadHoldingRegisters(100, 5); err != nil || !slices.Equal(BytesToUint16(results), []uint16{10, 11, 12, 13, 14}) {
+		t.Errorf("expected remapped registers, got %v, %v", results, err)
+	}
+	if results, err := client.ReadCoils(10, 4); err != nil || results[0] != 0b1011 {
+		t.Errorf("expected remapped coils, got %v, %v", results, err)
+	}
+
+	if _, err = client.WriteMultipleRegisters(102, 2, Uint16ToBytes([]uint16{22, 23})); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if values, _ := remote.ReadTable(1, TableHoldingRegisters, 2, 2); !slices.Equal(values, []uint16{22, 23}) {
+		t.Errorf("expected write forwarded, got %v", values)
+	}
+	if _, err = client.WriteSingleCoil(11, 0); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if values, _ := remote.ReadTable(2, TableCoils, 1, 1); values[0] != 0 {
+		t.Errorf("expected coil write forwarded, got %v", values)
+	}
+	var modbusError *modbus.ModbusError
+	if _, err = client.WriteMultipleRegisters(104, 2, Uint16ToBytes([]uint16{1, 2})); !errors.As(err, &modbusError) || modbusError.ExceptionCode != uint8(IllegalDataAddress) {
+		t.Errorf("expected IllegalDataAddress across the block end, got %v", err)
+	}
+	if _, err = client.WriteSingleRegister(0, 5); err != nil {
+		t.Errorf("expected local write, got %v", err)
+	}
+	if values, _ := s.ReadTable(1, TableHoldingRegisters, 0, 1); values[0] != 5 {
+		t.Errorf("expected local write, got %v", values)
+	}
+
+	remote.SlaveStopResponse(1)
+	if _, err = client.WriteSingleRegister(100, 5); !errors.As(err, &modbusError) || modbusError.ExceptionCode != uint8(GatewayTargetDeviceFailedtoRespond) {
+		t.Errorf("expected GatewayTargetDeviceFailedtoRespond, got %v", err)
+	}
+	waitQuality([]uint16{uint16(GatewayTargetDeviceFailedtoRespond), 0, uint16(GatewayPathUnavailable)})
+	if status := c.Status(); status[0].Error == nil || status[1].Error != nil || status[0].Updated.IsZero() {
+		t.Errorf("expected first poll failing, got %+v", status)
+	}
+
+	c.Close()
+	if _, err = client.WriteSingleRegister(100, 5); err != nil {
+		t.Errorf("expected local write after Close, got %v", err)
+	}
+}
+
+func TestConcentratorValidation(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	statusRegister := uint16(2)
+	_, err := NewConcentrator(s, []ConcentratorPoll{
+		{Backend: "a", Table: TableHoldingRegisters, Quantity: 10, Slave: 1, LocalAddress: 0, Interval: Duration(time.Second)},
+		{Backend: "b", Table: TableHoldingRegisters, Quantity: 10, Slave: 1, LocalAddress: 5, Interval: Duration(time.Second)},
+		{Backend: "c", Table: TableHoldingRegisters, Quantity: 126, Slave: 1, LocalAddress: 100, Interval: Duration(time.Second)},
+		{Backend: "d", Table: TableCoils, Quantity: 1, Slave: 1},
+		// The status register is in the block of its own poll.
+		{Backend: "e", Table: TableInputRegisters, Quantity: 4, Slave: 2, LocalAddress: 0, Interval: Duration(time.Second), StatusRegister: &statusRegister},
+	})
+	if err == nil || len(err.(interface{ Unwrap() []error }).Unwrap()) != 4 {
+		t.Errorf("expected 4 errors, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "concentrator.yaml")
+	os.WriteFile(path, []byte("- backend: plc\n  unit: 3\n  table: holding_registers\n  address: 0\n  quantity: 10\n"+
+		"  slave: 1\n  local_address: 100\n  interval: 1s\n  status_register: 7\n"), 0o644)
+	polls, err := LoadConcentratorFile(path)
+	if err != nil || len(polls) != 1 || polls[0].LocalAddress != 100 || polls[0].Interval != Duration(time.Second) ||
+		polls[0].StatusRegister == nil || *polls[0].StatusRegister != 7 {
+		t.Errorf("expected a poll, got %+v, %v", polls, err)
+	}
+}
@@ -0,0 +1,270 @@
+package modbusserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/goburrow/serial"
+)
+
+// Listener types of a ListenerConfig.
+const (
+	ListenerTCP        = "tcp"
+	ListenerTLS        = "tls"
+	ListenerRTUOverTCP = "rtu_over_tcp"
+	ListenerRTU        = "rtu"
+)
+
+type (
+	// ServerConfig describes a server with its listeners and slaves, as run by the modbus-server command.
+	ServerConfig struct {
+		// LogLevel is debug, info, warn or error, info if empty.
+		LogLevel  slog.Level       `json:"log_level,omitempty" yaml:"log_level,omitempty"`
+		Listeners []ListenerConfig `json:"listeners" yaml:"listeners"`
+		Slaves    []SlaveConfig    `json:"slaves,omitempty" yaml:"slaves,omitempty"`
+		// APIAddress serves the REST API if set, with APIToken if set.
+		APIAddress string `json:"api_address,omitempty" yaml:"api_address,omitempty"`
+		APIToken   string `json:"api_token,omitempty" yaml:"api_token,omitempty"`
+		// MetricsAddress serves the Prometheus metrics if set.
+		MetricsAddress string `json:"metrics_address,omitempty" yaml:"metrics_address,omitempty"`
+	}
+	// ListenerConfig describes a listener: ListenerTCP, ListenerTLS or ListenerRTUOverTCP on an
+	// "address:port", or ListenerRTU on a serial device.
+	ListenerConfig struct {
+		Type    string `json:"type" yaml:"type"`
+		Address string `json:"address" yaml:"address"`
+		// CertFile and KeyFile are the PEM certificate and key of a TLS listener. With ClientCAFile, clients
+		// must present a certificate signed by one of its authorities.
+		CertFile     string `json:"cert_file,omitempty" yaml:"cert_file,omitempty"`
+		KeyFile      string `json:"key_file,omitempty" yaml:"key_file,omitempty"`
+		ClientCAFile string `json:"client_ca_file,omitempty" yaml:"client_ca_file,omitempty"`
+		// BaudRate (19200 if zero), DataBits (8 if zero), StopBits (1 if zero) and Parity (N, E or O, E if
+		// empty) configure a serial device.
+		BaudRate int    `json:"baud_rate,omitempty" yaml:"baud_rate,omitempty"`
+		DataBits int    `json:"data_bits,omitempty" yaml:"data_bits,omitempty"`
+		StopBits int    `json:"stop_bits,omitempty" yaml:"stop_bits,omitempty"`
+		Parity   string `json:"parity,omitempty" yaml:"parity,omitempty"`
+	}
+	// SlaveConfig describes a slave and its initial values, optionally created from a device profile file
+	// with the instance Name, AddressOffset and Parameters. The tags and generators of the profile are
+	// not used.
+	SlaveConfig struct {
+		ID            uint8             `json:"id" yaml:"id"`
+		Profile       string            `json:"profile,omitempty" yaml:"profile,omitempty"`
+		Name          string            `json:"name,omitempty" yaml:"name,omitempty"`
+		AddressOffset uint16            `json:"address_offset,omitempty" yaml:"address_offset,omitempty"`
+		Parameters    map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+		// Values are written after the values of the profile, at absolute addresses. They can't refer to
+		// tags.
+		Values []ProfileValue `json:"values,omitempty" yaml:"values,omitempty"`
+		// Stopped slaves don't respond until started.
+		Stopped bool `json:"stopped,omitempty" yaml:"stopped,omitempty"`
+	}
+)
+
+// LoadServerConfigFile reads a server configuration from a JSON or YAML file, chosen by extension.
+// Unknown fields are errors. Relative paths of profiles and TLS files are resolved from the directory
+// of the file.
+func LoadServerConfigFile(path string) (config ServerConfig, err error) {
+	if config, err = loadConfigFile[ServerConfig](path, "server config"); err != nil {
+		return
+	}
+	resolve := func(file *string) {
+		if *file != "" && !filepath.IsAbs(*file) {
+			*file = filepath.Join(filepath.Dir(path), *file)
+		}
+	}
+	for i := range config.Listeners {
+		resolve(&config.Listeners[i].CertFile)
+		resolve(&config.Listeners[i].KeyFile)
+		resolve(&config.Listeners[i].ClientCAFile)
+	}
+	for i := range config.Slaves {
+		resolve(&config.Slaves[i].Profile)
+	}
+	return
+}
+
+// Validate checks the listeners and slaves. All errors are reported together.
+func (c *ServerConfig) Validate() error {
+	var errs []error
+	if len(c.Listeners) == 0 {
+		errs = append(errs, errors.New("no listeners"))
+	}
+	var addresses []string
+	for i, listener := range c.Listeners {
+		if err := listener.Validate(); err != nil {
+			for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+				errs = append(errs, fmt.Errorf("listener %d: %w", i+1, err))
+			}
+		}
+		if slices.Contains(addresses, listener.Address) {
+			errs = append(errs, fmt.Errorf("listener %d: address %s already used", i+1, listener.Address))
+		}
+		addresses = append(addresses, listener.Address)
+	}
+	var ids []uint8
+	for _, slave := range c.Slaves {
+		if slices.Contains(ids, slave.ID) {
+			errs = append(errs, fmt.Errorf("slave %d: defined twice", slave.ID))
+		}
+		ids = append(ids, slave.ID)
+		if slave.Profile == "" && (slave.Name != "" || slave.AddressOffset != 0 || len(slave.Parameters) != 0) {
+			errs = append(errs, fmt.Errorf("slave %d: name, address offset and parameters need a profile", slave.ID))
+		}
+		for i, value := range slave.Values {
+			if _, err := value.resolve(nil, func(text string) string { return text }); err != nil {
+				errs = append(errs, fmt.Errorf("slave %d: value %d: %w", slave.ID, i+1, err))
+			}
+		}
+	}
+	if c.APIToken != "" && c.APIAddress == "" {
+		errs = append(errs, errors.New("API token without API address"))
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the type, address and settings of the listener.
+func (l *ListenerConfig) Validate() error {
+	var errs []error
+	if l.Address == "" {
+		errs = append(errs, errors.New("empty address"))
+	}
+	switch l.Type {
+	case ListenerTCP, ListenerTLS, ListenerRTUOverTCP, ListenerRTU:
+	default:
+		errs = append(errs, fmt.Errorf("unknown type %q (must be %s, %s, %s or %s)", l.Type, ListenerTCP, ListenerTLS, ListenerRTUOverTCP, ListenerRTU))
+	}
+	if l.Type == ListenerTLS {
+		if l.CertFile == "" || l.KeyFile == "" {
+			errs = append(errs, errors.New("TLS needs a certificate and a key file"))
+		}
+	} else if l.CertFile != "" || l.KeyFile != "" || l.ClientCAFile != "" {
+		errs = append(errs, fmt.Errorf("certificate files are only used by %s listeners", ListenerTLS))
+	}
+	if l.Type == ListenerRTU {
+		if l.BaudRate < 0 {
+			errs = append(errs, fmt.Errorf("invalid baud rate %d", l.BaudRate))
+		}
+		if l.DataBits != 0 && (l.DataBits < 5 || l.DataBits > 8) {
+			errs = append(errs, fmt.Errorf("invalid data bits %d (must be 5 to 8)", l.DataBits))
+		}
+		if l.StopBits != 0 && l.StopBits != 1 && l.StopBits != 2 {
+			errs = append(errs, fmt.Errorf("invalid stop bits %d (must be 1 or 2)", l.StopBits))
+		}
+		if !slices.Contains([]string{"", "N", "E", "O"}, l.Parity) {
+			errs = append(errs, fmt.Errorf("invalid parity %q (must be N, E or O)", l.Parity))
+		}
+	} else if l.BaudRate != 0 || l.DataBits != 0 || l.StopBits != 0 || l.Parity != "" {
+		errs = append(errs, fmt.Errorf("serial settings are only used by %s listeners", ListenerRTU))
+	}
+	return errors.Join(errs...)
+}
+
+// NewServerFromConfig validates the configuration and creates the server with its slaves, then starts
+// the listeners and the REST API and metrics endpoints. The server is closed if any fails.
+func NewServerFromConfig(config ServerConfig, logger slog.Logger) (*Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	s := NewServer(logger)
+	if err := s.initConfigSlaves(config.Slaves); err != nil {
+		s.Close()
+		return nil, err
+	}
+	for i, listener := range config.Listeners {
+		if err := s.listenConfig(listener); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("listener %d: %s %s: %w", i+1, listener.Type, listener.Address, err)
+		}
+	}
+	if config.APIAddress != "" {
+		if err := s.ListenAPI(config.APIAddress, config.APIToken); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("API %s: %w", config.APIAddress, err)
+		}
+	}
+	if config.MetricsAddress != "" {
+		if err := s.ListenMetrics(config.MetricsAddress); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("metrics %s: %w", config.MetricsAddress, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Server) initConfigSlaves(slaves []SlaveConfig) error {
+	for _, slave := range slaves {
+		if slave.Profile != "" {
+			profile, err := LoadDeviceProfileFile(slave.Profile)
+			if err != nil {
+				return fmt.Errorf("slave %d: %w", slave.ID, err)
+			}
+			instance := DeviceInstance{Slave: slave.ID, Name: slave.Name, AddressOffset: slave.AddressOffset, Parameters: slave.Parameters}
+			if err = s.InitSlaveFromProfile(profile, instance, nil, nil); err != nil {
+				return fmt.Errorf("slave %d: %w", slave.ID, err)
+			}
+		} else {
+			s.InitSlave(slave.ID)
+		}
+		for i, value := range slave.Values {
+			write, _ := value.resolve(nil, func(text string) string { return text })
+			if err := s.WriteTable(slave.ID, write.Table, write.Address, write.Values); err != nil {
+				return fmt.Errorf("slave %d: value %d: %w", slave.ID, i+1, err)
+			}
+		}
+		if slave.Stopped {
+			s.SlaveStopResponse(slave.ID)
+		}
+	}
+	return nil
+}
+
+func (s *Server) listenConfig(listener ListenerConfig) error {
+	switch listener.Type {
+	case ListenerTCP:
+		return s.ListenTCP(listener.Address)
+	case ListenerTLS:
+		config, err := listener.tlsConfig()
+		if err != nil {
+			return err
+		}
+		return s.ListenTLS(listener.Address, config)
+	case ListenerRTUOverTCP:
+		return s.ListenRTUOverTCP(listener.Address)
+	default:
+		return s.ListenRTU(&serial.Config{
+			Address:  listener.Address,
+			BaudRate: listener.BaudRate,
+			DataBits: listener.DataBits,
+			StopBits: listener.StopBits,
+			Parity:   listener.Parity,
+		})
+	}
+}
+
+func (l *ListenerConfig) tlsConfig() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	if l.ClientCAFile != "" {
+		data, err := os.ReadFile(l.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no PEM certificates", l.ClientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
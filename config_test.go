@@ -0,0 +1,175 @@
+package modbusserver
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	tcpAddr, rtuAddr := getFreePort(), getFreePort()
+	os.WriteFile(filepath.Join(dir, "meter.yaml"), []byte(`
+name: meter
+identification:
+  vendor_name: Acme
+values:
+  - {table: holding_registers, address: 0, value: "${model}"}
+`), 0o644)
+	path := filepath.Join(dir, "server.yaml")
+	os.WriteFile(path, []byte(`
+log_level: warn
+listeners:
+  - {type: tcp, address: "`+tcpAddr+`"}
+  - {type: rtu_over_tcp, address: "`+rtuAddr+`"}
+slaves:
+  - id: 1
+    values:
+      - {table: holding_registers, address: 10, type: float32, value: "1.5"}
+      - {table: coils, address: 3, value: "1"}
+  - id: 2
+    profile: meter.yaml
+    address_offset: 100
+    parameters: {model: "42"}
+    stopped: true
+`), 0o644)
+	config, err := LoadServerConfigFile(path)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if config.LogLevel != slog.LevelWarn || config.Slaves[1].Profile != filepath.Join(dir, "meter.yaml") {
+		t.Errorf("expected warn level and resolved profile path, got %+v", config)
+	}
+	s, err := NewServerFromConfig(config, *slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer s.Close()
+
+	if value, err := s.ReadValue(1, TableHoldingRegisters, 10, TypeFloat32, ""); err != nil || value != 1.5 {
+		t.Errorf("expected 1.5, got %v, %v", value, err)
+	}
+	if coils, _ := s.ReadTable(1, TableCoils, 3, 1); coils[0] != 1 {
+		t.Errorf("expected coil set, got %v", coils)
+	}
+	if values, _ := s.ReadTable(2, TableHoldingRegisters, 100, 1); values[0] != 42 {
+		t.Errorf("expected profile value 42, got %v", values)
+	}
+	if s.slaveResponds(1) == s.slaveResponds(2) {
+		t.Errorf("expected slave 2 stopped")
+	}
+	if addresses := s.ListenerAddresses(); !slices.Equal(addresses, []string{tcpAddr, rtuAddr}) {
+		t.Errorf("expected listeners %s and %s, got %v", tcpAddr, rtuAddr, addresses)
+	}
+	client, err := DialRTUOverTCPClient(rtuAddr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer client.Close()
+	if values, err := client.ReadHoldingRegisters(1, 10, 2); err != nil || values[0] != 0x3FC0 {
+		t.Errorf("expected float32 registers, got %v, %v", values, err)
+	}
+}
+
+func TestServerConfigRTU(t *testing.T) {
+	master, device := openPTY(t)
+	defer master.Close()
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	os.WriteFile(path, []byte(`
+listeners:
+  - {type: rtu, address: "`+device+`", baud_rate: 115200, parity: "N", stop_bits: 1}
+slaves:
+  - id: 1
+    values:
+      - {table: holding_registers, address: 0, value: "7"}
+`), 0o644)
+	config, err := LoadServerConfigFile(path)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	s, err := NewServerFromConfig(config, *slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	request := &RTUFrame{SlaveId: 1, Function: 3}
+	SetDataWithRegisterAndNumber(request, 0, 1)
+	if _, err = master.Write(request.Bytes()); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	response := make([]byte, 7)
+	master.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = io.ReadFull(master, response); err != nil || !slices.Equal(response[:5], []byte{1, 3, 2, 0, 7}) {
+		t.Errorf("expected register 7, got %v, %v", response, err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Close to return without a frame on the serial device")
+	}
+}
+
+func TestServerConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"unknown.yaml": "listeners: []\nslave: []\n",
+		"level.yaml":   "log_level: verbose\n",
+		"unknown.json": `{"listeners": [], "port": 502}`,
+		"server.txt":   "",
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := LoadServerConfigFile(path); err == nil || !strings.HasPrefix(err.Error(), path) {
+			t.Errorf("%s: expected error with the path, got %v", name, err)
+		}
+	}
+
+	config := ServerConfig{
+		Listeners: []ListenerConfig{
+			{Type: "udp", Address: "localhost:502"},
+			{Type: ListenerTCP, Address: "localhost:502", Parity: "E"},
+			{Type: ListenerTLS, Address: "localhost:802"},
+			{Type: ListenerRTU, Address: "/dev/ttyUSB0", DataBits: 9, Parity: "X"},
+		},
+		Slaves: []SlaveConfig{
+			{ID: 1, Name: "pump"},
+			{ID: 1, Values: []ProfileValue{{Table: TableCoils, Type: TypeFloat32, Value: "1"}, {Tag: "level", Value: "1"}}},
+		},
+		APIToken: "secret",
+	}
+	err := config.Validate()
+	if err == nil || len(err.(interface{ Unwrap() []error }).Unwrap()) != 11 {
+		t.Errorf("expected 11 errors, got %v", err)
+	}
+	if (&ServerConfig{}).Validate() == nil {
+		t.Errorf("expected error without listeners")
+	}
+
+	// Listeners failing to start close the server.
+	logger := *slog.New(slog.NewTextHandler(io.Discard, nil))
+	tcpAddr := getFreePort()
+	_, err = NewServerFromConfig(ServerConfig{Listeners: []ListenerConfig{
+		{Type: ListenerTCP, Address: tcpAddr},
+		{Type: ListenerTLS, Address: getFreePort(), CertFile: filepath.Join(dir, "missing.pem"), KeyFile: filepath.Join(dir, "missing.key")},
+	}}, logger)
+	if err == nil || !strings.HasPrefix(err.Error(), "listener 2: tls ") {
+		t.Errorf("expected TLS listener error, got %v", err)
+	}
+	s, err := NewServerFromConfig(ServerConfig{Listeners: []ListenerConfig{{Type: ListenerTCP, Address: tcpAddr}}}, logger)
+	if err != nil {
+		t.Errorf("expected address released, got %v", err)
+	} else {
+		s.Close()
+	}
+}
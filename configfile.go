@@ -0,0 +1,46 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile reads a value from a JSON or YAML file, chosen by extension. kind names the file in the
+// error of an unsupported extension.
+func loadConfigFile[T any](path string, kind string) (value T, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	return decodeConfigFile[T](path, kind, data)
+}
+
+// decodeConfigFile decodes the data of a JSON or YAML file. Unknown fields are errors, and an empty YAML
+// file gives the zero value.
+func decodeConfigFile[T any](path string, kind string, data []byte) (value T, err error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&value)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&value); err == io.EOF {
+			err = nil
+		}
+	default:
+		err = fmt.Errorf("unsupported %s file extension %q", kind, filepath.Ext(path))
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+	}
+	return
+}
@@ -0,0 +1,28 @@
+package modbusserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	directory := t.TempDir()
+	files := map[string]string{
+		"unknown.yaml": "- name: alarm\n  when: \"1\"\n  sett: coil(1, 7)\n",
+		"unknown.json": `[{"name": "alarm", "when": "1", "sett": "coil(1, 7)"}]`,
+		"empty.yaml":   "",
+		"rules.toml":   "",
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(directory, name), []byte(content), 0o644)
+	}
+	for _, name := range []string{"unknown.yaml", "unknown.json", "rules.toml", "missing.json"} {
+		if _, err := LoadRulesFile(filepath.Join(directory, name)); err == nil {
+			t.Errorf("%s: expected an error, got nil", name)
+		}
+	}
+	if rules, err := LoadRulesFile(filepath.Join(directory, "empty.yaml")); err != nil || len(rules) != 0 {
+		t.Errorf("empty.yaml: expected no rules, got %v, %v", rules, err)
+	}
+}
@@ -0,0 +1,199 @@
+package modbusserver
+
+import (
+	"fmt"
+	"math"
+)
+
+type (
+	// DataType describes how a value is stored in one or more consecutive registers.
+	DataType string
+	// ByteOrder describes the order of bytes of a multi byte value, "A" being the most significant byte.
+	ByteOrder string
+)
+
+const (
+	TypeBool    DataType = "bool"
+	TypeUint16  DataType = "uint16"
+	TypeInt16   DataType = "int16"
+	TypeUint32  DataType = "uint32"
+	TypeInt32   DataType = "int32"
+	TypeFloat32 DataType = "float32"
+	TypeUint64  DataType = "uint64"
+	TypeInt64   DataType = "int64"
+	TypeFloat64 DataType = "float64"
+
+	// OrderABCD is big endian, the Modbus default.
+	OrderABCD ByteOrder = "ABCD"
+	// OrderCDAB is big endian bytes with swapped words.
+	OrderCDAB ByteOrder = "CDAB"
+	// OrderBADC is big endian words with swapped bytes.
+	OrderBADC ByteOrder = "BADC"
+	// OrderDCBA is little endian.
+	OrderDCBA ByteOrder = "DCBA"
+)
+
+// Registers returns the number of 16 bit registers (or bits for bool) occupied by the data type.
+func (t DataType) Registers() int {
+	switch t {
+	case TypeBool, TypeUint16, TypeInt16:
+		return 1
+	case TypeUint32, TypeInt32, TypeFloat32:
+		return 2
+	case TypeUint64, TypeInt64, TypeFloat64:
+		return 4
+	}
+	return 0
+}
+
+// Validate checks that the data type is known.
+func (t DataType) Validate() error {
+	if t.Registers() == 0 {
+		return fmt.Errorf("unknown data type %q", t)
+	}
+	return nil
+}
+
+// Validate checks that the byte order is known. Empty byte order means OrderABCD.
+func (o ByteOrder) Validate() error {
+	switch o {
+	case "", OrderABCD, OrderCDAB, OrderBADC, OrderDCBA:
+		return nil
+	}
+	return fmt.Errorf("unknown byte order %q", o)
+}
+
+// EncodeValue converts a number to the registers holding it. Integer types are rounded to the nearest
+// integer and an error is returned if the value doesn't fit into the type.
+func EncodeValue(value float64, dataType DataType, order ByteOrder) (registers []uint16, err error) {
+	var raw uint64
+	switch dataType {
+	case TypeBool:
+		if value != 0 {
+			raw = 1
+		}
+	case TypeUint16, TypeUint32, TypeUint64:
+		value = math.Round(value)
+		if value < 0 || value >= math.Pow(2, float64(16*dataType.Registers())) {
+			err = fmt.Errorf("value %v out of %s range", value, dataType)
+			return
+		}
+		raw = uint64(value)
+	case TypeInt16, TypeInt32, TypeInt64:
+		value = math.Round(value)
+		limit := math.Pow(2, float64(16*dataType.Registers()-1))
+		if value < -limit || value >= limit {
+			err = fmt.Errorf("value %v out of %s range", value, dataType)
+			return
+		}
+		raw = uint64(int64(value))
+	case TypeFloat32:
+		raw = uint64(math.Float32bits(float32(value)))
+	case TypeFloat64:
+		raw = math.Float64bits(value)
+	default:
+		err = dataType.Validate()
+		return
+	}
+	if err = order.Validate(); err != nil {
+		return
+	}
+	count := dataType.Registers()
+	registers = make([]uint16, count)
+	for i := range registers {
+		registers[i] = uint16(raw >> (16 * (count - 1 - i)))
+	}
+	reorder(registers, order)
+	return
+}
+
+// DecodeValue converts registers holding a value of the data type to a number.
+func DecodeValue(registers []uint16, dataType DataType, order ByteOrder) (value float64, err error) {
+	count := dataType.Registers()
+	if count == 0 {
+		err = dataType.Validate()
+		return
+	}
+	if len(registers) < count {
+		err = fmt.Errorf("%s needs %d registers, got %d", dataType, count, len(registers))
+		return
+	}
+	if err = order.Validate(); err != nil {
+		return
+	}
+	ordered := make([]uint16, count)
+	copy(ordered, registers)
+	reorder(ordered, order)
+	var raw uint64
+	for _, register := range ordered {
+		raw = raw<<16 | uint64(register)
+	}
+	switch dataType {
+	case TypeBool:
+		if raw != 0 {
+			value = 1
+		}
+	case TypeUint16, TypeUint32, TypeUint64:
+		value = float64(raw)
+	case TypeInt16:
+		value = float64(int16(raw))
+	case TypeInt32:
+		value = float64(int32(raw))
+	case TypeInt64:
+		value = float64(int64(raw))
+	case TypeFloat32:
+		value = float64(math.Float32frombits(uint32(raw)))
+	case TypeFloat64:
+		value = math.Float64frombits(raw)
+	}
+	return
+}
+
+// reorder converts registers between big endian and the byte order. The conversion is symmetric.
+func reorder(registers []uint16, order ByteOrder) {
+	if order == OrderBADC || order == OrderDCBA {
+		for i, register := range registers {
+			registers[i] = register<<8 | register>>8
+		}
+	}
+	if order == OrderCDAB || order == OrderDCBA {
+		for i, j := 0, len(registers)-1; i < j; i, j = i+1, j-1 {
+			registers[i], registers[j] = registers[j], registers[i]
+		}
+	}
+}
+
+// ReadValue reads a typed value from a slave table. Coils and discrete inputs only support TypeBool.
+func (s *Server) ReadValue(id uint8, table Table, address uint16, dataType DataType, order ByteOrder) (value float64, err error) {
+	if err = checkBitType(table, dataType); err != nil {
+		return
+	}
+	s.memoryMutex.RLock()
+	registers, err := s.readRaw(id, table, address, dataType.Registers())
+	s.memoryMutex.RUnlock()
+	if err != nil {
+		return
+	}
+	return DecodeValue(registers, dataType, order)
+}
+
+// WriteValue writes a typed value to a slave table, bypassing write validation rules.
+func (s *Server) WriteValue(id uint8, table Table, address uint16, dataType DataType, order ByteOrder, value float64) (err error) {
+	if err = checkBitType(table, dataType); err != nil {
+		return
+	}
+	registers, err := EncodeValue(value, dataType, order)
+	if err != nil {
+		return
+	}
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	return s.writeRaw(id, table, address, registers)
+}
+
+func checkBitType(table Table, dataType DataType) error {
+	if (table == TableCoils || table == TableDiscreteInputs) && dataType != TypeBool {
+		return fmt.Errorf("%s hold single bits only, got %s", table, dataType)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package modbusserver
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEncodeValue(t *testing.T) {
+	for _, test := range []struct {
+		value    float64
+		dataType DataType
+		order    ByteOrder
+		expect   []uint16
+	}{
+		{1, TypeUint16, "", []uint16{1}},
+		{-2, TypeInt16, OrderABCD, []uint16{0xFFFE}},
+		{1.5, TypeFloat32, OrderABCD, []uint16{0x3FC0, 0x0000}},
+		{1.5, TypeFloat32, OrderCDAB, []uint16{0x0000, 0x3FC0}},
+		{1.5, TypeFloat32, OrderBADC, []uint16{0xC03F, 0x0000}},
+		{1.5, TypeFloat32, OrderDCBA, []uint16{0x0000, 0xC03F}},
+		{0x01020304, TypeUint32, OrderABCD, []uint16{0x0102, 0x0304}},
+		{-1, TypeInt64, OrderABCD, []uint16{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF}},
+	} {
+		got, err := EncodeValue(test.value, test.dataType, test.order)
+		if err != nil {
+			t.Errorf("%v %s %s: expected nil, got %v", test.value, test.dataType, test.order, err)
+			continue
+		}
+		if !slices.Equal(test.expect, got) {
+			t.Errorf("%v %s %s: expected %04x, got %04x", test.value, test.dataType, test.order, test.expect, got)
+		}
+		value, err := DecodeValue(got, test.dataType, test.order)
+		if err != nil || value != test.value {
+			t.Errorf("%v %s %s: decoded %v, %v", test.value, test.dataType, test.order, value, err)
+		}
+	}
+}
+
+func TestEncodeValueRange(t *testing.T) {
+	for _, test := range []struct {
+		value    float64
+		dataType DataType
+	}{
+		{65536, TypeUint16},
+		{-1, TypeUint32},
+		{32768, TypeInt16},
+		{1, "int8"},
+	} {
+		if _, err := EncodeValue(test.value, test.dataType, ""); err == nil {
+			t.Errorf("%v %s: expected error, got nil", test.value, test.dataType)
+		}
+	}
+}
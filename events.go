@@ -0,0 +1,193 @@
+package modbusserver
+
+import (
+	"slices"
+	"time"
+)
+
+type (
+	// EventType is the kind of a server event.
+	EventType string
+	// Event is a change of the server state. Slave is set for writes and slave events, Table, Address and
+	// Values for writes, Listener and Remote for connection events.
+	Event struct {
+		// Sequence numbers the recorded events from 1. It is 0 for EventLost.
+		Sequence uint64    `json:"sequence,omitempty"`
+		Time     time.Time `json:"time"`
+		Type     EventType `json:"type"`
+		Slave    *uint8    `json:"slave,omitempty"`
+		Table    *Table    `json:"table,omitempty"`
+		Address  *uint16   `json:"address,omitempty"`
+		Values   []uint16  `json:"values,omitempty"`
+		Listener string    `json:"listener,omitempty"`
+		Remote   string    `json:"remote,omitempty"`
+	}
+	// EventFilter selects the events of a subscription. Zero fields select everything; a Slave, Table or
+	// range filter excludes the events without a slave, table or range. A range of Quantity addresses from
+	// Address selects the writes overlapping it.
+	EventFilter struct {
+		Types    []EventType
+		Slave    *uint8
+		Table    *Table
+		Address  uint16
+		Quantity int
+	}
+	// eventLog keeps the last events and delivers the new ones to the subscribers.
+	eventLog struct {
+		sequence    uint64
+		history     []Event
+		size        int
+		subscribers map[chan Event]EventFilter
+	}
+)
+
+const (
+	EventWrite      EventType = "write"
+	EventConnect    EventType = "connect"
+	EventDisconnect EventType = "disconnect"
+	EventSlaveStart EventType = "slave_start"
+	EventSlaveStop  EventType = "slave_stop"
+	// EventLost tells that events were missed: the subscriber didn't keep up, or the events to resume
+	// from are no longer kept. A subscription ends after it, unless it starts a resumed subscription.
+	EventLost EventType = "lost"
+)
+
+const (
+	// DefaultEventHistory is the number of events kept for resumed subscriptions when events are enabled
+	// by the first subscription or by the REST API.
+	DefaultEventHistory = 1000
+	eventBuffer         = 256
+)
+
+// EnableEvents starts recording the server events, keeping the last history events for subscriptions
+// resuming from a sequence number. Called again, it only changes the history size.
+func (s *Server) EnableEvents(history int) {
+	s.eventsMutex.Lock()
+	defer s.eventsMutex.Unlock()
+	if s.events == nil {
+		s.events = &eventLog{subscribers: make(map[chan Event]EventFilter)}
+	}
+	s.events.size = max(history, 0)
+	if len(s.events.history) > s.events.size {
+		s.events.history = slices.Clone(s.events.history[len(s.events.history)-s.events.size:])
+	}
+}
+
+// enableEvents enables the events with the default history unless they are enabled.
+func (s *Server) enableEvents() {
+	s.eventsMutex.Lock()
+	enabled := s.events != nil
+	s.eventsMutex.Unlock()
+	if !enabled {
+		s.EnableEvents(DefaultEventHistory)
+	}
+}
+
+// SubscribeEvents returns the events selected by the filter until cancel is called. With since, the kept
+// events following that sequence number come first, preceded by EventLost if some are no longer kept. A
+// subscriber not keeping up gets EventLost and its channel is closed; it can subscribe again from the
+// sequence number of the last event it got. Closing the server closes the channel too.
+func (s *Server) SubscribeEvents(filter EventFilter, since *uint64) (events <-chan Event, cancel func()) {
+	s.enableEvents()
+	s.eventsMutex.Lock()
+	defer s.eventsMutex.Unlock()
+	log := s.events
+	channel := make(chan Event, eventBuffer)
+	if since != nil {
+		// The channel has room for all the kept events, the lost event and the following events.
+		channel = make(chan Event, len(log.history)+eventBuffer)
+		oldest := log.sequence + 1
+		if len(log.history) != 0 {
+			oldest = log.history[0].Sequence
+		}
+		if *since+1 < oldest || *since > log.sequence {
+			channel <- Event{Time: time.Now(), Type: EventLost}
+		}
+		for _, event := range log.history {
+			if event.Sequence > *since && filter.matches(event) {
+				channel <- event
+			}
+		}
+	}
+	log.subscribers[channel] = filter
+	return channel, func() {
+		s.eventsMutex.Lock()
+		defer s.eventsMutex.Unlock()
+		if _, ok := log.subscribers[channel]; ok {
+			delete(log.subscribers, channel)
+			close(channel)
+		}
+	}
+}
+
+// closeEvents ends the subscriptions.
+func (s *Server) closeEvents() {
+	s.eventsMutex.Lock()
+	defer s.eventsMutex.Unlock()
+	if s.events == nil {
+		return
+	}
+	for channel := range s.events.subscribers {
+		close(channel)
+	}
+	clear(s.events.subscribers)
+}
+
+// emit records the event and delivers it to the subscribers, unless the events are disabled.
+func (s *Server) emit(event Event) {
+	s.eventsMutex.Lock()
+	defer s.eventsMutex.Unlock()
+	log := s.events
+	if log == nil {
+		return
+	}
+	log.sequence++
+	event.Sequence, event.Time = log.sequence, time.Now()
+	if log.size > 0 {
+		if len(log.history) == log.size {
+			log.history = slices.Delete(log.history, 0, 1)
+		}
+		log.history = append(log.history, event)
+	}
+	for channel, filter := range log.subscribers {
+		if filter.matches(event) && !deliver(channel, event) {
+			delete(log.subscribers, channel)
+			close(channel)
+		}
+	}
+}
+
+// emitWrite emits the write event.
+func (s *Server) emitWrite(write WriteEvent) {
+	s.emit(Event{Type: EventWrite, Slave: &write.Slave, Table: &write.Table, Address: &write.Address, Values: slices.Clone(write.Values)})
+}
+
+// deliver sends the event without blocking, or EventLost when only its room is left in the channel.
+func deliver(channel chan Event, event Event) bool {
+	if len(channel) >= cap(channel)-1 {
+		channel <- Event{Time: time.Now(), Type: EventLost}
+		return false
+	}
+	channel <- event
+	return true
+}
+
+func (f EventFilter) matches(event Event) bool {
+	if len(f.Types) != 0 && !slices.Contains(f.Types, event.Type) {
+		return false
+	}
+	if f.Slave != nil && (event.Slave == nil || *event.Slave != *f.Slave) {
+		return false
+	}
+	if f.Table != nil && (event.Table == nil || *event.Table != *f.Table) {
+		return false
+	}
+	if f.Quantity > 0 {
+		if event.Address == nil {
+			return false
+		}
+		start, end := int(*event.Address), int(*event.Address)+len(event.Values)
+		return start < int(f.Address)+f.Quantity && int(f.Address) < end
+	}
+	return true
+}
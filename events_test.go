@@ -0,0 +1,153 @@
+package modbusserver
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event of the channel, failing if none comes.
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatalf("expected an event, got closed channel")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected an event")
+	}
+	return Event{}
+}
+
+func TestEvents(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	s.WriteTable(1, TableCoils, 0, []uint16{1})
+	events, cancel := s.SubscribeEvents(EventFilter{}, nil)
+
+	s.WriteTable(1, TableHoldingRegisters, 5, []uint16{1, 2})
+	if event := nextEvent(t, events); event.Sequence != 1 || event.Type != EventWrite || *event.Slave != 1 ||
+		*event.Table != TableHoldingRegisters || *event.Address != 5 || !slices.Equal(event.Values, []uint16{1, 2}) {
+		t.Errorf("expected write event, got %+v", event)
+	}
+	s.SlaveStopResponse(1)
+	s.SlaveStopResponse(1)
+	s.SlaveStartResponse(1)
+	if event := nextEvent(t, events); event.Type != EventSlaveStop || *event.Slave != 1 {
+		t.Errorf("expected slave stop event, got %+v", event)
+	}
+	if event := nextEvent(t, events); event.Sequence != 3 || event.Type != EventSlaveStart {
+		t.Errorf("expected slave start event, got %+v", event)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	remote := conn.LocalAddr().String()
+	if event := nextEvent(t, events); event.Type != EventConnect || event.Listener != addr || event.Remote != remote {
+		t.Errorf("expected connect event, got %+v", event)
+	}
+	conn.Close()
+	if event := nextEvent(t, events); event.Type != EventDisconnect || event.Remote != remote {
+		t.Errorf("expected disconnect event, got %+v", event)
+	}
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("expected closed channel")
+	}
+	cancel()
+
+	events, _ = s.SubscribeEvents(EventFilter{}, nil)
+	s.Close()
+	if _, ok := <-events; ok {
+		t.Errorf("expected channel closed with the server")
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	slave, table := uint8(1), TableHoldingRegisters
+	write := func(id uint8, table Table, address uint16, quantity int) Event {
+		return Event{Type: EventWrite, Slave: &id, Table: &table, Address: &address, Values: make([]uint16, quantity)}
+	}
+	connect := Event{Type: EventConnect, Remote: "127.0.0.1:5000"}
+	for _, test := range []struct {
+		filter   EventFilter
+		event    Event
+		expected bool
+	}{
+		{EventFilter{}, connect, true},
+		{EventFilter{Types: []EventType{EventWrite}}, connect, false},
+		{EventFilter{Types: []EventType{EventWrite, EventConnect}}, connect, true},
+		{EventFilter{Slave: &slave}, connect, false},
+		{EventFilter{Slave: &slave}, write(1, TableCoils, 0, 1), true},
+		{EventFilter{Slave: &slave}, write(2, TableCoils, 0, 1), false},
+		{EventFilter{Slave: &slave}, Event{Type: EventSlaveStop, Slave: &slave}, true},
+		{EventFilter{Table: &table}, Event{Type: EventSlaveStop, Slave: &slave}, false},
+		{EventFilter{Table: &table}, write(1, TableInputRegisters, 0, 1), false},
+		{EventFilter{Address: 10, Quantity: 5}, write(1, TableCoils, 8, 2), false},
+		{EventFilter{Address: 10, Quantity: 5}, write(1, TableCoils, 8, 3), true},
+		{EventFilter{Address: 10, Quantity: 5}, write(1, TableCoils, 14, 3), true},
+		{EventFilter{Address: 10, Quantity: 5}, write(1, TableCoils, 15, 1), false},
+		{EventFilter{Address: 10, Quantity: 5}, Event{Type: EventSlaveStop, Slave: &slave}, false},
+	} {
+		if actual := test.filter.matches(test.event); actual != test.expected {
+			t.Errorf("filter %+v, event %+v: expected %v, got %v", test.filter, test.event, test.expected, actual)
+		}
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.EnableEvents(3)
+	for i := range 5 {
+		s.WriteTable(1, TableHoldingRegisters, uint16(i), []uint16{1})
+	}
+	for _, test := range []struct {
+		since    uint64
+		expected []uint64
+	}{
+		{0, []uint64{0, 3, 4, 5}},
+		{1, []uint64{0, 3, 4, 5}},
+		{2, []uint64{3, 4, 5}},
+		{4, []uint64{5}},
+		{5, nil},
+		{100, []uint64{0}},
+	} {
+		events, cancel := s.SubscribeEvents(EventFilter{}, &test.since)
+		cancel()
+		var sequences []uint64
+		for event := range events {
+			if (event.Sequence == 0) != (event.Type == EventLost) {
+				t.Errorf("since %d: unexpected event %+v", test.since, event)
+			}
+			sequences = append(sequences, event.Sequence)
+		}
+		if !slices.Equal(sequences, test.expected) {
+			t.Errorf("since %d: expected %v, got %v", test.since, test.expected, sequences)
+		}
+	}
+
+	// A subscriber not keeping up loses the events and its subscription.
+	events, cancel := s.SubscribeEvents(EventFilter{}, nil)
+	defer cancel()
+	for i := range eventBuffer + 10 {
+		s.WriteTable(1, TableHoldingRegisters, 0, []uint16{uint16(i)})
+	}
+	var received []Event
+	for event := range events {
+		received = append(received, event)
+	}
+	if len(received) != eventBuffer || received[len(received)-1].Type != EventLost || received[len(received)-2].Sequence != 5+eventBuffer-1 {
+		t.Errorf("expected %d events ending with a lost event, got %d", eventBuffer, len(received))
+	}
+}
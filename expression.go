@@ -0,0 +1,388 @@
+package modbusserver
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type (
+	// Reference addresses a typed value of a slave table in expressions, written as
+	// table(slave, address[, type[, byte order]]) with table being coil, di, hr or ir, for example
+	// hr(1, 10, float32, CDAB). The type defaults to bool for bit tables and uint16 for registers.
+	Reference struct {
+		Slave     uint8
+		Table     Table
+		Address   uint16
+		Type      DataType
+		ByteOrder ByteOrder
+	}
+	// expression is a compiled expression evaluated over slave memory. Booleans are 1 and 0.
+	expression interface {
+		eval(memory memoryReader) (float64, error)
+	}
+	memoryReader interface {
+		ReadValue(id uint8, table Table, address uint16, dataType DataType, order ByteOrder) (float64, error)
+	}
+	numberNode    float64
+	referenceNode Reference
+	unaryNode     struct {
+		operator string
+		operand  expression
+	}
+	binaryNode struct {
+		operator    string
+		left, right expression
+	}
+	callNode struct {
+		function  string
+		arguments []expression
+	}
+	expressionParser struct {
+		tokens   []string
+		position int
+	}
+)
+
+var (
+	referenceTables = map[string]Table{
+		"coil": TableCoils,
+		"di":   TableDiscreteInputs,
+		"hr":   TableHoldingRegisters,
+		"ir":   TableInputRegisters,
+	}
+	binaryPrecedence = map[string]int{
+		"||": 1,
+		"&&": 2,
+		"==": 3, "!=": 3,
+		"<": 4, "<=": 4, ">": 4, ">=": 4,
+		"+": 5, "-": 5,
+		"*": 6, "/": 6, "%": 6,
+	}
+	expressionFunctions = map[string]int{"abs": 1, "min": 2, "max": 2, "round": 1}
+)
+
+// ParseReference parses a reference like hr(1, 10, float32).
+func ParseReference(text string) (reference Reference, err error) {
+	node, err := parseExpression(text)
+	if err != nil {
+		return
+	}
+	current, ok := node.(referenceNode)
+	if !ok {
+		err = fmt.Errorf("%q isn't a reference", text)
+		return
+	}
+	return Reference(current), nil
+}
+
+// String formats the reference in expression syntax.
+func (r Reference) String() string {
+	for name, table := range referenceTables {
+		if table == r.Table {
+			return fmt.Sprintf("%s(%d, %d, %s, %s)", name, r.Slave, r.Address, r.Type, r.ByteOrder)
+		}
+	}
+	return fmt.Sprintf("unknown(%d, %d)", r.Slave, r.Address)
+}
+
+func parseExpression(text string) (node expression, err error) {
+	tokens, err := tokenizeExpression(text)
+	if err != nil {
+		return
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	parser := &expressionParser{tokens: tokens}
+	if node, err = parser.parse(0); err != nil {
+		return nil, fmt.Errorf("%q: %w", text, err)
+	}
+	if parser.position != len(tokens) {
+		return nil, fmt.Errorf("%q: unexpected %q", text, tokens[parser.position])
+	}
+	return
+}
+
+func tokenizeExpression(text string) (tokens []string, err error) {
+	for i := 0; i < len(text); {
+		character := rune(text[i])
+		switch {
+		case unicode.IsSpace(character):
+			i++
+		case unicode.IsDigit(character) || character == '.':
+			start := i
+			for i < len(text) && (unicode.IsDigit(rune(text[i])) || text[i] == '.' || text[i] == 'e' || text[i] == 'E' ||
+				((text[i] == '+' || text[i] == '-') && (text[i-1] == 'e' || text[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		case unicode.IsLetter(character) || character == '_':
+			start := i
+			for i < len(text) && (unicode.IsLetter(rune(text[i])) || unicode.IsDigit(rune(text[i])) || text[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		case i+1 < len(text) && slices.Contains([]string{"&&", "||", "==", "!=", "<=", ">="}, text[i:i+2]):
+			tokens = append(tokens, text[i:i+2])
+			i += 2
+		case strings.ContainsRune("+-*/%<>!(),", character):
+			tokens = append(tokens, string(character))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q in %q", character, text)
+		}
+	}
+	return
+}
+
+func (p *expressionParser) peek() string {
+	if p.position < len(p.tokens) {
+		return p.tokens[p.position]
+	}
+	return ""
+}
+
+func (p *expressionParser) next() string {
+	token := p.peek()
+	p.position++
+	return token
+}
+
+func (p *expressionParser) expect(token string) error {
+	if got := p.next(); got != token {
+		return fmt.Errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func (p *expressionParser) parse(precedence int) (left expression, err error) {
+	if left, err = p.parseUnary(); err != nil {
+		return
+	}
+	for {
+		operator := p.peek()
+		current, ok := binaryPrecedence[operator]
+		if !ok || current <= precedence {
+			return
+		}
+		p.next()
+		var right expression
+		if right, err = p.parse(current); err != nil {
+			return
+		}
+		left = binaryNode{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *expressionParser) parseUnary() (expression, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "-" || token == "!":
+		operand, err := p.parseUnary()
+		return unaryNode{operator: token, operand: operand}, err
+	case token == "(":
+		node, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	case token == "true":
+		return numberNode(1), nil
+	case token == "false":
+		return numberNode(0), nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		value, err := strconv.ParseFloat(token, 64)
+		return numberNode(value), err
+	}
+	if table, ok := referenceTables[token]; ok {
+		return p.parseReference(table)
+	}
+	if count, ok := expressionFunctions[token]; ok {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		call := callNode{function: token}
+		for i := 0; i < count; i++ {
+			if i != 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			argument, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			call.arguments = append(call.arguments, argument)
+		}
+		return call, p.expect(")")
+	}
+	return nil, fmt.Errorf("unexpected %q", token)
+}
+
+func (p *expressionParser) parseReference(table Table) (expression, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var arguments []string
+	for {
+		arguments = append(arguments, p.next())
+		if separator := p.next(); separator == ")" {
+			break
+		} else if separator != "," {
+			return nil, fmt.Errorf("expected \",\" or \")\", got %q", separator)
+		}
+	}
+	if len(arguments) < 2 || len(arguments) > 4 {
+		return nil, fmt.Errorf("reference needs slave, address and optionally type and byte order")
+	}
+	slave, err := strconv.ParseUint(arguments[0], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid slave %q", arguments[0])
+	}
+	address, err := strconv.ParseUint(arguments[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q", arguments[1])
+	}
+	reference := Reference{Slave: uint8(slave), Table: table, Address: uint16(address), Type: TypeUint16, ByteOrder: OrderABCD}
+	if table == TableCoils || table == TableDiscreteInputs {
+		reference.Type = TypeBool
+	}
+	if len(arguments) > 2 {
+		reference.Type = DataType(arguments[2])
+	}
+	if len(arguments) > 3 {
+		reference.ByteOrder = ByteOrder(arguments[3])
+	}
+	if err = reference.Type.Validate(); err != nil {
+		return nil, err
+	}
+	if err = reference.ByteOrder.Validate(); err != nil {
+		return nil, err
+	}
+	if err = checkBitType(table, reference.Type); err != nil {
+		return nil, err
+	}
+	if int(reference.Address)+reference.Type.Registers() > 65536 {
+		return nil, fmt.Errorf("address %d out of range", reference.Address)
+	}
+	return referenceNode(reference), nil
+}
+
+func (n numberNode) eval(memory memoryReader) (float64, error) {
+	return float64(n), nil
+}
+
+func (n referenceNode) eval(memory memoryReader) (float64, error) {
+	return memory.ReadValue(n.Slave, n.Table, n.Address, n.Type, n.ByteOrder)
+}
+
+func (n unaryNode) eval(memory memoryReader) (float64, error) {
+	value, err := n.operand.eval(memory)
+	if err != nil {
+		return 0, err
+	}
+	if n.operator == "-" {
+		return -value, nil
+	}
+	return boolNumber(value == 0), nil
+}
+
+func (n binaryNode) eval(memory memoryReader) (value float64, err error) {
+	left, err := n.left.eval(memory)
+	if err != nil {
+		return
+	}
+	// Short circuit logical operators.
+	if n.operator == "&&" && left == 0 {
+		return 0, nil
+	}
+	if n.operator == "||" && left != 0 {
+		return 1, nil
+	}
+	right, err := n.right.eval(memory)
+	if err != nil {
+		return
+	}
+	switch n.operator {
+	case "&&", "||":
+		value = boolNumber(right != 0)
+	case "==":
+		value = boolNumber(left == right)
+	case "!=":
+		value = boolNumber(left != right)
+	case "<":
+		value = boolNumber(left < right)
+	case "<=":
+		value = boolNumber(left <= right)
+	case ">":
+		value = boolNumber(left > right)
+	case ">=":
+		value = boolNumber(left >= right)
+	case "+":
+		value = left + right
+	case "-":
+		value = left - right
+	case "*":
+		value = left * right
+	case "/", "%":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if n.operator == "/" {
+			value = left / right
+		} else {
+			value = math.Mod(left, right)
+		}
+	}
+	return
+}
+
+func (n callNode) eval(memory memoryReader) (value float64, err error) {
+	arguments := make([]float64, len(n.arguments))
+	for i, argument := range n.arguments {
+		if arguments[i], err = argument.eval(memory); err != nil {
+			return
+		}
+	}
+	switch n.function {
+	case "abs":
+		value = math.Abs(arguments[0])
+	case "min":
+		value = math.Min(arguments[0], arguments[1])
+	case "max":
+		value = math.Max(arguments[0], arguments[1])
+	case "round":
+		value = math.Round(arguments[0])
+	}
+	return
+}
+
+func boolNumber(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// references appends the references used by the expression.
+func references(node expression, found []Reference) []Reference {
+	switch current := node.(type) {
+	case referenceNode:
+		found = append(found, Reference(current))
+	case unaryNode:
+		found = references(current.operand, found)
+	case binaryNode:
+		found = references(current.right, references(current.left, found))
+	case callNode:
+		for _, argument := range current.arguments {
+			found = references(argument, found)
+		}
+	}
+	return found
+}
@@ -0,0 +1,282 @@
+package modbusserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+type (
+	// FaultKind is the misbehaviour injected by a Fault.
+	FaultKind string
+	// Fault makes the server misbehave on matching requests. A request matches when it is addressed to
+	// one of Slaves and uses one of Functions (any if empty), and, if Quantity is not zero, reads or
+	// writes addresses in [Address, Address+Quantity) with a standard read or write function.
+	//
+	// A matching request triggers the fault with Probability (always if zero) while the fault is
+	// scheduled: from After since it was added, for For (forever if zero), repeating Every (once if
+	// zero), and at most Count times (unlimited if zero).
+	Fault struct {
+		Name        string    `json:"name" yaml:"name"`
+		Kind        FaultKind `json:"kind" yaml:"kind"`
+		Slaves      []uint8   `json:"slaves,omitempty" yaml:"slaves,omitempty"`
+		Functions   []uint8   `json:"functions,omitempty" yaml:"functions,omitempty"`
+		Address     uint16    `json:"address,omitempty" yaml:"address,omitempty"`
+		Quantity    uint16    `json:"quantity,omitempty" yaml:"quantity,omitempty"`
+		Probability float64   `json:"probability,omitempty" yaml:"probability,omitempty"`
+		After       Duration  `json:"after,omitempty" yaml:"after,omitempty"`
+		For         Duration  `json:"for,omitempty" yaml:"for,omitempty"`
+		Every       Duration  `json:"every,omitempty" yaml:"every,omitempty"`
+		Count       int       `json:"count,omitempty" yaml:"count,omitempty"`
+		// Delay is the response delay of FaultDelay.
+		Delay Duration `json:"delay,omitempty" yaml:"delay,omitempty"`
+		// Exception is the exception code of FaultException.
+		Exception Exception `json:"exception,omitempty" yaml:"exception,omitempty"`
+		// Bytes is the number of bytes removed by FaultTruncate or added by FaultExtraBytes, 1 if zero.
+		Bytes int `json:"bytes,omitempty" yaml:"bytes,omitempty"`
+	}
+	activeFault struct {
+		Fault
+		added    time.Time
+		injected int
+	}
+	// injectedFaults is the combined effect of the faults triggered by a request.
+	injectedFaults struct {
+		delay            time.Duration
+		noResponse       bool
+		exception        *Exception
+		corruptCRC       bool
+		wrongTransaction bool
+		wrongUnit        bool
+		truncate, extra  int
+		duplicate        bool
+	}
+)
+
+const (
+	// FaultDelay delays the response by Delay. The following responses of the same connection are
+	// delayed as well to keep their order, as with a slow device; other connections aren't affected.
+	FaultDelay FaultKind = "delay"
+	// FaultNoResponse processes the request without responding.
+	FaultNoResponse FaultKind = "no_response"
+	// FaultException responds with Exception without processing the request.
+	FaultException FaultKind = "exception"
+	// FaultCorruptCRC sends an RTU response with a wrong CRC; it doesn't affect TCP responses.
+	FaultCorruptCRC FaultKind = "corrupt_crc"
+	// FaultWrongTransactionID sends a TCP response with another transaction ID; it doesn't affect RTU
+	// responses.
+	FaultWrongTransactionID FaultKind = "wrong_transaction_id"
+	// FaultWrongUnitID sends the response with another unit ID.
+	FaultWrongUnitID FaultKind = "wrong_unit_id"
+	// FaultTruncate removes Bytes bytes from the end of the response.
+	FaultTruncate FaultKind = "truncate"
+	// FaultExtraBytes appends Bytes random bytes to the response.
+	FaultExtraBytes FaultKind = "extra_bytes"
+	// FaultDuplicate sends the response twice.
+	FaultDuplicate FaultKind = "duplicate"
+)
+
+// Validate checks the fault definition.
+func (f *Fault) Validate() error {
+	if f.Name == "" {
+		return errors.New("fault name is empty")
+	}
+	switch f.Kind {
+	case FaultDelay:
+		if f.Delay <= 0 {
+			return fmt.Errorf("fault %s: delay needs a positive delay", f.Name)
+		}
+	case FaultException:
+		if f.Exception == Success {
+			return fmt.Errorf("fault %s: exception needs an exception code", f.Name)
+		}
+	case FaultTruncate, FaultExtraBytes:
+		if f.Bytes < 0 {
+			return fmt.Errorf("fault %s: negative bytes", f.Name)
+		}
+	case FaultNoResponse, FaultCorruptCRC, FaultWrongTransactionID, FaultWrongUnitID, FaultDuplicate:
+	default:
+		return fmt.Errorf("fault %s: unknown kind %q", f.Name, f.Kind)
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("fault %s: probability %v out of range [0, 1]", f.Name, f.Probability)
+	}
+	if f.After < 0 || f.For < 0 || f.Every < 0 || f.Count < 0 {
+		return fmt.Errorf("fault %s: negative schedule", f.Name)
+	}
+	if int(f.Address)+int(f.Quantity) > 65536 {
+		return fmt.Errorf("fault %s: invalid range: address %d, quantity %d", f.Name, f.Address, f.Quantity)
+	}
+	return nil
+}
+
+// AddFault adds a fault, scheduled from now. Faults can be added and removed while the server runs.
+func (s *Server) AddFault(fault Fault) (err error) {
+	if err = fault.Validate(); err != nil {
+		return
+	}
+	s.faultsMutex.Lock()
+	defer s.faultsMutex.Unlock()
+	if slices.ContainsFunc(s.faults, func(current *activeFault) bool { return current.Name == fault.Name }) {
+		return fmt.Errorf("fault %s already exists", fault.Name)
+	}
+	fault.Slaves = slices.Clone(fault.Slaves)
+	fault.Functions = slices.Clone(fault.Functions)
+	s.faults = append(s.faults, &activeFault{Fault: fault, added: time.Now()})
+	return
+}
+
+// RemoveFault removes the fault.
+func (s *Server) RemoveFault(name string) {
+	s.faultsMutex.Lock()
+	defer s.faultsMutex.Unlock()
+	s.faults = slices.DeleteFunc(s.faults, func(current *activeFault) bool { return current.Name == name })
+}
+
+// ClearFaults removes all faults.
+func (s *Server) ClearFaults() {
+	s.faultsMutex.Lock()
+	defer s.faultsMutex.Unlock()
+	s.faults = nil
+}
+
+// Faults returns the fault definitions in the order they were added.
+func (s *Server) Faults() []Fault {
+	s.faultsMutex.Lock()
+	defer s.faultsMutex.Unlock()
+	faults := make([]Fault, len(s.faults))
+	for i, fault := range s.faults {
+		faults[i] = fault.Fault
+	}
+	return faults
+}
+
+// FaultInjections returns how many times the fault was triggered.
+func (s *Server) FaultInjections(name string) int {
+	s.faultsMutex.Lock()
+	defer s.faultsMutex.Unlock()
+	for _, fault := range s.faults {
+		if fault.Name == name {
+			return fault.injected
+		}
+	}
+	return 0
+}
+
+// injectFaults returns the faults triggered by the request.
+func (s *Server) injectFaults(frame Framer) (injected injectedFaults) {
+	s.faultsMutex.Lock()
+	defer s.faultsMutex.Unlock()
+	if len(s.faults) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, fault := range s.faults {
+		if !fault.matches(frame) || !fault.scheduled(now) || (fault.Probability != 0 && rand.Float64() >= fault.Probability) {
+			continue
+		}
+		fault.injected++
+		bytes := max(fault.Bytes, 1)
+		switch fault.Kind {
+		case FaultDelay:
+			injected.delay += time.Duration(fault.Delay)
+		case FaultNoResponse:
+			injected.noResponse = true
+		case FaultException:
+			exception := fault.Exception
+			injected.exception = &exception
+		case FaultCorruptCRC:
+			injected.corruptCRC = true
+		case FaultWrongTransactionID:
+			injected.wrongTransaction = true
+		case FaultWrongUnitID:
+			injected.wrongUnit = true
+		case FaultTruncate:
+			injected.truncate += bytes
+		case FaultExtraBytes:
+			injected.extra += bytes
+		case FaultDuplicate:
+			injected.duplicate = true
+		}
+	}
+	return
+}
+
+func (f *activeFault) matches(frame Framer) bool {
+	if len(f.Slaves) != 0 && !slices.Contains(f.Slaves, frame.GetSlaveId()) {
+		return false
+	}
+	if len(f.Functions) != 0 && !slices.Contains(f.Functions, frame.GetFunction()) {
+		return false
+	}
+	if f.Quantity == 0 {
+		return true
+	}
+	address, quantity, ok := requestRange(frame)
+	return ok && int(f.Address) < address+quantity && address < int(f.Address)+int(f.Quantity)
+}
+
+func (f *activeFault) scheduled(now time.Time) bool {
+	if f.Count != 0 && f.injected >= f.Count {
+		return false
+	}
+	elapsed := now.Sub(f.added) - time.Duration(f.After)
+	if elapsed < 0 {
+		return false
+	}
+	if f.Every > 0 {
+		elapsed %= time.Duration(f.Every)
+	}
+	return f.For == 0 || elapsed < time.Duration(f.For)
+}
+
+// requestRange returns the addresses read or written by a standard read or write request.
+func requestRange(frame Framer) (address int, quantity int, ok bool) {
+	data := frame.GetData()
+	if len(data) < 4 {
+		return
+	}
+	address = int(binary.BigEndian.Uint16(data[0:2]))
+	switch frame.GetFunction() {
+	case 1, 2, 3, 4, 15, 16:
+		return address, int(binary.BigEndian.Uint16(data[2:4])), true
+	case 5, 6:
+		return address, 1, true
+	}
+	return
+}
+
+// packets returns the bytes to send for the response, none if no response must be sent.
+func (f *injectedFaults) packets(response Framer) [][]byte {
+	if f.noResponse {
+		return nil
+	}
+	switch frame := response.(type) {
+	case *TCPFrame:
+		if f.wrongTransaction {
+			frame.TransactionIdentifier++
+		}
+		if f.wrongUnit {
+			frame.Device++
+		}
+	case *RTUFrame:
+		if f.wrongUnit {
+			frame.SlaveId++
+		}
+	}
+	packet := response.Bytes()
+	if _, ok := response.(*RTUFrame); ok && f.corruptCRC {
+		packet[len(packet)-1] ^= 0xFF
+	}
+	packet = packet[:max(len(packet)-f.truncate, 0)]
+	for i := 0; i < f.extra; i++ {
+		packet = append(packet, byte(rand.Uint32()))
+	}
+	if f.duplicate {
+		return [][]byte{packet, packet}
+	}
+	return [][]byte{packet}
+}
@@ -0,0 +1,174 @@
+package modbusserver
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestFaultInjection(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.SlaveId = 1
+	handler.Timeout = 200 * time.Millisecond
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	err := s.AddFault(Fault{Name: "busy", Kind: FaultException, Exception: SlaveDeviceBusy,
+		Slaves: []uint8{1}, Functions: []uint8{3, 6}, Address: 10, Quantity: 10, Count: 2})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if _, err = client.ReadHoldingRegisters(0, 10); err != nil {
+		t.Errorf("expected nil outside the fault range, got %v", err)
+	}
+	var modbusError *modbus.ModbusError
+	if _, err = client.ReadHoldingRegisters(5, 10); !errors.As(err, &modbusError) || modbusError.ExceptionCode != uint8(SlaveDeviceBusy) {
+		t.Errorf("expected SlaveDeviceBusy, got %v", err)
+	}
+	_, err = client.WriteSingleRegister(12, 7)
+	if values, _ := s.ReadTable(1, TableHoldingRegisters, 12, 1); !errors.As(err, &modbusError) || values[0] != 0 {
+		t.Errorf("expected write not processed, got %v, %v", err, values)
+	}
+	if _, err = client.ReadHoldingRegisters(10, 1); err != nil || s.FaultInjections("busy") != 2 {
+		t.Errorf("expected nil after count, got %v, %d injections", err, s.FaultInjections("busy"))
+	}
+	s.RemoveFault("busy")
+
+	s.AddFault(Fault{Name: "slow", Kind: FaultDelay, Delay: Duration(100 * time.Millisecond)})
+	start := time.Now()
+	if _, err = client.ReadCoils(0, 1); err != nil || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("expected delayed response, got %v after %v", err, time.Since(start))
+	}
+	s.RemoveFault("slow")
+
+	s.AddFault(Fault{Name: "transaction", Kind: FaultWrongTransactionID, Count: 1})
+	if _, err = client.ReadCoils(0, 1); err == nil {
+		t.Errorf("expected transaction ID error, got nil")
+	}
+	if _, err = client.ReadCoils(0, 1); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	s.AddFault(Fault{Name: "silent", Kind: FaultNoResponse, Functions: []uint8{6}})
+	if _, err = client.WriteSingleRegister(1, 5); err == nil {
+		t.Errorf("expected timeout, got nil")
+	}
+	if values, _ := s.ReadTable(1, TableHoldingRegisters, 1, 1); values[0] != 5 {
+		t.Errorf("expected processed write, got %v", values)
+	}
+	if got := s.Faults(); len(got) != 2 || got[1].Name != "silent" {
+		t.Errorf("expected 2 faults, got %v", got)
+	}
+	s.ClearFaults()
+}
+
+func TestFaultPackets(t *testing.T) {
+	response := &RTUFrame{SlaveId: 1, Function: 3, Data: []byte{2, 0, 7}}
+	valid := response.Bytes()
+
+	faults := injectedFaults{corruptCRC: true}
+	packets := faults.packets(response.Copy())
+	if _, err := NewRTUFrame(packets[0]); err == nil {
+		t.Errorf("expected CRC error, got nil")
+	}
+	faults = injectedFaults{truncate: 2, extra: 3, duplicate: true}
+	packets = faults.packets(response.Copy())
+	if len(packets) != 2 || len(packets[0]) != len(valid)+1 || !slices.Equal(packets[0][:len(valid)-2], valid[:len(valid)-2]) {
+		t.Errorf("expected duplicated truncated packet with extra bytes, got %v", packets)
+	}
+	faults = injectedFaults{wrongUnit: true}
+	if frame, err := NewRTUFrame(faults.packets(response.Copy())[0]); err != nil || frame.SlaveId != 2 {
+		t.Errorf("expected valid frame of unit 2, got %v, %v", frame, err)
+	}
+	faults = injectedFaults{noResponse: true}
+	if packets = faults.packets(response.Copy()); len(packets) != 0 {
+		t.Errorf("expected no packets, got %v", packets)
+	}
+}
+
+func TestFaultSchedule(t *testing.T) {
+	added := time.Now()
+	fault := &activeFault{Fault: Fault{After: Duration(time.Second), For: Duration(2 * time.Second), Every: Duration(5 * time.Second)}, added: added}
+	for _, step := range []struct {
+		at       time.Duration
+		expected bool
+	}{
+		{500 * time.Millisecond, false},
+		{time.Second, true},
+		{2900 * time.Millisecond, true},
+		{3 * time.Second, false},
+		{6500 * time.Millisecond, true},
+		{8500 * time.Millisecond, false},
+	} {
+		if got := fault.scheduled(added.Add(step.at)); got != step.expected {
+			t.Errorf("at %v: expected %v, got %v", step.at, step.expected, got)
+		}
+	}
+
+	for _, fault := range []Fault{
+		{Kind: FaultDelay},
+		{Name: "a", Kind: FaultDelay},
+		{Name: "a", Kind: FaultException},
+		{Name: "a", Kind: "lost"},
+		{Name: "a", Kind: FaultDuplicate, Probability: 1.5},
+		{Name: "a", Kind: FaultDuplicate, Address: 65535, Quantity: 2},
+	} {
+		if err := fault.Validate(); err == nil {
+			t.Errorf("%+v: expected error, got nil", fault)
+		}
+	}
+}
+
+func TestFaultDelayConnection(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.InitSlave(2)
+	var clients []modbus.Client
+	for _, slave := range []byte{1, 2} {
+		addr := getFreePort()
+		if err := s.ListenTCP(addr); err != nil {
+			t.Fatalf("failed to listen, got %v\n", err)
+		}
+		handler := modbus.NewTCPClientHandler(addr)
+		handler.SlaveId = slave
+		handler.Timeout = time.Second
+		if err := handler.Connect(); err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		defer handler.Close()
+		clients = append(clients, modbus.NewClient(handler))
+	}
+	defer s.Close()
+	s.AddFault(Fault{Name: "slow", Kind: FaultDelay, Slaves: []uint8{1}, Delay: Duration(400 * time.Millisecond)})
+
+	// The delayed response of slave 1 doesn't hold the responses of the other connection.
+	delayed := make(chan error)
+	go func() {
+		_, err := clients[0].ReadCoils(0, 1)
+		delayed <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if _, err := clients[1].ReadCoils(0, 1); err != nil || time.Since(start) > 200*time.Millisecond {
+		t.Errorf("expected an immediate response, got %v after %v", err, time.Since(start))
+	}
+	if err := <-delayed; err != nil {
+		t.Errorf("expected the delayed response, got %v", err)
+	}
+}
@@ -1,6 +1,15 @@
 package modbusserver
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Frame parsing errors wrap one of these errors.
+var (
+	errFrameLength   = errors.New("invalid length")
+	errFrameChecksum = errors.New("invalid checksum")
+)
 
 // Framer is the interface that wraps Modbus frames.
 type Framer interface {
@@ -64,3 +73,27 @@ func SetDataWithRegisterAndNumberAndBytes(frame Framer, register uint16, number
 	copy(data[5:], bytes)
 	frame.SetData(data)
 }
+
+// setSlaveId sets the slave ID of a TCPFrame, RTUFrame or ASCIIFrame.
+func setSlaveId(frame Framer, id uint8) {
+	switch frame := frame.(type) {
+	case *TCPFrame:
+		frame.Device = id
+	case *RTUFrame:
+		frame.SlaveId = id
+	case *ASCIIFrame:
+		frame.SlaveId = id
+	}
+}
+
+// setFunction sets the function code of a TCPFrame, RTUFrame or ASCIIFrame.
+func setFunction(frame Framer, function uint8) {
+	switch frame := frame.(type) {
+	case *TCPFrame:
+		frame.Function = function
+	case *RTUFrame:
+		frame.Function = function
+	case *ASCIIFrame:
+		frame.Function = function
+	}
+}
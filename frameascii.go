@@ -0,0 +1,91 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ASCIIFrame is the Modbus ASCII frame.
+type ASCIIFrame struct {
+	SlaveId  uint8
+	Function uint8
+	Data     []byte
+	LRC      uint8
+}
+
+// NewASCIIFrame converts a packet, from the colon to the CR LF, to a Modbus ASCII frame.
+func NewASCIIFrame(packet []byte) (*ASCIIFrame, error) {
+	if len(packet) < 9 || packet[0] != ':' || !bytes.HasSuffix(packet, []byte("\r\n")) {
+		return nil, fmt.Errorf("ASCII Frame error: not a frame: %q", packet)
+	}
+	decoded := make([]byte, hex.DecodedLen(len(packet)-3))
+	if _, err := hex.Decode(decoded, packet[1:len(packet)-2]); err != nil {
+		return nil, fmt.Errorf("ASCII Frame error: %w", err)
+	}
+
+	// Check the LRC.
+	pLen := len(decoded)
+	lrcExpect := decoded[pLen-1]
+	lrcCalc := lrcModbus(decoded[0 : pLen-1])
+	if lrcCalc != lrcExpect {
+		return nil, fmt.Errorf("ASCII Frame error: %w: LRC (expected 0x%x, got 0x%x)", errFrameChecksum, lrcExpect, lrcCalc)
+	}
+
+	frame := &ASCIIFrame{
+		SlaveId:  decoded[0],
+		Function: decoded[1],
+		Data:     decoded[2 : pLen-1],
+		LRC:      lrcExpect,
+	}
+
+	return frame, nil
+}
+
+// Copy the ASCIIFrame.
+func (frame *ASCIIFrame) Copy() Framer {
+	copy := *frame
+	return &copy
+}
+
+// Bytes returns the Modbus byte stream based on the ASCIIFrame fields
+func (frame *ASCIIFrame) Bytes() []byte {
+	decoded := append([]byte{frame.SlaveId, frame.Function}, frame.Data...)
+	decoded = append(decoded, lrcModbus(decoded))
+	return []byte(":" + strings.ToUpper(hex.EncodeToString(decoded)) + "\r\n")
+}
+
+func (f *ASCIIFrame) GetSlaveId() uint8 {
+	return f.SlaveId
+}
+
+// GetFunction returns the Modbus function code.
+func (frame *ASCIIFrame) GetFunction() uint8 {
+	return frame.Function
+}
+
+// GetData returns the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) GetData() []byte {
+	return frame.Data
+}
+
+// SetData sets the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) SetData(data []byte) {
+	frame.Data = data
+}
+
+// SetException sets the Modbus exception code in the frame.
+func (frame *ASCIIFrame) SetException(exception *Exception) {
+	frame.Function = frame.Function | 0x80
+	frame.Data = []byte{byte(*exception)}
+}
+
+// lrcModbus returns the longitudinal redundancy check of the bytes: the two's complement of their sum.
+func lrcModbus(bytes []byte) uint8 {
+	var sum uint8
+	for _, b := range bytes {
+		sum += b
+	}
+	return -sum
+}
@@ -0,0 +1,22 @@
+package modbusserver
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestASCIIFrame(t *testing.T) {
+	packet := []byte(":010300000001FB\r\n")
+	frame, err := NewASCIIFrame(packet)
+	if err != nil || frame.SlaveId != 1 || frame.Function != 3 || !bytes.Equal(frame.Data, []byte{0, 0, 0, 1}) {
+		t.Fatalf("expected frame, got %+v, %v", frame, err)
+	}
+	if !bytes.Equal(frame.Bytes(), packet) {
+		t.Errorf("expected %q, got %q", packet, frame.Bytes())
+	}
+	for _, malformed := range []string{":010300000001FC\r\n", "010300000001FB\r\n", ":010300000001FB", ":01030000000XFB\r\n"} {
+		if _, err := NewASCIIFrame([]byte(malformed)); err == nil {
+			t.Errorf("%q: expected error, got nil", malformed)
+		}
+	}
+}
@@ -17,7 +17,7 @@ type RTUFrame struct {
 func NewRTUFrame(packet []byte) (*RTUFrame, error) {
 	// Check the that the packet length.
 	if len(packet) < 5 {
-		return nil, fmt.Errorf("RTU Frame error: packet less than 5 bytes: %v", packet)
+		return nil, fmt.Errorf("RTU Frame error: %w: packet less than 5 bytes: %v", errFrameLength, packet)
 	}
 
 	// Check the CRC.
@@ -25,7 +25,7 @@ func NewRTUFrame(packet []byte) (*RTUFrame, error) {
 	crcExpect := binary.LittleEndian.Uint16(packet[pLen-2 : pLen])
 	crcCalc := crcModbus(packet[0 : pLen-2])
 	if crcCalc != crcExpect {
-		return nil, fmt.Errorf("RTU Frame error: CRC (expected 0x%x, got 0x%x)", crcExpect, crcCalc)
+		return nil, fmt.Errorf("RTU Frame error: %w: CRC (expected 0x%x, got 0x%x)", errFrameChecksum, crcExpect, crcCalc)
 	}
 
 	frame := &RTUFrame{
@@ -19,7 +19,7 @@ type TCPFrame struct {
 func NewTCPFrame(packet []byte) (*TCPFrame, error) {
 	// Check if the packet is too short.
 	if len(packet) < 9 {
-		return nil, fmt.Errorf("TCP Frame error: packet less than 9 bytes")
+		return nil, fmt.Errorf("TCP Frame error: %w: packet less than 9 bytes", errFrameLength)
 	}
 
 	frame := &TCPFrame{
@@ -33,7 +33,7 @@ func NewTCPFrame(packet []byte) (*TCPFrame, error) {
 
 	// Check expected vs actual packet length.
 	if int(frame.Length) != len(frame.Data)+2 {
-		return nil, fmt.Errorf("specified packet length does not match actual packet length")
+		return nil, fmt.Errorf("%w: specified packet length does not match actual packet length", errFrameLength)
 	}
 
 	return frame, nil
@@ -71,14 +71,24 @@ func WriteSingleCoil(s *Server, frame Framer) ([]byte, *Exception) {
 	if value != 0 {
 		value = 1
 	}
-	s.Slaves[frame.GetSlaveId()].Coils[register] = byte(value)
+	if exception := s.validateWrite(frame.GetSlaveId(), TableCoils, register, []uint16{value}); exception != &Success {
+		return []byte{}, exception
+	}
+	if exception := s.commitWrite(frame.GetSlaveId(), TableCoils, register, []uint16{value}); exception != &Success {
+		return []byte{}, exception
+	}
 	return frame.GetData()[0:4], &Success
 }
 
 // WriteHoldingRegister function 6, write a holding register to internal memory.
 func WriteHoldingRegister(s *Server, frame Framer) ([]byte, *Exception) {
 	register, value := registerAddressAndValue(frame)
-	s.Slaves[frame.GetSlaveId()].HoldingRegisters[register] = value
+	if exception := s.validateWrite(frame.GetSlaveId(), TableHoldingRegisters, register, []uint16{value}); exception != &Success {
+		return []byte{}, exception
+	}
+	if exception := s.commitWrite(frame.GetSlaveId(), TableHoldingRegisters, register, []uint16{value}); exception != &Success {
+		return []byte{}, exception
+	}
 	return frame.GetData()[0:4], &Success
 }
 
@@ -96,18 +106,15 @@ func WriteMultipleCoils(s *Server, frame Framer) ([]byte, *Exception) {
 	//	return []byte{}, &IllegalDataAddress
 	//}
 
-	bitCount := 0
-	for i, value := range valueBytes {
-		for bitPos := uint(0); bitPos < 8; bitPos++ {
-			s.Slaves[frame.GetSlaveId()].Coils[register+(i*8)+int(bitPos)] = bitAtPosition(value, bitPos)
-			bitCount++
-			if bitCount >= numRegs {
-				break
-			}
-		}
-		if bitCount >= numRegs {
-			break
-		}
+	values := make([]uint16, 0, numRegs)
+	for i := 0; i < numRegs && i/8 < len(valueBytes); i++ {
+		values = append(values, uint16(bitAtPosition(valueBytes[i/8], uint(i%8))))
+	}
+	if exception := s.validateWrite(frame.GetSlaveId(), TableCoils, register, values); exception != &Success {
+		return []byte{}, exception
+	}
+	if exception := s.commitWrite(frame.GetSlaveId(), TableCoils, register, values); exception != &Success {
+		return []byte{}, exception
 	}
 
 	return frame.GetData()[0:4], &Success
@@ -115,26 +122,23 @@ func WriteMultipleCoils(s *Server, frame Framer) ([]byte, *Exception) {
 
 // WriteHoldingRegisters function 16, writes holding registers to internal memory.
 func WriteHoldingRegisters(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, _ := registerAddressAndNumber(frame)
+	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	valueBytes := frame.GetData()[5:]
-	var exception *Exception
-	var data []byte
 
-	if len(valueBytes)/2 != numRegs {
-		exception = &IllegalDataAddress
+	if len(valueBytes)/2 != numRegs || endRegister > 65536 {
+		return []byte{}, &IllegalDataAddress
 	}
 
-	// Copy data to memroy
 	values := BytesToUint16(valueBytes)
-	valuesUpdated := copy(s.Slaves[frame.GetSlaveId()].HoldingRegisters[register:], values)
-	if valuesUpdated == numRegs {
-		exception = &Success
-		data = frame.GetData()[0:4]
-	} else {
-		exception = &IllegalDataAddress
+	if exception := s.validateWrite(frame.GetSlaveId(), TableHoldingRegisters, register, values); exception != &Success {
+		return []byte{}, exception
+	}
+	// Copy data to memroy
+	if exception := s.commitWrite(frame.GetSlaveId(), TableHoldingRegisters, register, values); exception != &Success {
+		return []byte{}, exception
 	}
 
-	return data, exception
+	return frame.GetData()[0:4], &Success
 }
 
 // BytesToUint16 converts a big endian array of bytes to an array of unit16s
@@ -0,0 +1,388 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// defaultGatewayTimeout is the response timeout of a forward route without one.
+const defaultGatewayTimeout = time.Second
+
+type (
+	// GatewayRoute forwards the requests for a unit ID to a device on a backend. It is a shorthand for a
+	// forward Route of a single unit ID.
+	GatewayRoute struct {
+		// Line is the name of the gateway line or backend.
+		Line string `json:"line" yaml:"line"`
+		// Slave is the slave ID of the device on the line, the unit ID if zero.
+		Slave uint8 `json:"slave,omitempty" yaml:"slave,omitempty"`
+		// Timeout is the response timeout of each attempt, 1s if zero.
+		Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+		// Retries is the number of attempts after the first one.
+		Retries int `json:"retries,omitempty" yaml:"retries,omitempty"`
+	}
+	// backend sends requests to remote devices, one at a time. A nil response data without error means
+	// the request gets no response, as a broadcast.
+	backend interface {
+		transact(unit uint8, function uint8, data []byte, timeout time.Duration) (uint8, []byte, error)
+		close() error
+	}
+	// gatewayLine is an RTU master on a serial line.
+	gatewayLine struct {
+		port      io.ReadWriteCloser
+		mutex     sync.Mutex
+		received  chan []byte
+		closeChan chan struct{}
+		closeOnce sync.Once
+		wg        sync.WaitGroup
+	}
+	// tcpBackend is a Modbus TCP client of a remote server, connecting on demand.
+	tcpBackend struct {
+		address       string
+		mutex         sync.Mutex
+		conn          net.Conn
+		transactionID uint16
+		closed        bool
+	}
+)
+
+var errGatewayTimeout = errors.New("gateway target device failed to respond")
+
+// OpenGatewayLine opens a serial port as a gateway line named after its address.
+func (s *Server) OpenGatewayLine(config *serial.Config) (err error) {
+	port, err := serial.Open(config)
+	if err != nil {
+		return
+	}
+	if err = s.AddGatewayLine(config.Address, port); err != nil {
+		port.Close()
+	}
+	return
+}
+
+// AddGatewayLine adds a backend sending RTU requests to the port. Once a backend is added, the server acts
+// as a gateway: requests for unit IDs without a route that are not local get GatewayPathUnavailable. The
+// port is closed by RemoveBackend or Close.
+func (s *Server) AddGatewayLine(name string, port io.ReadWriteCloser) error {
+	line := &gatewayLine{port: port, received: make(chan []byte, 16), closeChan: make(chan struct{})}
+	if err := s.addBackend(name, line); err != nil {
+		return err
+	}
+	line.wg.Add(1)
+	go line.readLoop()
+	return nil
+}
+
+// AddTCPBackend adds a backend sending Modbus TCP requests to the server at "address:port". The
+// connection is opened on the first request and reopened after errors.
+func (s *Server) AddTCPBackend(name string, address string) error {
+	return s.addBackend(name, &tcpBackend{address: address})
+}
+
+func (s *Server) addBackend(name string, b backend) error {
+	s.routingMutex.Lock()
+	defer s.routingMutex.Unlock()
+	if _, ok := s.backends[name]; ok {
+		return fmt.Errorf("backend %s already exists", name)
+	}
+	s.backends[name] = b
+	return nil
+}
+
+// RemoveBackend closes the backend and removes its routes.
+func (s *Server) RemoveBackend(name string) (err error) {
+	s.routingMutex.Lock()
+	b, ok := s.backends[name]
+	delete(s.backends, name)
+	s.routes = slices.DeleteFunc(slices.Clone(s.routes), func(route Route) bool {
+		return route.Action == RouteForward && route.Backend == name
+	})
+	s.routingMutex.Unlock()
+	if ok {
+		err = b.close()
+	}
+	return
+}
+
+// RemoveGatewayLine closes the gateway line and removes its routes.
+//
+// Deprecated: gateway lines are backends, use RemoveBackend.
+func (s *Server) RemoveGatewayLine(name string) error {
+	return s.RemoveBackend(name)
+}
+
+// SetGatewayRoute forwards the requests for the unit ID to the route, replacing the routes of the single
+// unit ID and taking precedence over the other routes. Routes can be changed while the server runs.
+func (s *Server) SetGatewayRoute(unit uint8, route GatewayRoute) error {
+	forward := Route{Units: UnitRange{unit, unit}, Action: RouteForward, Backend: route.Line,
+		Unit: route.Slave, Timeout: route.Timeout, Retries: route.Retries}
+	if err := forward.Validate(); err != nil {
+		return err
+	}
+	s.routingMutex.Lock()
+	defer s.routingMutex.Unlock()
+	if _, ok := s.backends[route.Line]; !ok {
+		return fmt.Errorf("route of units %s: unknown backend %q", forward.Units, route.Line)
+	}
+	s.routes = append([]Route{forward}, slices.DeleteFunc(slices.Clone(s.routes), func(current Route) bool {
+		return current.Units == forward.Units
+	})...)
+	return nil
+}
+
+// RemoveGatewayRoute removes the routes of the single unit ID.
+func (s *Server) RemoveGatewayRoute(unit uint8) {
+	s.routingMutex.Lock()
+	defer s.routingMutex.Unlock()
+	s.routes = slices.DeleteFunc(slices.Clone(s.routes), func(route Route) bool {
+		return route.Units == UnitRange{unit, unit}
+	})
+}
+
+// GatewayRoutes returns the forward routes of single unit IDs by unit ID.
+func (s *Server) GatewayRoutes() map[uint8]GatewayRoute {
+	s.routingMutex.RLock()
+	defer s.routingMutex.RUnlock()
+	routes := make(map[uint8]GatewayRoute)
+	for _, route := range s.routes {
+		if _, ok := routes[route.Units.First]; !ok && route.Action == RouteForward && route.Units.First == route.Units.Last {
+			routes[route.Units.First] = GatewayRoute{Line: route.Backend, Slave: route.Unit, Timeout: route.Timeout, Retries: route.Retries}
+		}
+	}
+	return routes
+}
+
+// closeBackends closes all backends and clears the routing table.
+func (s *Server) closeBackends() {
+	s.routingMutex.Lock()
+	backends := s.backends
+	s.backends = make(map[string]backend)
+	s.routes = nil
+	s.routingMutex.Unlock()
+	for _, b := range backends {
+		b.close()
+	}
+}
+
+// forward sends the request to the backend of the route and returns the response, nil if there is none.
+func (s *Server) forward(request Framer, route Route) Framer {
+	unit := route.Unit
+	if unit == 0 {
+		unit = request.GetSlaveId()
+	}
+	function, data, exception := s.transact(route.Backend, unit, request.GetFunction(), request.GetData(), time.Duration(route.Timeout), route.Retries)
+	response := request.Copy()
+	if exception != &Success {
+		response.SetException(exception)
+		return response
+	}
+	if data == nil {
+		return nil
+	}
+	setFunction(response, function)
+	response.SetData(data)
+	return response
+}
+
+// transact sends a request to a unit on the named backend, with a response timeout of 1s if zero and
+// retries after failed attempts. It returns the response function and data, nil data if the request
+// gets no response, or GatewayPathUnavailable or GatewayTargetDeviceFailedtoRespond.
+func (s *Server) transact(name string, unit uint8, function uint8, data []byte, timeout time.Duration, retries int) (uint8, []byte, *Exception) {
+	s.routingMutex.RLock()
+	b, ok := s.backends[name]
+	s.routingMutex.RUnlock()
+	if !ok {
+		return 0, nil, &GatewayPathUnavailable
+	}
+	if timeout == 0 {
+		timeout = defaultGatewayTimeout
+	}
+	for attempt := 0; attempt <= retries; attempt++ {
+		responseFunction, responseData, err := b.transact(unit, function, data, timeout)
+		if err != nil {
+			s.logger.Debug(fmt.Sprintf("Backend %s: attempt %d to unit %d failed: %s", name, attempt+1, unit, err.Error()))
+			continue
+		}
+		return responseFunction, responseData, &Success
+	}
+	return 0, nil, &GatewayTargetDeviceFailedtoRespond
+}
+
+// readLoop receives the bytes of the line until it is closed.
+func (l *gatewayLine) readLoop() {
+	defer l.wg.Done()
+	buffer := make([]byte, 512)
+	for {
+		n, err := l.port.Read(buffer)
+		if n != 0 {
+			select {
+			case l.received <- bytes.Clone(buffer[:n]):
+			case <-l.closeChan:
+				return
+			}
+		}
+		if err != nil && !errors.Is(err, serial.ErrTimeout) {
+			return
+		}
+	}
+}
+
+// transact sends an RTU request and waits for a complete response for up to the timeout. Broadcasts to
+// slave 0 get no response.
+func (l *gatewayLine) transact(slave uint8, function uint8, data []byte, timeout time.Duration) (uint8, []byte, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	// Discard the late responses to previous requests.
+	for drained := false; !drained; {
+		select {
+		case <-l.received:
+		default:
+			drained = true
+		}
+	}
+	if _, err := l.port.Write((&RTUFrame{SlaveId: slave, Function: function, Data: data}).Bytes()); err != nil {
+		return 0, nil, err
+	}
+	if slave == 0 {
+		return 0, nil, nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var packet []byte
+	for {
+		select {
+		case <-l.closeChan:
+			return 0, nil, net.ErrClosed
+		case <-timer.C:
+			return 0, nil, errGatewayTimeout
+		case received := <-l.received:
+			packet = append(packet, received...)
+			length := rtuResponseLength(packet)
+			if length == 0 || len(packet) < length {
+				continue
+			}
+			frame, err := NewRTUFrame(packet[:length])
+			if err != nil {
+				return 0, nil, err
+			}
+			if frame.SlaveId != slave || frame.Function&0x7F != function {
+				return 0, nil, fmt.Errorf("unexpected response of slave %d, function %d", frame.SlaveId, frame.Function)
+			}
+			return frame.Function, bytes.Clone(frame.Data), nil
+		}
+	}
+}
+
+func (l *gatewayLine) close() (err error) {
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+		err = l.port.Close()
+		l.wg.Wait()
+	})
+	return
+}
+
+// transact sends a Modbus TCP request and waits for the response with the same transaction ID for up to
+// the timeout. The connection is closed on errors.
+func (b *tcpBackend) transact(unit uint8, function uint8, data []byte, timeout time.Duration) (uint8, []byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return 0, nil, net.ErrClosed
+	}
+	deadline := time.Now().Add(timeout)
+	if b.conn == nil {
+		conn, err := net.DialTimeout("tcp", b.address, timeout)
+		if err != nil {
+			return 0, nil, err
+		}
+		b.conn = conn
+	}
+	b.transactionID++
+	request := &TCPFrame{TransactionIdentifier: b.transactionID, Device: unit, Function: function}
+	request.SetData(data)
+	b.conn.SetDeadline(deadline)
+	if _, err := b.conn.Write(request.Bytes()); err != nil {
+		b.disconnect()
+		return 0, nil, err
+	}
+	for {
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(b.conn, header); err != nil {
+			b.disconnect()
+			return 0, nil, err
+		}
+		packet := append(header, make([]byte, binary.BigEndian.Uint16(header[4:6]))...)
+		if _, err := io.ReadFull(b.conn, packet[6:]); err != nil {
+			b.disconnect()
+			return 0, nil, err
+		}
+		frame, err := NewTCPFrame(packet)
+		if err != nil {
+			b.disconnect()
+			return 0, nil, err
+		}
+		// Skip the late responses to previous requests.
+		if frame.TransactionIdentifier == b.transactionID {
+			return frame.Function, frame.Data, nil
+		}
+	}
+}
+
+func (b *tcpBackend) disconnect() {
+	b.conn.Close()
+	b.conn = nil
+}
+
+func (b *tcpBackend) close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	if b.conn != nil {
+		b.disconnect()
+	}
+	return nil
+}
+
+// rtuResponseLength returns the length of the RTU response starting with the bytes, 0 if it can't be
+// known yet. Responses of functions without a known length end at the first valid CRC.
+func rtuResponseLength(response []byte) int {
+	if len(response) < 2 {
+		return 0
+	}
+	switch function := response[1]; {
+	case function&0x80 != 0:
+		return 5
+	case function == 5 || function == 6 || function == 8 || function == 11 || function == 15 || function == 16:
+		return 8
+	case function == 7:
+		return 5
+	case function == 22:
+		return 10
+	case function <= 4 || function == 12 || function == 17 || function == 20 || function == 21 || function == 23:
+		if len(response) < 3 {
+			return 0
+		}
+		return 5 + int(response[2])
+	case function == 24:
+		if len(response) < 4 {
+			return 0
+		}
+		return 6 + int(binary.BigEndian.Uint16(response[2:4]))
+	}
+	for length := 4; length <= len(response); length++ {
+		if crcModbus(response[:length-2]) == binary.LittleEndian.Uint16(response[length-2:length]) {
+			return length
+		}
+	}
+	return 0
+}
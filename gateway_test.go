@@ -0,0 +1,141 @@
+package modbusserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// serveRTUDevice answers the RTU requests received on the port with the memory of the device, ignoring
+// the slaves it doesn't have. Responses are written in two parts.
+func serveRTUDevice(device *Server, port net.Conn, ignored *atomic.Int32) {
+	buffer := make([]byte, 256)
+	for {
+		n, err := port.Read(buffer)
+		if err != nil {
+			return
+		}
+		frame, err := NewRTUFrame(buffer[:n])
+		if err != nil || !device.slaveResponds(frame.SlaveId) {
+			ignored.Add(1)
+			continue
+		}
+		response := device.respond(frame).Bytes()
+		port.Write(response[:3])
+		port.Write(response[3:])
+	}
+}
+
+func TestGateway(t *testing.T) {
+	device := NewServer(slog.Logger{})
+	device.InitSlave(5)
+	device.WriteTable(5, TableHoldingRegisters, 0, []uint16{10, 11, 12, 13, 14})
+	devicePort, linePort := net.Pipe()
+	var ignored atomic.Int32
+	go serveRTUDevice(device, devicePort, &ignored)
+
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	if err := s.AddGatewayLine("bus", linePort); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := s.SetGatewayRoute(3, GatewayRoute{Line: "other"}); err == nil {
+		t.Errorf("expected unknown line error, got nil")
+	}
+	s.SetGatewayRoute(2, GatewayRoute{Line: "bus", Slave: 5, Timeout: Duration(200 * time.Millisecond)})
+	s.SetGatewayRoute(9, GatewayRoute{Line: "bus", Timeout: Duration(50 * time.Millisecond), Retries: 2})
+
+	connect := func(unit uint8) (*modbus.TCPClientHandler, modbus.Client) {
+		handler := modbus.NewTCPClientHandler(addr)
+		handler.SlaveId = unit
+		handler.Timeout = time.Second
+		if err := handler.Connect(); err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		return handler, modbus.NewClient(handler)
+	}
+
+	// Concurrent clients share the serial line.
+	var wg sync.WaitGroup
+	for i := uint16(0); i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler, client := connect(2)
+			defer handler.Close()
+			for j := 0; j < 5; j++ {
+				results, err := client.ReadHoldingRegisters(i, 1)
+				if err != nil || binary.BigEndian.Uint16(results) != 10+i {
+					t.Errorf("register %d: expected %d, got %v, %v", i, 10+i, results, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	handler, client := connect(2)
+	defer handler.Close()
+	var modbusError *modbus.ModbusError
+	for _, c := range []struct {
+		unit      uint8
+		exception Exception
+	}{
+		{1, Success},
+		{7, GatewayPathUnavailable},
+		{9, GatewayTargetDeviceFailedtoRespond},
+	} {
+		handler.SlaveId = c.unit
+		_, err := client.ReadCoils(0, 1)
+		if c.exception == Success && err != nil {
+			t.Errorf("unit %d: expected nil, got %v", c.unit, err)
+		}
+		if c.exception != Success && (!errors.As(err, &modbusError) || modbusError.ExceptionCode != uint8(c.exception)) {
+			t.Errorf("unit %d: expected %v, got %v", c.unit, c.exception, err)
+		}
+	}
+	if attempts := ignored.Load(); attempts != 3 {
+		t.Errorf("expected 3 attempts to the silent device, got %d", attempts)
+	}
+
+	s.RemoveBackend("bus")
+	if routes := s.GatewayRoutes(); len(routes) != 0 {
+		t.Errorf("expected routes of the line removed, got %v", routes)
+	}
+	handler.SlaveId = 7
+	if _, err := client.ReadCoils(0, 1); err == nil {
+		t.Errorf("expected timeout without gateway, got nil")
+	}
+}
+
+func TestRTUResponseLength(t *testing.T) {
+	for _, c := range []struct {
+		response []byte
+		expected int
+	}{
+		{[]byte{1}, 0},
+		{[]byte{1, 3}, 0},
+		{[]byte{1, 3, 4}, 9},
+		{[]byte{1, 0x83, 2}, 5},
+		{[]byte{1, 16, 0, 0}, 8},
+		{[]byte{1, 24, 0, 6}, 12},
+		{(&RTUFrame{SlaveId: 1, Function: 43, Data: []byte{14, 1, 0x81}}).Bytes(), 7},
+		{[]byte{1, 43, 14}, 0},
+	} {
+		if length := rtuResponseLength(c.response); length != c.expected {
+			t.Errorf("%v: expected %d, got %d", c.response, c.expected, length)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// GeneratorKind is the waveform produced by a Generator.
+	GeneratorKind string
+	// Generator drives a typed value at a slave address. With t being the time since the generator was
+	// started and P the period:
+	//   - sine: Offset + Amplitude * sin(2πt/P);
+	//   - ramp: triangle rising from Offset to Offset + Amplitude in P/2 and falling back in P/2;
+	//   - sawtooth: rising from Offset to Offset + Amplitude in P, then restarting;
+	//   - square: Offset + Amplitude for P/2, then Offset - Amplitude for P/2;
+	//   - random_walk: starts at Offset and moves by a uniform step in [-Amplitude, Amplitude] each update;
+	//   - noise: Offset plus gaussian noise with standard deviation Amplitude;
+	//   - steps: Steps[n mod len(Steps)], n being the number of elapsed periods;
+	//   - counter: Offset + Amplitude * n, wrapping around the range of the data type.
+	//
+	// Values are clamped to the range of the data type. A TypeBool value is true when positive.
+	Generator struct {
+		Name      string        `json:"name" yaml:"name"`
+		Slave     uint8         `json:"slave" yaml:"slave"`
+		Table     Table         `json:"table" yaml:"table"`
+		Address   uint16        `json:"address" yaml:"address"`
+		Type      DataType      `json:"type" yaml:"type"`
+		ByteOrder ByteOrder     `json:"byte_order,omitempty" yaml:"byte_order,omitempty"`
+		Kind      GeneratorKind `json:"kind" yaml:"kind"`
+		Period    time.Duration `json:"period" yaml:"period"`
+		Amplitude float64       `json:"amplitude" yaml:"amplitude"`
+		Offset    float64       `json:"offset" yaml:"offset"`
+		Steps     []float64     `json:"steps,omitempty" yaml:"steps,omitempty"`
+		// Seed makes random_walk and noise reproducible; zero uses a random seed.
+		Seed uint64 `json:"seed,omitempty" yaml:"seed,omitempty"`
+	}
+	// GeneratorScheduler updates the values of running generators every resolution tick. The values of
+	// each slave are written in one transaction. A generator whose write fails, for example because its
+	// slave was removed, is stopped and the error is logged.
+	GeneratorScheduler struct {
+		server     *Server
+		resolution time.Duration
+		generators map[string]*runningGenerator
+		names      []string
+		mutex      sync.Mutex
+		stopChan   chan struct{}
+		wg         sync.WaitGroup
+	}
+	runningGenerator struct {
+		Generator
+		running bool
+		started time.Time
+		last    float64
+		random  *rand.Rand
+	}
+)
+
+const (
+	GeneratorSine       GeneratorKind = "sine"
+	GeneratorRamp       GeneratorKind = "ramp"
+	GeneratorSawtooth   GeneratorKind = "sawtooth"
+	GeneratorSquare     GeneratorKind = "square"
+	GeneratorRandomWalk GeneratorKind = "random_walk"
+	GeneratorNoise      GeneratorKind = "noise"
+	GeneratorSteps      GeneratorKind = "steps"
+	GeneratorCounter    GeneratorKind = "counter"
+)
+
+// NewGeneratorScheduler creates a scheduler updating generators every resolution (100 ms if zero).
+// Close must be called to stop it.
+func NewGeneratorScheduler(s *Server, resolution time.Duration) *GeneratorScheduler {
+	if resolution <= 0 {
+		resolution = 100 * time.Millisecond
+	}
+	gs := &GeneratorScheduler{
+		server:     s,
+		resolution: resolution,
+		generators: make(map[string]*runningGenerator),
+		stopChan:   make(chan struct{}),
+	}
+	gs.wg.Add(1)
+	go gs.run()
+	return gs
+}
+
+// UnmarshalJSON implements json.Unmarshaler, also accepting the period as a string like "1.5s". Unknown
+// fields are errors.
+func (g *Generator) UnmarshalJSON(data []byte) error {
+	type plain Generator
+	value := struct {
+		*plain
+		Period Duration `json:"period"`
+	}{plain: (*plain)(g), Period: Duration(g.Period)}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	g.Period = time.Duration(value.Period)
+	return nil
+}
+
+// Validate checks the generator definition.
+func (g *Generator) Validate() error {
+	if g.Name == "" {
+		return errors.New("generator name is empty")
+	}
+	if err := g.Type.Validate(); err != nil {
+		return fmt.Errorf("generator %s: %w", g.Name, err)
+	}
+	if err := g.ByteOrder.Validate(); err != nil {
+		return fmt.Errorf("generator %s: %w", g.Name, err)
+	}
+	if err := checkBitType(g.Table, g.Type); err != nil {
+		return fmt.Errorf("generator %s: %w", g.Name, err)
+	}
+	if int(g.Address)+g.Type.Registers() > 65536 {
+		return fmt.Errorf("generator %s: address %d out of range", g.Name, g.Address)
+	}
+	switch g.Kind {
+	case GeneratorSine, GeneratorRamp, GeneratorSawtooth, GeneratorSquare, GeneratorCounter:
+		if g.Period <= 0 {
+			return fmt.Errorf("generator %s: %s needs a positive period", g.Name, g.Kind)
+		}
+	case GeneratorSteps:
+		if g.Period <= 0 || len(g.Steps) == 0 {
+			return fmt.Errorf("generator %s: steps needs a positive period and at least one step", g.Name)
+		}
+	case GeneratorRandomWalk, GeneratorNoise:
+	default:
+		return fmt.Errorf("generator %s: unknown kind %q", g.Name, g.Kind)
+	}
+	return nil
+}
+
+// Add adds a stopped generator.
+func (gs *GeneratorScheduler) Add(generator Generator) (err error) {
+	if err = generator.Validate(); err != nil {
+		return
+	}
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	if _, ok := gs.generators[generator.Name]; ok {
+		return fmt.Errorf("generator %s already exists", generator.Name)
+	}
+	seed := generator.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	generator.Steps = slices.Clone(generator.Steps)
+	gs.generators[generator.Name] = &runningGenerator{Generator: generator, random: rand.New(rand.NewPCG(seed, seed))}
+	gs.names = append(gs.names, generator.Name)
+	return
+}
+
+// Remove stops and removes the generator.
+func (gs *GeneratorScheduler) Remove(name string) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	delete(gs.generators, name)
+	gs.names = slices.DeleteFunc(gs.names, func(current string) bool { return current == name })
+}
+
+// Start starts the generator from the beginning of its waveform.
+func (gs *GeneratorScheduler) Start(name string) (err error) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	generator, ok := gs.generators[name]
+	if !ok {
+		return fmt.Errorf("unknown generator %s", name)
+	}
+	generator.running = true
+	generator.started = time.Now()
+	generator.last = generator.Offset
+	return
+}
+
+// Stop stops the generator, leaving its last value in memory.
+func (gs *GeneratorScheduler) Stop(name string) (err error) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	generator, ok := gs.generators[name]
+	if !ok {
+		return fmt.Errorf("unknown generator %s", name)
+	}
+	generator.running = false
+	return
+}
+
+// Running reports whether the generator is running.
+func (gs *GeneratorScheduler) Running(name string) bool {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	generator, ok := gs.generators[name]
+	return ok && generator.running
+}
+
+// Generators returns the definitions of all generators in the order they were added.
+func (gs *GeneratorScheduler) Generators() []Generator {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	generators := make([]Generator, len(gs.names))
+	for i, name := range gs.names {
+		generators[i] = gs.generators[name].Generator
+	}
+	return generators
+}
+
+// Close stops the scheduler.
+func (gs *GeneratorScheduler) Close() {
+	close(gs.stopChan)
+	gs.wg.Wait()
+}
+
+func (gs *GeneratorScheduler) run() {
+	defer gs.wg.Done()
+	ticker := time.NewTicker(gs.resolution)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gs.stopChan:
+			return
+		case now := <-ticker.C:
+			gs.update(now)
+		}
+	}
+}
+
+func (gs *GeneratorScheduler) update(now time.Time) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	var slaves []uint8
+	for _, name := range gs.names {
+		if generator := gs.generators[name]; generator.running && !slices.Contains(slaves, generator.Slave) {
+			slaves = append(slaves, generator.Slave)
+		}
+	}
+	for _, slave := range slaves {
+		err := gs.server.Update([]uint8{slave}, func(tx *Transaction) error {
+			for _, name := range gs.names {
+				generator := gs.generators[name]
+				if !generator.running || generator.Slave != slave {
+					continue
+				}
+				value := clampValue(generator.value(now.Sub(generator.started)), generator.Type)
+				if err := tx.WriteValue(generator.Slave, generator.Table, generator.Address, generator.Type, generator.ByteOrder, value); err != nil {
+					gs.fail(generator, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			for _, name := range gs.names {
+				if generator := gs.generators[name]; generator.running && generator.Slave == slave {
+					gs.fail(generator, err)
+				}
+			}
+		}
+	}
+}
+
+// fail stops a generator whose write failed, so the error is logged once.
+func (gs *GeneratorScheduler) fail(generator *runningGenerator, err error) {
+	generator.running = false
+	gs.server.logger.Error(fmt.Sprintf("Server: generator %s stopped: %s", generator.Name, err.Error()))
+}
+
+// value returns the generator value at the time elapsed since its start.
+func (g *runningGenerator) value(elapsed time.Duration) (value float64) {
+	phase := 0.0
+	periods := 0.0
+	if g.Period > 0 {
+		periods = math.Floor(float64(elapsed) / float64(g.Period))
+		phase = float64(elapsed)/float64(g.Period) - periods
+	}
+	switch g.Kind {
+	case GeneratorSine:
+		value = g.Offset + g.Amplitude*math.Sin(2*math.Pi*phase)
+	case GeneratorRamp:
+		if phase < 0.5 {
+			value = g.Offset + g.Amplitude*2*phase
+		} else {
+			value = g.Offset + g.Amplitude*2*(1-phase)
+		}
+	case GeneratorSawtooth:
+		value = g.Offset + g.Amplitude*phase
+	case GeneratorSquare:
+		if phase < 0.5 {
+			value = g.Offset + g.Amplitude
+		} else {
+			value = g.Offset - g.Amplitude
+		}
+	case GeneratorRandomWalk:
+		g.last += (g.random.Float64()*2 - 1) * g.Amplitude
+		g.last = clampValue(g.last, g.Type)
+		value = g.last
+	case GeneratorNoise:
+		value = g.Offset + g.random.NormFloat64()*g.Amplitude
+	case GeneratorSteps:
+		value = g.Steps[int(periods)%len(g.Steps)]
+	case GeneratorCounter:
+		value = wrapValue(g.Offset+g.Amplitude*periods, g.Type)
+	}
+	return
+}
+
+// typeRange returns the minimum and maximum values of the data type.
+func typeRange(dataType DataType) (minimum float64, maximum float64) {
+	bits := float64(16 * dataType.Registers())
+	switch dataType {
+	case TypeBool:
+		return math.Inf(-1), math.Inf(1)
+	case TypeUint16, TypeUint32, TypeUint64:
+		// The largest float64 below the limit, clampValue rounds it down to an integer.
+		return 0, math.Nextafter(math.Pow(2, bits), 0)
+	case TypeInt16, TypeInt32, TypeInt64:
+		return -math.Pow(2, bits-1), math.Nextafter(math.Pow(2, bits-1), 0)
+	case TypeFloat32:
+		return -math.MaxFloat32, math.MaxFloat32
+	}
+	return -math.MaxFloat64, math.MaxFloat64
+}
+
+func clampValue(value float64, dataType DataType) float64 {
+	if dataType == TypeBool {
+		if value > 0 {
+			return 1
+		}
+		return 0
+	}
+	minimum, maximum := typeRange(dataType)
+	if dataType != TypeFloat32 && dataType != TypeFloat64 {
+		minimum, maximum, value = math.Ceil(minimum), math.Floor(maximum), math.Round(value)
+	}
+	return math.Max(minimum, math.Min(maximum, value))
+}
+
+// wrapValue wraps an integer value around the range of the data type.
+func wrapValue(value float64, dataType DataType) float64 {
+	switch dataType {
+	case TypeBool, TypeFloat32, TypeFloat64:
+		return clampValue(value, dataType)
+	}
+	minimum, _ := typeRange(dataType)
+	size := math.Pow(2, float64(16*dataType.Registers()))
+	value = math.Mod(math.Round(value)-minimum, size)
+	if value < 0 {
+		value += size
+	}
+	return clampValue(value+minimum, dataType)
+}
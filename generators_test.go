@@ -0,0 +1,121 @@
+package modbusserver
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGeneratorValues(t *testing.T) {
+	for _, test := range []struct {
+		generator Generator
+		elapsed   time.Duration
+		expect    float64
+	}{
+		{Generator{Kind: GeneratorSine, Period: time.Second, Amplitude: 10, Offset: 5}, 250 * time.Millisecond, 15},
+		{Generator{Kind: GeneratorRamp, Period: time.Second, Amplitude: 10}, 250 * time.Millisecond, 5},
+		{Generator{Kind: GeneratorRamp, Period: time.Second, Amplitude: 10}, 750 * time.Millisecond, 5},
+		{Generator{Kind: GeneratorSawtooth, Period: time.Second, Amplitude: 10}, 1750 * time.Millisecond, 7.5},
+		{Generator{Kind: GeneratorSquare, Period: time.Second, Amplitude: 1, Offset: 2}, 600 * time.Millisecond, 1},
+		{Generator{Kind: GeneratorSteps, Period: time.Second, Steps: []float64{1, 2, 3}}, 4500 * time.Millisecond, 2},
+		{Generator{Kind: GeneratorCounter, Type: TypeUint16, Period: time.Second, Amplitude: 2, Offset: 1}, 3 * time.Second, 7},
+	} {
+		generator := &runningGenerator{Generator: test.generator}
+		if got := generator.value(test.elapsed); math.Abs(got-test.expect) > 1e-9 {
+			t.Errorf("%s at %v: expected %v, got %v", test.generator.Kind, test.elapsed, test.expect, got)
+		}
+	}
+}
+
+func TestGeneratorClampAndWrap(t *testing.T) {
+	if got := clampValue(70000, TypeUint16); got != 65535 {
+		t.Errorf("expected %v, got %v", 65535, got)
+	}
+	if got := clampValue(-40000, TypeInt16); got != -32768 {
+		t.Errorf("expected %v, got %v", -32768, got)
+	}
+	if got := wrapValue(65537, TypeUint16); got != 1 {
+		t.Errorf("expected %v, got %v", 1, got)
+	}
+	if got := wrapValue(32768, TypeInt16); got != -32768 {
+		t.Errorf("expected %v, got %v", -32768, got)
+	}
+	if got := clampValue(-0.5, TypeBool); got != 0 {
+		t.Errorf("expected %v, got %v", 0, got)
+	}
+}
+
+func TestGeneratorScheduler(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	gs := NewGeneratorScheduler(s, time.Millisecond)
+	defer gs.Close()
+
+	err := gs.Add(Generator{Name: "flow", Slave: 1, Table: TableInputRegisters, Address: 0, Type: TypeFloat32, Kind: GeneratorSteps, Period: time.Hour, Steps: []float64{2.5}})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	gs.Add(Generator{Name: "alarm", Slave: 1, Table: TableDiscreteInputs, Address: 3, Type: TypeBool, Kind: GeneratorSquare, Period: time.Hour, Amplitude: 1})
+	if err = gs.Add(Generator{Name: "flow", Slave: 1, Table: TableInputRegisters, Type: TypeUint16, Kind: GeneratorNoise}); err == nil {
+		t.Errorf("expected duplicate name error, got nil")
+	}
+	if err = gs.Add(Generator{Name: "bad", Slave: 1, Table: TableCoils, Type: TypeUint16, Kind: GeneratorNoise}); err == nil {
+		t.Errorf("expected bit table error, got nil")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if value, _ := s.ReadValue(1, TableInputRegisters, 0, TypeFloat32, OrderABCD); value != 0 {
+		t.Errorf("expected stopped generator not to write, got %v", value)
+	}
+
+	gs.Start("flow")
+	gs.Start("alarm")
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		flow, _ := s.ReadValue(1, TableInputRegisters, 0, TypeFloat32, OrderABCD)
+		alarm, _ := s.ReadValue(1, TableDiscreteInputs, 3, TypeBool, "")
+		if flow == 2.5 && alarm == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if value, _ := s.ReadValue(1, TableInputRegisters, 0, TypeFloat32, OrderABCD); value != 2.5 {
+		t.Errorf("expected %v, got %v", 2.5, value)
+	}
+	if value, _ := s.ReadValue(1, TableDiscreteInputs, 3, TypeBool, ""); value != 1 {
+		t.Errorf("expected %v, got %v", 1, value)
+	}
+
+	gs.Stop("flow")
+	if gs.Running("flow") || !gs.Running("alarm") {
+		t.Errorf("unexpected running state")
+	}
+}
+
+func TestGeneratorRemovedSlave(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.InitSlave(2)
+	gs := NewGeneratorScheduler(s, time.Millisecond)
+	defer gs.Close()
+
+	gs.Add(Generator{Name: "level", Slave: 1, Table: TableHoldingRegisters, Address: 0, Type: TypeUint16, Kind: GeneratorCounter, Period: time.Millisecond, Amplitude: 1})
+	gs.Add(Generator{Name: "removed", Slave: 2, Table: TableHoldingRegisters, Address: 0, Type: TypeUint16, Kind: GeneratorCounter, Period: time.Millisecond, Amplitude: 1})
+	s.RemoveSlave(2)
+	gs.Start("level")
+	gs.Start("removed")
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && gs.Running("removed") {
+		time.Sleep(time.Millisecond)
+	}
+	if gs.Running("removed") || !gs.Running("level") {
+		t.Fatalf("expected only the generator of the removed slave to stop")
+	}
+	first, _ := s.ReadValue(1, TableHoldingRegisters, 0, TypeUint16, "")
+	time.Sleep(20 * time.Millisecond)
+	if value, _ := s.ReadValue(1, TableHoldingRegisters, 0, TypeUint16, ""); value <= first {
+		t.Errorf("expected the generator of slave 1 to keep counting, got %v then %v", first, value)
+	}
+}
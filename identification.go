@@ -0,0 +1,143 @@
+package modbusserver
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"golang.org/x/exp/maps"
+)
+
+// Object IDs of Read Device Identification. IDs 0x07-0x7F are reserved regular objects and 0x80-0xFF
+// are extended, vendor specific objects.
+const (
+	ObjectVendorName uint8 = iota
+	ObjectProductCode
+	ObjectMajorMinorRevision
+	ObjectVendorURL
+	ObjectProductName
+	ObjectModelName
+	ObjectUserApplicationName
+)
+
+// meiReadDeviceIdentification is the MEI type of Read Device Identification.
+const meiReadDeviceIdentification = 0x0E
+
+var objectNames = []string{
+	"vendor_name",
+	"product_code",
+	"major_minor_revision",
+	"vendor_url",
+	"product_name",
+	"model_name",
+	"user_application_name",
+}
+
+// ParseObjectID parses the name of a standard identification object (vendor_name, product_code,
+// major_minor_revision, vendor_url, product_name, model_name, user_application_name) or a numeric ID.
+func ParseObjectID(name string) (uint8, error) {
+	if index := slices.Index(objectNames, name); index >= 0 {
+		return uint8(index), nil
+	}
+	id, err := strconv.ParseUint(name, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown identification object %q", name)
+	}
+	return uint8(id), nil
+}
+
+// SetDeviceIdentification sets the objects answered by the slave to Read Device Identification
+// (function 43, MEI type 14). A slave without objects answers the function with IllegalFunction.
+func (s *Server) SetDeviceIdentification(id uint8, objects map[uint8]string) (err error) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	if _, ok := s.Slaves[id]; !ok {
+		return fmt.Errorf("slave with %d ID didn't implemented on server (must be in %v)", id, maps.Keys(s.Slaves))
+	}
+	for objectID, value := range objects {
+		// The object must fit in a response with its ID and length.
+		if len(value) > 245 {
+			return fmt.Errorf("identification object %d longer than 245 bytes", objectID)
+		}
+	}
+	if len(objects) == 0 {
+		delete(s.identification, id)
+		return
+	}
+	s.identification[id] = maps.Clone(objects)
+	return
+}
+
+// DeviceIdentification returns a copy of the identification objects of the slave.
+func (s *Server) DeviceIdentification(id uint8) map[uint8]string {
+	s.memoryMutex.RLock()
+	defer s.memoryMutex.RUnlock()
+	return maps.Clone(s.identification[id])
+}
+
+// ReadDeviceIdentification function 43, MEI type 14, reads the identification objects of the slave by
+// stream (basic, regular, extended) or individually.
+func ReadDeviceIdentification(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 3 {
+		return []byte{}, &IllegalDataValue
+	}
+	if data[0] != meiReadDeviceIdentification {
+		return []byte{}, &IllegalFunction
+	}
+	objects := s.identification[frame.GetSlaveId()]
+	if len(objects) == 0 {
+		return []byte{}, &IllegalFunction
+	}
+	code, objectID := data[1], data[2]
+	ids := maps.Keys(objects)
+	slices.Sort(ids)
+	conformity := uint8(0x81)
+	switch last := ids[len(ids)-1]; {
+	case last >= 0x80:
+		conformity = 0x83
+	case last > ObjectMajorMinorRevision:
+		conformity = 0x82
+	}
+	response := []byte{meiReadDeviceIdentification, code, conformity, 0, 0, 0}
+	appendObject := func(id uint8) bool {
+		if len(response)+2+len(objects[id]) > 253 {
+			return false
+		}
+		response = append(response, id, uint8(len(objects[id])))
+		response = append(response, objects[id]...)
+		response[5]++
+		return true
+	}
+	var last uint8
+	switch code {
+	case 1:
+		last = ObjectMajorMinorRevision
+	case 2:
+		last = 0x7F
+	case 3:
+		last = 0xFF
+	case 4:
+		if _, ok := objects[objectID]; !ok {
+			return []byte{}, &IllegalDataAddress
+		}
+		appendObject(objectID)
+		return response, &Success
+	default:
+		return []byte{}, &IllegalDataValue
+	}
+	// A stream restarts from the first object if the requested one doesn't exist.
+	if _, ok := objects[objectID]; !ok || objectID > last {
+		objectID = 0
+	}
+	for _, id := range ids {
+		if id < objectID || id > last {
+			continue
+		}
+		if !appendObject(id) {
+			response[3], response[4] = 0xFF, id
+			break
+		}
+	}
+	return response, &Success
+}
@@ -0,0 +1,70 @@
+package modbusserver
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func readDeviceIdentification(s *Server, code uint8, object uint8) ([]byte, *Exception) {
+	frame := &TCPFrame{Device: 1, Function: 43}
+	frame.SetData([]byte{meiReadDeviceIdentification, code, object})
+	return ReadDeviceIdentification(s, frame)
+}
+
+func TestReadDeviceIdentification(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	if _, exception := readDeviceIdentification(s, 1, 0); exception != &IllegalFunction {
+		t.Errorf("expected IllegalFunction, got %v", exception)
+	}
+	err := s.SetDeviceIdentification(1, map[uint8]string{
+		ObjectVendorName:         "Acme",
+		ObjectProductCode:        "PM",
+		ObjectMajorMinorRevision: "1.0",
+		ObjectModelName:          "M1",
+		0x80:                     strings.Repeat("a", 200),
+		0x81:                     strings.Repeat("b", 200),
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	data, exception := readDeviceIdentification(s, 1, 0)
+	expected := []byte{0x0E, 1, 0x83, 0, 0, 3, 0, 4, 'A', 'c', 'm', 'e', 1, 2, 'P', 'M', 2, 3, '1', '.', '0'}
+	if exception != &Success || !slices.Equal(data, expected) {
+		t.Errorf("expected %v, got %v, %v", expected, data, exception)
+	}
+
+	// The extended stream doesn't fit in one response.
+	data, _ = readDeviceIdentification(s, 3, 0)
+	if data[3] != 0xFF || data[4] != 0x81 || data[5] != 5 {
+		t.Errorf("expected more follows from object 0x81 after 5 objects, got %v", data[:6])
+	}
+	data, _ = readDeviceIdentification(s, 3, 0x81)
+	if data[3] != 0 || data[5] != 1 || data[6] != 0x81 {
+		t.Errorf("expected last object 0x81, got %v", data[:7])
+	}
+
+	data, exception = readDeviceIdentification(s, 4, ObjectModelName)
+	if exception != &Success || !slices.Equal(data, []byte{0x0E, 4, 0x83, 0, 0, 1, 5, 2, 'M', '1'}) {
+		t.Errorf("expected model name, got %v, %v", data, exception)
+	}
+	if _, exception = readDeviceIdentification(s, 4, ObjectVendorURL); exception != &IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception)
+	}
+	if _, exception = readDeviceIdentification(s, 5, 0); exception != &IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception)
+	}
+
+	if id, err := ParseObjectID("model_name"); err != nil || id != ObjectModelName {
+		t.Errorf("expected %v, got %v, %v", ObjectModelName, id, err)
+	}
+	if id, err := ParseObjectID("0x90"); err != nil || id != 0x90 {
+		t.Errorf("expected %v, got %v, %v", 0x90, id, err)
+	}
+	if _, err := ParseObjectID("serial"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -4,11 +4,15 @@
 package modbusserver
 
 import (
+	"fmt"
 	"log"
 	"log/slog"
+	"os"
 	"os/exec"
+	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/goburrow/modbus"
 	"github.com/goburrow/serial"
@@ -83,3 +87,22 @@ func TestModbusRTU(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+// openPTY opens a pseudo terminal, returning its master side and the path of its slave side.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo terminals unavailable: %v", err)
+	}
+	var unlock, number int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Skipf("unable to unlock the pseudo terminal: %v", errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); errno != 0 {
+		master.Close()
+		t.Skipf("unable to get the pseudo terminal number: %v", errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", number)
+}
@@ -0,0 +1,191 @@
+package modbusserver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var errReadOnlyAlias = errors.New("write to read-only alias")
+
+// WriteEvent describes a write to slave memory. For coils and discrete inputs Values hold 0 or 1.
+type WriteEvent struct {
+	Slave   uint8
+	Table   Table
+	Address uint16
+	Values  []uint16
+}
+
+// AddWriteHook registers a function called for every write to slave memory, made by a Modbus master
+// or the application, before the write is applied. An error cancels the write; a Modbus master then
+// gets SlaveDeviceFailure. Hooks run with the slave memory locked and must not access it through the
+// Server methods.
+func (s *Server) AddWriteHook(hook func(event WriteEvent) error) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	s.writeHooks = append(s.writeHooks, hook)
+}
+
+// ReadTable returns quantity values of a slave table starting from address.
+func (s *Server) ReadTable(id uint8, table Table, address uint16, quantity int) (values []uint16, err error) {
+	s.memoryMutex.RLock()
+	defer s.memoryMutex.RUnlock()
+	return s.readRaw(id, table, address, quantity)
+}
+
+// WriteTable writes values to a slave table starting from address, bypassing write validation rules.
+func (s *Server) WriteTable(id uint8, table Table, address uint16, values []uint16) (err error) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	return s.writeRaw(id, table, address, values)
+}
+
+// slaveResponds reports whether requests addressed to the slave should be processed.
+func (s *Server) slaveResponds(id uint8) bool {
+	s.memoryMutex.RLock()
+	defer s.memoryMutex.RUnlock()
+	_, ok := s.Slaves[id]
+	return ok && !slices.Contains(s.SlavesStoppedResponse, id)
+}
+
+// commitWrite runs the write hooks and applies a validated Modbus write. The memory must be locked.
+func (s *Server) commitWrite(id uint8, table Table, address int, values []uint16) *Exception {
+	if len(values) == 0 {
+		return &Success
+	}
+	if err := s.writeRaw(id, table, uint16(address), values); err != nil {
+		if errors.Is(err, errReadOnlyAlias) {
+			return &IllegalDataAddress
+		}
+		s.logger.Error(fmt.Sprintf("Slave %d: write to %s %d canceled: %s", id, table, address, err.Error()))
+		return &SlaveDeviceFailure
+	}
+	return &Success
+}
+
+func (s *Server) checkRange(id uint8, table Table, address uint16, count int) (slave SlaveData, err error) {
+	slave, ok := s.Slaves[id]
+	if !ok {
+		err = fmt.Errorf("slave with %d ID didn't implemented on server", id)
+		return
+	}
+	if count == 0 || int(address)+count > 65536 {
+		err = fmt.Errorf("invalid range: address %d, quantity %d", address, count)
+	}
+	return
+}
+
+// readRaw reads slave memory. The memory must be locked.
+func (s *Server) readRaw(id uint8, table Table, address uint16, count int) (registers []uint16, err error) {
+	slave, err := s.checkRange(id, table, address, count)
+	if err != nil {
+		return
+	}
+	registers = make([]uint16, count)
+	switch table {
+	case TableCoils:
+		for i := range registers {
+			registers[i] = uint16(slave.Coils[int(address)+i])
+		}
+	case TableDiscreteInputs:
+		for i := range registers {
+			registers[i] = uint16(slave.DiscreteInputs[int(address)+i])
+		}
+	case TableHoldingRegisters:
+		copy(registers, slave.HoldingRegisters[address:])
+	case TableInputRegisters:
+		copy(registers, slave.InputRegisters[address:])
+	default:
+		err = fmt.Errorf("unknown table %d", table)
+	}
+	return
+}
+
+// writeRaw runs the write hooks, logs the write and writes slave memory. The memory must be locked.
+func (s *Server) writeRaw(id uint8, table Table, address uint16, registers []uint16) (err error) {
+	return s.commitEvents([]WriteEvent{{Slave: id, Table: table, Address: address, Values: registers}})
+}
+
+// commitEvents checks the writes and runs the write hooks for all of them and their mirrored writes
+// before logging, applying and emitting any, so the writes are applied either all or none. The memory
+// must be locked.
+func (s *Server) commitEvents(events []WriteEvent) (err error) {
+	for _, event := range events {
+		if _, err = s.checkRange(event.Slave, event.Table, event.Address, len(event.Values)); err != nil {
+			return
+		}
+		if event.Table > TableInputRegisters {
+			return fmt.Errorf("unknown table %d", event.Table)
+		}
+		if s.aliasReadOnly(event.Slave, event.Table, int(event.Address), len(event.Values)) {
+			return fmt.Errorf("slave %d %s %d: %w", event.Slave, event.Table, event.Address, errReadOnlyAlias)
+		}
+	}
+	// Mirrored writes are seen by the hooks and the subscribers, but only the written events are logged
+	// since replaying them mirrors them again.
+	var applied []WriteEvent
+	for _, event := range events {
+		applied = append(applied, event)
+		applied = append(applied, s.mirroredWrites(event)...)
+	}
+	for _, event := range applied {
+		for _, hook := range s.writeHooks {
+			if err = hook(event); err != nil {
+				return
+			}
+		}
+	}
+	if s.writeLog != nil {
+		if err = s.writeLog.append(events...); err != nil {
+			return
+		}
+	}
+	for _, event := range applied {
+		s.storeWrite(event)
+		s.emitWrite(event)
+	}
+	return
+}
+
+// applyWrite stores the event values without any checks and mirrors them through the alias windows.
+// The memory must be locked.
+func (s *Server) applyWrite(event WriteEvent) {
+	s.storeWrite(event)
+	for _, mirrored := range s.mirroredWrites(event) {
+		s.storeWrite(mirrored)
+	}
+}
+
+// mirroredWrites returns the writes mirroring the event through the alias windows. The memory must be
+// locked.
+func (s *Server) mirroredWrites(event WriteEvent) []WriteEvent {
+	if len(s.aliasWindows) == 0 {
+		return nil
+	}
+	return s.mirrorWrites(event, make([]bool, len(s.aliasWindows)))
+}
+
+func (s *Server) storeWrite(event WriteEvent) {
+	slave := s.Slaves[event.Slave]
+	switch event.Table {
+	case TableCoils:
+		for i, value := range event.Values {
+			slave.Coils[int(event.Address)+i] = bitValue(value)
+		}
+	case TableDiscreteInputs:
+		for i, value := range event.Values {
+			slave.DiscreteInputs[int(event.Address)+i] = bitValue(value)
+		}
+	case TableHoldingRegisters:
+		copy(slave.HoldingRegisters[event.Address:], event.Values)
+	case TableInputRegisters:
+		copy(slave.InputRegisters[event.Address:], event.Values)
+	}
+}
+
+func bitValue(value uint16) byte {
+	if value != 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package modbusserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Transports of the frame parsing errors.
+const (
+	transportTCP        = "tcp"
+	transportRTUOverTCP = "rtu_over_tcp"
+	transportRTU        = "rtu"
+)
+
+// serverMetrics are the Prometheus metrics of a server, in a registry of their own so that servers of
+// one process don't share them.
+type serverMetrics struct {
+	registry    *prometheus.Registry
+	requests    *prometheus.CounterVec
+	exceptions  *prometheus.CounterVec
+	latency     *prometheus.HistogramVec
+	connections *prometheus.GaugeVec
+	frameErrors *prometheus.CounterVec
+	queueDepth  prometheus.Gauge
+}
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_requests_total",
+			Help: "Requests received, by listener or serial device, slave and function code.",
+		}, []string{"listener", "slave", "function"}),
+		exceptions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_exceptions_total",
+			Help: "Exception responses sent, by listener or serial device and exception code.",
+		}, []string{"listener", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "modbus_request_duration_seconds",
+			Help:    "Time from receiving a request to sending its response, by listener or serial device and function code.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"listener", "function"}),
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "modbus_connections",
+			Help: "Open TCP connections, by listener.",
+		}, []string{"listener"}),
+		frameErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modbus_frame_errors_total",
+			Help: "Received packets dropped as malformed, by transport and reason (crc, length or other).",
+		}, []string{"transport", "reason"}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "modbus_request_queue_depth",
+			Help: "Requests waiting for the request handler.",
+		}),
+	}
+	m.registry.MustRegister(m)
+	return m
+}
+
+// Describe implements prometheus.Collector.
+func (m *serverMetrics) Describe(descriptions chan<- *prometheus.Desc) {
+	m.requests.Describe(descriptions)
+	m.exceptions.Describe(descriptions)
+	m.latency.Describe(descriptions)
+	m.connections.Describe(descriptions)
+	m.frameErrors.Describe(descriptions)
+	m.queueDepth.Describe(descriptions)
+}
+
+// Collect implements prometheus.Collector.
+func (m *serverMetrics) Collect(metrics chan<- prometheus.Metric) {
+	m.requests.Collect(metrics)
+	m.exceptions.Collect(metrics)
+	m.latency.Collect(metrics)
+	m.connections.Collect(metrics)
+	m.frameErrors.Collect(metrics)
+	m.queueDepth.Collect(metrics)
+}
+
+// Metrics returns the collector of the server metrics, to register them in the registry of the
+// application instead of serving them with MetricsHandler.
+func (s *Server) Metrics() prometheus.Collector {
+	return s.metrics
+}
+
+// MetricsHandler returns the HTTP handler of the server metrics in the Prometheus format.
+func (s *Server) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// ListenMetrics serves the server metrics at /metrics on the address until the server is closed.
+func (s *Server) ListenMetrics(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", s.MetricsHandler())
+	return s.listenHTTP(address, mux)
+}
+
+// enqueue passes the request to the request handler.
+func (s *Server) enqueue(request *Request) {
+	s.metrics.queueDepth.Inc()
+	s.requestChan <- request
+}
+
+// frameError counts a packet of the transport dropped as malformed.
+func (m *serverMetrics) frameError(transport string, err error) {
+	reason := "other"
+	if errors.Is(err, errFrameChecksum) {
+		reason = "crc"
+	} else if errors.Is(err, errFrameLength) {
+		reason = "length"
+	}
+	m.frameErrors.WithLabelValues(transport, reason).Inc()
+}
+
+// received counts a request taken by the request handler.
+func (m *serverMetrics) received(request *Request) {
+	m.queueDepth.Dec()
+	m.requests.WithLabelValues(request.address, strconv.Itoa(int(request.frame.GetSlaveId())), strconv.Itoa(int(request.frame.GetFunction()))).Inc()
+}
+
+// sent counts the response to the request and observes the request latency.
+func (m *serverMetrics) sent(request *Request, response Framer, received time.Time) {
+	function := request.frame.GetFunction()
+	m.latency.WithLabelValues(request.address, strconv.Itoa(int(function))).Observe(time.Since(received).Seconds())
+	if response.GetFunction()&0x80 != 0 && len(response.GetData()) != 0 {
+		m.exceptions.WithLabelValues(request.address, strconv.Itoa(int(GetException(response)))).Inc()
+	}
+}
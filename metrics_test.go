@@ -0,0 +1,71 @@
+package modbusserver
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	tcpAddr, rtuAddr, metricsAddr := getFreePort(), getFreePort(), getFreePort()
+	if err := s.ListenTCP(tcpAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	if err := s.ListenRTUOverTCP(rtuAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	if err := s.ListenMetrics(metricsAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	tcpClient, err := DialTCPClient(tcpAddr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer tcpClient.Close()
+	rtuClient, err := DialRTUOverTCPClient(rtuAddr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer rtuClient.Close()
+
+	tcpClient.ReadHoldingRegisters(1, 0, 2)
+	tcpClient.ReadHoldingRegisters(1, 0, 2)
+	tcpClient.ReadHoldingRegisters(1, 65535, 2)
+	rtuClient.WriteSingleRegister(1, 0, 1)
+	request := tcpClient.Frame(1, 3, []byte{0, 0, 0, 1}).Bytes()
+	request[5]++
+	tcpClient.WithTimeout(50 * time.Millisecond).SendRaw(request)
+	request = (&RTUFrame{SlaveId: 1, Function: 3, Data: []byte{0, 0, 0, 1}}).Bytes()
+	request[len(request)-1]++
+	rtuClient.WithTimeout(50 * time.Millisecond).SendRaw(request)
+
+	response, err := http.Get("http://" + metricsAddr + "/metrics")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	lines := strings.Split(string(body), "\n")
+	for _, expected := range []string{
+		`modbus_requests_total{function="3",listener="` + tcpAddr + `",slave="1"} 3`,
+		`modbus_requests_total{function="6",listener="` + rtuAddr + `",slave="1"} 1`,
+		`modbus_exceptions_total{code="2",listener="` + tcpAddr + `"} 1`,
+		`modbus_request_duration_seconds_count{function="3",listener="` + tcpAddr + `"} 3`,
+		`modbus_connections{listener="` + tcpAddr + `"} 1`,
+		`modbus_connections{listener="` + rtuAddr + `"} 1`,
+		`modbus_frame_errors_total{reason="length",transport="tcp"} 1`,
+		`modbus_frame_errors_total{reason="crc",transport="rtu_over_tcp"} 1`,
+		`modbus_request_queue_depth 0`,
+	} {
+		if !slices.Contains(lines, expected) {
+			t.Errorf("expected %s in metrics:\n%s", expected, body)
+		}
+	}
+}
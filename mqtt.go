@@ -0,0 +1,315 @@
+package modbusserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type (
+	// MQTTConfig configures an MQTTBridge.
+	MQTTConfig struct {
+		// Broker is the broker URL, as "tcp://localhost:1883".
+		Broker       string            `json:"broker" yaml:"broker"`
+		ClientID     string            `json:"client_id,omitempty" yaml:"client_id,omitempty"`
+		Username     string            `json:"username,omitempty" yaml:"username,omitempty"`
+		Password     string            `json:"password,omitempty" yaml:"password,omitempty"`
+		Publications []MQTTPublication `json:"publications,omitempty" yaml:"publications,omitempty"`
+		Commands     []MQTTCommand     `json:"commands,omitempty" yaml:"commands,omitempty"`
+		// MaxReconnectInterval limits the exponential backoff between reconnection attempts, 1 minute if
+		// zero.
+		MaxReconnectInterval Duration `json:"max_reconnect_interval,omitempty" yaml:"max_reconnect_interval,omitempty"`
+	}
+	// MQTTPublication publishes the value of a tag, or of an address block, to a topic when it changes.
+	MQTTPublication struct {
+		Topic string `json:"topic" yaml:"topic"`
+		// Tag is the name of the published tag. Without it, the block of Quantity values (1 if zero) at
+		// Address is published.
+		Tag      string `json:"tag,omitempty" yaml:"tag,omitempty"`
+		Slave    uint8  `json:"slave,omitempty" yaml:"slave,omitempty"`
+		Table    Table  `json:"table,omitempty" yaml:"table,omitempty"`
+		Address  uint16 `json:"address,omitempty" yaml:"address,omitempty"`
+		Quantity uint16 `json:"quantity,omitempty" yaml:"quantity,omitempty"`
+		// QoS is 0 or 1.
+		QoS      byte `json:"qos,omitempty" yaml:"qos,omitempty"`
+		Retained bool `json:"retained,omitempty" yaml:"retained,omitempty"`
+	}
+	// MQTTCommand writes the messages of a topic to a tag, or to coils or holding registers from Address,
+	// with the write validation of Modbus masters. Payloads are {"value": 21.5} for tags and
+	// {"values": [1, 2]} or {"value": 1} for addresses, a value from 0 to 65535 without fraction.
+	MQTTCommand struct {
+		Topic   string `json:"topic" yaml:"topic"`
+		Tag     string `json:"tag,omitempty" yaml:"tag,omitempty"`
+		Slave   uint8  `json:"slave,omitempty" yaml:"slave,omitempty"`
+		Table   Table  `json:"table,omitempty" yaml:"table,omitempty"`
+		Address uint16 `json:"address,omitempty" yaml:"address,omitempty"`
+		QoS     byte   `json:"qos,omitempty" yaml:"qos,omitempty"`
+	}
+	// MQTTMessage is the JSON payload of a publication: the tag value and units, or the block values.
+	MQTTMessage struct {
+		Tag     string    `json:"tag,omitempty"`
+		Value   *float64  `json:"value,omitempty"`
+		Units   string    `json:"units,omitempty"`
+		Slave   *uint8    `json:"slave,omitempty"`
+		Table   *Table    `json:"table,omitempty"`
+		Address *uint16   `json:"address,omitempty"`
+		Values  []uint16  `json:"values,omitempty"`
+		Time    time.Time `json:"time"`
+	}
+	// MQTTBridge publishes value changes to an MQTT broker and writes the commands it receives,
+	// reconnecting with exponential backoff.
+	MQTTBridge struct {
+		server      *Server
+		tags        *TagDatabase
+		config      MQTTConfig
+		client      mqtt.Client
+		mutex       sync.Mutex
+		published   []string
+		changedChan chan struct{}
+		closed      atomic.Bool
+		stopChan    chan struct{}
+		wg          sync.WaitGroup
+	}
+)
+
+// Validate checks the broker and the publications and commands, with the tags of the database (nil
+// without tags). All errors are reported together.
+func (c *MQTTConfig) Validate(tags *TagDatabase) error {
+	var errs []error
+	if c.Broker == "" {
+		errs = append(errs, errors.New("MQTT broker is empty"))
+	}
+	checkTag := func(topic string, name string, writable bool) {
+		if tags == nil {
+			errs = append(errs, fmt.Errorf("topic %s: tag %s without tag database", topic, name))
+			return
+		}
+		tag, ok := tags.Tag(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("topic %s: unknown tag %s", topic, name))
+		} else if writable && tag.Table != TableCoils && tag.Table != TableHoldingRegisters {
+			errs = append(errs, fmt.Errorf("topic %s: tag %s in read-only %s", topic, name, tag.Table))
+		}
+	}
+	for _, p := range c.Publications {
+		switch {
+		case p.Topic == "" || strings.ContainsAny(p.Topic, "#+"):
+			errs = append(errs, fmt.Errorf("publication topic %q: empty or wildcard", p.Topic))
+		case p.QoS > 1:
+			errs = append(errs, fmt.Errorf("topic %s: QoS %d is not 0 or 1", p.Topic, p.QoS))
+		case p.Tag != "":
+			checkTag(p.Topic, p.Tag, false)
+		case p.Table > TableInputRegisters || int(p.Address)+max(int(p.Quantity), 1) > 65536:
+			errs = append(errs, fmt.Errorf("topic %s: invalid block %s %d, quantity %d", p.Topic, p.Table, p.Address, p.Quantity))
+		}
+	}
+	for _, command := range c.Commands {
+		switch {
+		case command.Topic == "":
+			errs = append(errs, errors.New("command topic is empty"))
+		case command.QoS > 1:
+			errs = append(errs, fmt.Errorf("topic %s: QoS %d is not 0 or 1", command.Topic, command.QoS))
+		case command.Tag != "":
+			checkTag(command.Topic, command.Tag, true)
+		case command.Table != TableCoils && command.Table != TableHoldingRegisters:
+			errs = append(errs, fmt.Errorf("topic %s: %s are not writable", command.Topic, command.Table))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LoadMQTTConfigFile reads a bridge configuration from a JSON or YAML file, chosen by extension.
+// Unknown fields are errors.
+func LoadMQTTConfigFile(path string) (MQTTConfig, error) {
+	return loadConfigFile[MQTTConfig](path, "MQTT config")
+}
+
+// NewMQTTBridge starts connecting to the broker, in the background and until it succeeds. On each
+// connection, the command topics are subscribed and all publications are sent; then a publication is
+// sent when a write changes its value. tags may be nil if no tag is used. Close must be called to
+// disconnect.
+func NewMQTTBridge(s *Server, tags *TagDatabase, config MQTTConfig) (*MQTTBridge, error) {
+	if err := config.Validate(tags); err != nil {
+		return nil, err
+	}
+	if config.MaxReconnectInterval == 0 {
+		config.MaxReconnectInterval = Duration(time.Minute)
+	}
+	b := &MQTTBridge{
+		server:      s,
+		tags:        tags,
+		config:      config,
+		published:   make([]string, len(config.Publications)),
+		changedChan: make(chan struct{}, 1),
+		stopChan:    make(chan struct{}),
+	}
+	options := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(time.Second).
+		SetMaxReconnectInterval(time.Duration(config.MaxReconnectInterval)).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			s.logger.Warn(fmt.Sprintf("MQTT bridge: connection to %s lost: %s", config.Broker, err.Error()))
+		})
+	b.client = mqtt.NewClient(options)
+	s.AddWriteHook(b.onWrite)
+	b.client.Connect()
+	b.wg.Add(1)
+	go b.run()
+	return b, nil
+}
+
+// Close disconnects from the broker and stops publishing.
+func (b *MQTTBridge) Close() {
+	if b.closed.Swap(true) {
+		return
+	}
+	close(b.stopChan)
+	b.wg.Wait()
+	b.client.Disconnect(250)
+}
+
+// Connected returns true while the bridge is connected to the broker.
+func (b *MQTTBridge) Connected() bool {
+	return b.client.IsConnectionOpen()
+}
+
+func (b *MQTTBridge) onConnect(client mqtt.Client) {
+	b.server.logger.Info(fmt.Sprintf("MQTT bridge: connected to %s", b.config.Broker))
+	for _, command := range b.config.Commands {
+		command := command
+		client.Subscribe(command.Topic, command.QoS, func(_ mqtt.Client, message mqtt.Message) {
+			if err := b.execute(command, message.Payload()); err != nil {
+				b.server.logger.Warn(fmt.Sprintf("MQTT bridge: command of topic %s rejected: %s", command.Topic, err.Error()))
+			}
+		})
+	}
+	b.publish(true)
+}
+
+// onWrite requests publishing after writes to published addresses.
+func (b *MQTTBridge) onWrite(event WriteEvent) error {
+	if b.closed.Load() {
+		return nil
+	}
+	for _, p := range b.config.Publications {
+		slave, table, address, end := p.Slave, p.Table, int(p.Address), int(p.Address)+max(int(p.Quantity), 1)
+		if p.Tag != "" {
+			tag, ok := b.tags.Tag(p.Tag)
+			if !ok {
+				continue
+			}
+			slave, table, address, end = tag.Slave, tag.Table, int(tag.Address), tag.End()
+		}
+		if event.Slave == slave && event.Table == table && address < int(event.Address)+len(event.Values) && int(event.Address) < end {
+			select {
+			case b.changedChan <- struct{}{}:
+			default:
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func (b *MQTTBridge) run() {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-b.stopChan:
+			return
+		case <-b.changedChan:
+			b.publish(false)
+		}
+	}
+}
+
+// publish sends the publications whose value changed since it was last sent, or all of them.
+func (b *MQTTBridge) publish(all bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.client.IsConnectionOpen() {
+		return
+	}
+	for i, p := range b.config.Publications {
+		message, err := b.message(p)
+		if err != nil {
+			b.server.logger.Warn(fmt.Sprintf("MQTT bridge: topic %s: %s", p.Topic, err.Error()))
+			continue
+		}
+		// The time is not part of the comparison.
+		value := fmt.Sprint(message.Values)
+		if message.Value != nil {
+			value = fmt.Sprint(*message.Value)
+		}
+		if !all && value == b.published[i] {
+			continue
+		}
+		payload, _ := json.Marshal(message)
+		b.client.Publish(p.Topic, p.QoS, p.Retained, payload)
+		b.published[i] = value
+	}
+}
+
+// message reads the current value of the publication.
+func (b *MQTTBridge) message(p MQTTPublication) (message MQTTMessage, err error) {
+	message.Time = time.Now()
+	if p.Tag != "" {
+		tag, ok := b.tags.Tag(p.Tag)
+		if !ok {
+			return message, fmt.Errorf("unknown tag %s", p.Tag)
+		}
+		value, err := b.tags.Get(p.Tag)
+		message.Tag, message.Value, message.Units = p.Tag, &value, tag.Units
+		return message, err
+	}
+	message.Slave, message.Table, message.Address = &p.Slave, &p.Table, &p.Address
+	message.Values, err = b.server.ReadTable(p.Slave, p.Table, p.Address, max(int(p.Quantity), 1))
+	return
+}
+
+// execute writes the payload of a command message.
+func (b *MQTTBridge) execute(command MQTTCommand, payload []byte) error {
+	var message MQTTMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return err
+	}
+	slave, table, address, values := command.Slave, command.Table, command.Address, message.Values
+	if command.Tag != "" {
+		tag, ok := b.tags.Tag(command.Tag)
+		if !ok || message.Value == nil {
+			return fmt.Errorf("tag %s: unknown or no value", command.Tag)
+		}
+		registers, err := EncodeValue((*message.Value-tag.Offset)/tag.scale(), tag.Type, tag.ByteOrder)
+		if err != nil {
+			return err
+		}
+		slave, table, address, values = tag.Slave, tag.Table, tag.Address, registers
+	} else if values == nil && message.Value != nil {
+		value := *message.Value
+		if value != math.Trunc(value) || value < 0 || value > math.MaxUint16 {
+			return fmt.Errorf("value %v out of register range", value)
+		}
+		values = []uint16{uint16(value)}
+	}
+	if len(values) == 0 {
+		return errors.New("no value")
+	}
+	if table == TableCoils && slices.ContainsFunc(values, func(value uint16) bool { return value > 1 }) {
+		return IllegalDataValue
+	}
+	return b.server.WriteChecked(slave, table, address, values)
+}
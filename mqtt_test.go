@@ -0,0 +1,275 @@
+package modbusserver
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// mqttBroker is a broker stand-in serving one client at a time. It acknowledges connections,
+// subscriptions and QoS 1 publications, and records the publications.
+type mqttBroker struct {
+	listener      net.Listener
+	mutex         sync.Mutex
+	conn          net.Conn
+	subscriptions []string
+	messages      chan *packets.PublishPacket
+}
+
+func newMQTTBroker(t *testing.T) *mqttBroker {
+	listener, err := net.Listen("tcp", getFreePort())
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	b := &mqttBroker{listener: listener, messages: make(chan *packets.PublishPacket, 64)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.mutex.Lock()
+			b.conn, b.subscriptions = conn, nil
+			b.mutex.Unlock()
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *mqttBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var response packets.ControlPacket
+		switch packet := packet.(type) {
+		case *packets.ConnectPacket:
+			response = packets.NewControlPacket(packets.Connack)
+		case *packets.SubscribePacket:
+			b.mutex.Lock()
+			b.subscriptions = append(b.subscriptions, packet.Topics...)
+			b.mutex.Unlock()
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID, suback.ReturnCodes = packet.MessageID, packet.Qoss
+			response = suback
+		case *packets.PublishPacket:
+			if packet.Qos == 1 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = packet.MessageID
+				response = puback
+			}
+			b.messages <- packet
+		case *packets.PingreqPacket:
+			response = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if response != nil {
+			b.mutex.Lock()
+			response.Write(conn)
+			b.mutex.Unlock()
+		}
+	}
+}
+
+// subscribed waits for the subscription of the topics by the current client.
+func (b *mqttBroker) subscribed(t *testing.T, topics ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mutex.Lock()
+		subscriptions := slices.Clone(b.subscriptions)
+		b.mutex.Unlock()
+		slices.Sort(subscriptions)
+		if slices.Equal(subscriptions, topics) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected subscriptions %v, got %v", topics, subscriptions)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// send publishes a QoS 0 message to the current client.
+func (b *mqttBroker) send(topic string, payload string) {
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName, publish.Payload = topic, []byte(payload)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	publish.Write(b.conn)
+}
+
+// receive returns the next publication of the topic, skipping the others.
+func (b *mqttBroker) receive(t *testing.T, topic string) (*packets.PublishPacket, MQTTMessage) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case packet := <-b.messages:
+			if packet.TopicName != topic {
+				continue
+			}
+			var message MQTTMessage
+			if err := json.Unmarshal(packet.Payload, &message); err != nil {
+				t.Fatalf("topic %s: invalid payload %s: %v", topic, packet.Payload, err)
+			}
+			return packet, message
+		case <-timeout:
+			t.Fatalf("expected a publication to %s", topic)
+		}
+	}
+}
+
+func TestMQTTBridge(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	max := 100
+	s.AddWriteRule(1, WriteRule{Table: TableHoldingRegisters, Address: 0, Quantity: 2, Max: &max})
+	tags := NewTagDatabase(s)
+	tags.Add(Tag{Name: "temperature", Slave: 1, Table: TableHoldingRegisters, Address: 10, Type: TypeUint16, Scale: 0.1, Units: "C"})
+	tags.Set("temperature", 20)
+	broker := newMQTTBroker(t)
+	defer broker.listener.Close()
+
+	bridge, err := NewMQTTBridge(s, tags, MQTTConfig{
+		Broker: "tcp://" + broker.listener.Addr().String(),
+		Publications: []MQTTPublication{
+			{Topic: "plant/temperature", Tag: "temperature", QoS: 1, Retained: true},
+			{Topic: "plant/registers", Slave: 1, Table: TableHoldingRegisters, Address: 0, Quantity: 2},
+		},
+		Commands: []MQTTCommand{
+			{Topic: "plant/temperature/set", Tag: "temperature"},
+			{Topic: "plant/registers/set", Slave: 1, Table: TableHoldingRegisters, Address: 0},
+		},
+		MaxReconnectInterval: Duration(2 * time.Second),
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer bridge.Close()
+
+	packet, message := broker.receive(t, "plant/temperature")
+	if !packet.Retain || packet.Qos != 1 || message.Tag != "temperature" || *message.Value != 20 || message.Units != "C" {
+		t.Errorf("expected retained temperature, got %v, %+v", packet, message)
+	}
+	packet, message = broker.receive(t, "plant/registers")
+	if packet.Retain || packet.Qos != 0 || *message.Address != 0 || !slices.Equal(message.Values, []uint16{0, 0}) {
+		t.Errorf("expected registers, got %v, %+v", packet, message)
+	}
+
+	s.WriteTable(1, TableHoldingRegisters, 1, []uint16{5})
+	if _, message = broker.receive(t, "plant/registers"); !slices.Equal(message.Values, []uint16{0, 5}) {
+		t.Errorf("expected changed registers, got %+v", message)
+	}
+
+	broker.subscribed(t, "plant/registers/set", "plant/temperature/set")
+	broker.send("plant/registers/set", `{"value": 500}`)
+	broker.send("plant/registers/set", `{"values": [7, 8]}`)
+	if _, message = broker.receive(t, "plant/registers"); !slices.Equal(message.Values, []uint16{7, 8}) {
+		t.Errorf("expected written registers without the rejected write, got %+v", message)
+	}
+	broker.send("plant/temperature/set", `{"value": 21.5}`)
+	if _, message = broker.receive(t, "plant/temperature"); *message.Value != 21.5 {
+		t.Errorf("expected written temperature, got %+v", message)
+	}
+
+	// The bridge reconnects, subscribes and publishes again.
+	broker.mutex.Lock()
+	broker.conn.Close()
+	broker.mutex.Unlock()
+	if _, message = broker.receive(t, "plant/registers"); !slices.Equal(message.Values, []uint16{7, 8}) {
+		t.Errorf("expected registers after reconnection, got %+v", message)
+	}
+	broker.subscribed(t, "plant/registers/set", "plant/temperature/set")
+	broker.send("plant/registers/set", `{"value": 9}`)
+	if _, message = broker.receive(t, "plant/registers"); !slices.Equal(message.Values, []uint16{9, 8}) {
+		t.Errorf("expected command after reconnection, got %+v", message)
+	}
+}
+
+func TestMQTTBridgeUnchangedTag(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	tags := NewTagDatabase(s)
+	tags.Add(Tag{Name: "temperature", Slave: 1, Table: TableHoldingRegisters, Address: 10, Type: TypeUint16})
+	broker := newMQTTBroker(t)
+	defer broker.listener.Close()
+
+	bridge, err := NewMQTTBridge(s, tags, MQTTConfig{
+		Broker: "tcp://" + broker.listener.Addr().String(),
+		Publications: []MQTTPublication{
+			{Topic: "plant/temperature", Tag: "temperature"},
+			{Topic: "plant/registers", Slave: 1, Table: TableHoldingRegisters, Address: 0, Quantity: 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer bridge.Close()
+	broker.receive(t, "plant/temperature")
+	broker.receive(t, "plant/registers")
+
+	// The tag is checked first, so a republished tag would come before the changed registers.
+	s.WriteTable(1, TableHoldingRegisters, 0, []uint16{3})
+	select {
+	case packet := <-broker.messages:
+		if packet.TopicName != "plant/registers" {
+			t.Errorf("expected only the changed registers published, got %s %s", packet.TopicName, packet.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a publication to plant/registers")
+	}
+}
+
+func TestMQTTConfigValidation(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	tags := NewTagDatabase(s)
+	tags.Add(Tag{Name: "input", Slave: 1, Table: TableInputRegisters, Type: TypeUint16})
+	config := MQTTConfig{
+		Publications: []MQTTPublication{
+			{Topic: "a/#", Slave: 1},
+			{Topic: "b", Tag: "missing"},
+			{Topic: "c", QoS: 2},
+		},
+		Commands: []MQTTCommand{
+			{Topic: "d", Tag: "input"},
+			{Topic: "e", Table: TableDiscreteInputs},
+		},
+	}
+	err := config.Validate(tags)
+	if err == nil || len(err.(interface{ Unwrap() []error }).Unwrap()) != 6 {
+		t.Errorf("expected 6 errors, got %v", err)
+	}
+}
+
+func TestMQTTCommandValues(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	bridge := &MQTTBridge{server: s, tags: NewTagDatabase(s)}
+	command := MQTTCommand{Topic: "plant/registers/set", Slave: 1, Table: TableHoldingRegisters, Address: 0}
+	for _, payload := range []string{`{"value": -1}`, `{"value": 70000}`, `{"value": 1.5}`, `{"value": -0.5}`} {
+		if err := bridge.execute(command, []byte(payload)); err == nil {
+			t.Errorf("%s: expected error, got nil", payload)
+		}
+	}
+	if values, _ := s.ReadTable(1, TableHoldingRegisters, 0, 1); values[0] != 0 {
+		t.Errorf("expected no write, got %v", values)
+	}
+	if err := bridge.execute(command, []byte(`{"value": 65535}`)); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if values, _ := s.ReadTable(1, TableHoldingRegisters, 0, 1); values[0] != 65535 {
+		t.Errorf("expected %v, got %v", 65535, values)
+	}
+}
@@ -0,0 +1,285 @@
+package modbusserver
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// emulatedDrainTimeout limits the time spent sending the queued responses of a closed connection.
+const emulatedDrainTimeout = time.Second
+
+type (
+	// NetworkConditions emulates a bad link on the responses sent over TCP connections.
+	NetworkConditions struct {
+		// Latency delays every response, plus or minus a uniformly random Jitter.
+		Latency Duration `json:"latency,omitempty" yaml:"latency,omitempty"`
+		Jitter  Duration `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+		// Bandwidth limits the sending rate in bytes per second, unlimited if zero.
+		Bandwidth int `json:"bandwidth,omitempty" yaml:"bandwidth,omitempty"`
+		// SplitSize splits responses into TCP writes of at most SplitSize bytes, SplitDelay apart.
+		SplitSize  int      `json:"split_size,omitempty" yaml:"split_size,omitempty"`
+		SplitDelay Duration `json:"split_delay,omitempty" yaml:"split_delay,omitempty"`
+		// ResetProbability is the probability of resetting the connection instead of sending a response.
+		ResetProbability float64 `json:"reset_probability,omitempty" yaml:"reset_probability,omitempty"`
+		// StallProbability is the probability of the connection going silent, keeping the socket open and
+		// dropping responses, for StallDuration (until it is closed if zero).
+		StallProbability float64  `json:"stall_probability,omitempty" yaml:"stall_probability,omitempty"`
+		StallDuration    Duration `json:"stall_duration,omitempty" yaml:"stall_duration,omitempty"`
+	}
+	// ConnectionInfo identifies an open TCP connection of the server.
+	ConnectionInfo struct {
+		Listener string `json:"listener"`
+		Remote   string `json:"remote"`
+	}
+	// emulatedConn sends responses with network conditions through a writer goroutine, so delays don't
+	// hold the request handler. Responses without conditions are written directly.
+	emulatedConn struct {
+		net.Conn
+		server   *Server
+		listener string
+		// writeMutex serialises the writes, and pending counts the queued ones not sent yet.
+		writeMutex sync.Mutex
+		pending    atomic.Int32
+		queue      chan emulatedWrite
+		closeChan  chan struct{}
+		// doneChan is closed when the writer goroutine stops.
+		doneChan  chan struct{}
+		closeOnce sync.Once
+		wg        sync.WaitGroup
+		// stalledUntil is only used by the writer goroutine; a stalled connection stays silent after its
+		// conditions are removed.
+		stalledUntil time.Time
+		stalled      atomic.Bool
+	}
+	emulatedWrite struct {
+		data       []byte
+		conditions NetworkConditions
+		at         time.Time
+	}
+)
+
+// SetListenerConditions sets the network conditions of the connections accepted by the listener, given
+// by its address as returned by ListenerAddresses. Zero conditions remove the emulation. Conditions
+// apply to the following responses, including those of open connections.
+func (s *Server) SetListenerConditions(listener string, conditions NetworkConditions) {
+	s.networkMutex.Lock()
+	defer s.networkMutex.Unlock()
+	setConditions(s.listenerConditions, listener, conditions)
+}
+
+// SetConnectionConditions sets the network conditions of the connection with the remote address (see
+// Connections), overriding the conditions of its listener. Zero conditions remove the override.
+func (s *Server) SetConnectionConditions(remote string, conditions NetworkConditions) {
+	s.networkMutex.Lock()
+	defer s.networkMutex.Unlock()
+	setConditions(s.connectionConditions, remote, conditions)
+}
+
+// ClearNetworkConditions removes all network conditions.
+func (s *Server) ClearNetworkConditions() {
+	s.networkMutex.Lock()
+	defer s.networkMutex.Unlock()
+	clear(s.listenerConditions)
+	clear(s.connectionConditions)
+}
+
+// ListenerAddresses returns the addresses of the TCP listeners.
+func (s *Server) ListenerAddresses() (addresses []string) {
+	for _, listen := range s.listeners {
+		addresses = append(addresses, listen.Addr().String())
+	}
+	return
+}
+
+// Connections returns the open TCP connections.
+func (s *Server) Connections() []ConnectionInfo {
+	s.networkMutex.RLock()
+	defer s.networkMutex.RUnlock()
+	connections := make([]ConnectionInfo, 0, len(s.connections))
+	for conn := range s.connections {
+		connections = append(connections, ConnectionInfo{Listener: conn.listener, Remote: conn.RemoteAddr().String()})
+	}
+	slices.SortFunc(connections, func(a, b ConnectionInfo) int {
+		return cmp.Or(cmp.Compare(a.Listener, b.Listener), cmp.Compare(a.Remote, b.Remote))
+	})
+	return connections
+}
+
+func setConditions(conditions map[string]NetworkConditions, key string, current NetworkConditions) {
+	if current == (NetworkConditions{}) {
+		delete(conditions, key)
+		return
+	}
+	conditions[key] = current
+}
+
+// emulateNetwork wraps a connection accepted by the listener.
+func (s *Server) emulateNetwork(listen net.Listener, conn net.Conn) net.Conn {
+	emulated := &emulatedConn{
+		Conn:      conn,
+		server:    s,
+		listener:  listen.Addr().String(),
+		queue:     make(chan emulatedWrite, 64),
+		closeChan: make(chan struct{}),
+		doneChan:  make(chan struct{}),
+	}
+	s.networkMutex.Lock()
+	s.connections[emulated] = struct{}{}
+	s.networkMutex.Unlock()
+	s.metrics.connections.WithLabelValues(emulated.listener).Inc()
+	s.emit(Event{Type: EventConnect, Listener: emulated.listener, Remote: conn.RemoteAddr().String()})
+	emulated.wg.Add(1)
+	go emulated.writeLoop()
+	return emulated
+}
+
+func (s *Server) networkConditions(listener string, remote string) NetworkConditions {
+	s.networkMutex.RLock()
+	defer s.networkMutex.RUnlock()
+	if conditions, ok := s.connectionConditions[remote]; ok {
+		return conditions
+	}
+	return s.listenerConditions[listener]
+}
+
+// Write writes the data directly without network conditions, or queues it with the current ones. Errors
+// of the connection on queued writes are reported by the following reads.
+func (c *emulatedConn) Write(data []byte) (int, error) {
+	conditions := c.server.networkConditions(c.listener, c.RemoteAddr().String())
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	// The queued responses are sent first to keep the order.
+	if conditions == (NetworkConditions{}) && c.pending.Load() == 0 && !c.stalled.Load() {
+		return c.Conn.Write(data)
+	}
+	c.pending.Add(1)
+	select {
+	case <-c.doneChan:
+		c.pending.Add(-1)
+		return 0, net.ErrClosed
+	case c.queue <- emulatedWrite{data: slices.Clone(data), conditions: conditions, at: time.Now()}:
+		return len(data), nil
+	}
+}
+
+// Close sends the queued responses without delay, for at most emulatedDrainTimeout, and closes the
+// connection.
+func (c *emulatedConn) Close() (err error) {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		c.Conn.SetWriteDeadline(time.Now().Add(emulatedDrainTimeout))
+		c.wg.Wait()
+		err = c.Conn.Close()
+		c.server.networkMutex.Lock()
+		delete(c.server.connections, c)
+		c.server.networkMutex.Unlock()
+		c.server.metrics.connections.WithLabelValues(c.listener).Dec()
+		c.server.captureClose(c.listener, c.RemoteAddr(), c.LocalAddr())
+		c.server.emit(Event{Type: EventDisconnect, Listener: c.listener, Remote: c.RemoteAddr().String()})
+	})
+	return
+}
+
+// writeLoop sends the queued writes until the connection is closed and the queue is empty.
+func (c *emulatedConn) writeLoop() {
+	defer c.wg.Done()
+	defer close(c.doneChan)
+	var deadline time.Time
+	for {
+		var write emulatedWrite
+		select {
+		case write = <-c.queue:
+		case <-c.closeChan:
+			select {
+			case write = <-c.queue:
+			default:
+				return
+			}
+		}
+		err := c.send(write, &deadline)
+		c.pending.Add(-1)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send writes the data with its network conditions. It returns an error if the connection is reset or
+// broken.
+func (c *emulatedConn) send(write emulatedWrite, deadline *time.Time) error {
+	conditions := write.conditions
+	if conditions.ResetProbability > 0 && rand.Float64() < conditions.ResetProbability {
+		c.reset()
+		return net.ErrClosed
+	}
+	if conditions.StallProbability > 0 && !c.stalled.Load() && rand.Float64() < conditions.StallProbability {
+		c.stalled.Store(true)
+		c.stalledUntil = time.Time{}
+		if conditions.StallDuration > 0 {
+			c.stalledUntil = time.Now().Add(time.Duration(conditions.StallDuration))
+		}
+	}
+	if c.stalled.Load() {
+		if c.stalledUntil.IsZero() || time.Now().Before(c.stalledUntil) {
+			return nil
+		}
+		c.stalled.Store(false)
+	}
+	delay := time.Duration(conditions.Latency)
+	if conditions.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * float64(conditions.Jitter))
+	}
+	// Responses keep their order even if the jitter would reorder them.
+	*deadline = maxTime(*deadline, write.at.Add(max(delay, 0)))
+	c.sleep(time.Until(*deadline))
+	for data := write.data; len(data) != 0; {
+		size := len(data)
+		if conditions.SplitSize > 0 {
+			size = min(size, conditions.SplitSize)
+		}
+		if conditions.Bandwidth > 0 {
+			c.sleep(time.Duration(size) * time.Second / time.Duration(conditions.Bandwidth))
+		}
+		if _, err := c.Conn.Write(data[:size]); err != nil {
+			return err
+		}
+		if data = data[size:]; len(data) != 0 {
+			c.sleep(time.Duration(conditions.SplitDelay))
+		}
+	}
+	*deadline = time.Now()
+	return nil
+}
+
+// sleep waits for the duration, or less if the connection is being closed.
+func (c *emulatedConn) sleep(duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-c.closeChan:
+	case <-timer.C:
+	}
+}
+
+// reset closes the connection with a TCP reset if possible.
+func (c *emulatedConn) reset() {
+	if tcp, ok := c.Conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	c.Conn.Close()
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
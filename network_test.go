@@ -0,0 +1,115 @@
+package modbusserver
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestNetworkConditions(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	connect := func() (*modbus.TCPClientHandler, modbus.Client) {
+		handler := modbus.NewTCPClientHandler(addr)
+		handler.SlaveId = 1
+		handler.Timeout = 300 * time.Millisecond
+		if err := handler.Connect(); err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		return handler, modbus.NewClient(handler)
+	}
+	handler, client := connect()
+	defer handler.Close()
+	listener := s.ListenerAddresses()[0]
+
+	for _, c := range []struct {
+		name       string
+		conditions NetworkConditions
+		minimum    time.Duration
+	}{
+		{"latency", NetworkConditions{Latency: Duration(100 * time.Millisecond), Jitter: Duration(10 * time.Millisecond)}, 90 * time.Millisecond},
+		{"split", NetworkConditions{SplitSize: 2, SplitDelay: Duration(5 * time.Millisecond)}, 14 * 5 * time.Millisecond},
+		{"bandwidth", NetworkConditions{Bandwidth: 200}, 29 * time.Second / 200},
+	} {
+		s.SetListenerConditions(listener, c.conditions)
+		start := time.Now()
+		if results, err := client.ReadHoldingRegisters(0, 10); err != nil || len(results) != 20 {
+			t.Errorf("%s: expected 20 bytes, got %v, %v", c.name, results, err)
+		}
+		if elapsed := time.Since(start); elapsed < c.minimum {
+			t.Errorf("%s: expected at least %v, got %v", c.name, c.minimum, elapsed)
+		}
+	}
+	s.SetListenerConditions(listener, NetworkConditions{})
+
+	connections := s.Connections()
+	if len(connections) != 1 || connections[0].Listener != listener {
+		t.Fatalf("expected 1 connection, got %v", connections)
+	}
+	s.SetConnectionConditions(connections[0].Remote, NetworkConditions{StallProbability: 1})
+	if _, err := client.ReadCoils(0, 1); err == nil {
+		t.Errorf("expected timeout on stalled connection, got nil")
+	}
+	s.ClearNetworkConditions()
+	if _, err := client.ReadCoils(0, 1); err == nil {
+		t.Errorf("expected stalled connection to stay silent, got nil")
+	}
+	handler.Close()
+
+	handler, client = connect()
+	defer handler.Close()
+	if _, err := client.ReadCoils(0, 1); err != nil {
+		t.Errorf("expected nil on new connection, got %v", err)
+	}
+	s.SetListenerConditions(listener, NetworkConditions{ResetProbability: 1})
+	if _, err := client.ReadCoils(0, 1); err == nil {
+		t.Errorf("expected reset connection error, got nil")
+	}
+}
+
+func TestEmulatedConnWrites(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	listen, err := net.Listen("tcp", getFreePort())
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer listen.Close()
+	client, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer client.Close()
+	accepted, err := listen.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept, got %v\n", err)
+	}
+	conn := s.emulateNetwork(listen, accepted)
+
+	// A queued response is sent when the connection is closed, without waiting for its latency.
+	s.SetListenerConditions(listen.Addr().String(), NetworkConditions{Latency: Duration(time.Hour)})
+	if _, err = conn.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	conn.Close()
+	received := make([]byte, 3)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = io.ReadFull(client, received); err != nil || !slices.Equal(received, []byte{1, 2, 3}) {
+		t.Errorf("expected queued response, got %v, %v", received, err)
+	}
+
+	// Without conditions, the errors of the connection are returned by Write.
+	s.ClearNetworkConditions()
+	if _, err = conn.Write([]byte{4}); err == nil {
+		t.Errorf("expected error on closed connection, got nil")
+	}
+}
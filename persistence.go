@@ -0,0 +1,362 @@
+package modbusserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+type (
+	// Snapshot is a copy of the memory and the response state of all server slaves.
+	Snapshot struct {
+		Time                  time.Time
+		Slaves                map[uint8]SlaveData
+		SlavesStoppedResponse []uint8
+	}
+	// writeLog is an append only file of writes made since the last snapshot.
+	writeLog struct {
+		file *os.File
+	}
+)
+
+// writeLogHeaderSize is the size of the record CRC32 and the payload length.
+const writeLogHeaderSize = 8
+
+// Snapshot returns a deep copy of the memory and the response state of all slaves.
+func (s *Server) Snapshot() Snapshot {
+	s.memoryMutex.RLock()
+	defer s.memoryMutex.RUnlock()
+	return s.snapshot()
+}
+
+func (s *Server) snapshot() Snapshot {
+	snapshot := Snapshot{
+		Time:                  time.Now(),
+		Slaves:                make(map[uint8]SlaveData, len(s.Slaves)),
+		SlavesStoppedResponse: slices.Clone(s.SlavesStoppedResponse),
+	}
+	for id, slave := range s.Slaves {
+		snapshot.Slaves[id] = SlaveData{
+			Coils:            slices.Clone(slave.Coils),
+			DiscreteInputs:   slices.Clone(slave.DiscreteInputs),
+			HoldingRegisters: slices.Clone(slave.HoldingRegisters),
+			InputRegisters:   slices.Clone(slave.InputRegisters),
+		}
+	}
+	return snapshot
+}
+
+// Restore replaces the memory and the response state of all slaves with the snapshot.
+// Slaves missing in the snapshot are removed. Slave aliases share the restored memory of their targets.
+func (s *Server) Restore(snapshot Snapshot) (err error) {
+	for id, slave := range snapshot.Slaves {
+		if len(slave.Coils) != 65536 || len(slave.DiscreteInputs) != 65536 || len(slave.HoldingRegisters) != 65536 || len(slave.InputRegisters) != 65536 {
+			return fmt.Errorf("snapshot of slave %d has invalid memory size", id)
+		}
+	}
+	restored := make(map[uint8]SlaveData, len(snapshot.Slaves))
+	for id, slave := range snapshot.Slaves {
+		restored[id] = SlaveData{
+			Coils:            slices.Clone(slave.Coils),
+			DiscreteInputs:   slices.Clone(slave.DiscreteInputs),
+			HoldingRegisters: slices.Clone(slave.HoldingRegisters),
+			InputRegisters:   slices.Clone(slave.InputRegisters),
+		}
+	}
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	s.Slaves = restored
+	s.SlavesStoppedResponse = slices.Clone(snapshot.SlavesStoppedResponse)
+	s.relinkAliases()
+	return
+}
+
+// SaveSnapshot atomically writes a snapshot of all slaves to the file. The memory is copied under the lock
+// and the file is written after releasing it. If a write log is open, the writes contained in the snapshot
+// are discarded from it.
+func (s *Server) SaveSnapshot(path string) (err error) {
+	s.memoryMutex.RLock()
+	snapshot := s.snapshot()
+	log := s.writeLog
+	var logged int64
+	if log != nil {
+		logged, err = log.size()
+	}
+	s.memoryMutex.RUnlock()
+	if err != nil {
+		return
+	}
+	temporary, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			temporary.Close()
+			os.Remove(temporary.Name())
+		}
+	}()
+	writer := bufio.NewWriter(temporary)
+	if err = gob.NewEncoder(writer).Encode(snapshot); err != nil {
+		return
+	}
+	if err = writer.Flush(); err != nil {
+		return
+	}
+	if err = temporary.Sync(); err != nil {
+		return
+	}
+	if err = temporary.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(temporary.Name(), path); err != nil {
+		return
+	}
+	if log == nil {
+		return
+	}
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	if s.writeLog == log {
+		err = log.discard(logged)
+	}
+	return
+}
+
+// LoadSnapshot restores all slaves from a snapshot file written by SaveSnapshot.
+func (s *Server) LoadSnapshot(path string) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	var snapshot Snapshot
+	if err = gob.NewDecoder(bufio.NewReader(file)).Decode(&snapshot); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return s.Restore(snapshot)
+}
+
+// StartPeriodicSnapshots saves a snapshot to the file every interval until StopPeriodicSnapshots or Close is called.
+func (s *Server) StartPeriodicSnapshots(path string, interval time.Duration) {
+	s.snapshotsMutex.Lock()
+	defer s.snapshotsMutex.Unlock()
+	s.stopPeriodicSnapshots()
+	stop := make(chan struct{})
+	s.snapshotsStopChan = stop
+	s.snapshotsWG.Add(1)
+	go func() {
+		defer s.snapshotsWG.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := s.SaveSnapshot(path); err != nil {
+					s.logger.Error(fmt.Sprintf("Server: unable to save snapshot %s: %s", path, err.Error()))
+				}
+			}
+		}
+	}()
+}
+
+// StopPeriodicSnapshots stops saving snapshots started by StartPeriodicSnapshots.
+func (s *Server) StopPeriodicSnapshots() {
+	s.snapshotsMutex.Lock()
+	defer s.snapshotsMutex.Unlock()
+	s.stopPeriodicSnapshots()
+}
+
+func (s *Server) stopPeriodicSnapshots() {
+	if s.snapshotsStopChan == nil {
+		return
+	}
+	close(s.snapshotsStopChan)
+	s.snapshotsWG.Wait()
+	s.snapshotsStopChan = nil
+}
+
+// OpenWriteLog replays the writes stored in the log file into slave memory and then appends every
+// following write of coils and holding registers to it. Each write is synced to disk before it is applied and acknowledged to the
+// master. A torn record at the end of the file, left by a crash, is discarded.
+//
+// Load the last snapshot before opening the write log.
+func (s *Server) OpenWriteLog(path string) (err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return
+	}
+	log := &writeLog{file: file}
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	if s.writeLog != nil {
+		file.Close()
+		return errors.New("write log is already open")
+	}
+	valid, err := log.replay(s.applyLoggedWrite)
+	if err == nil {
+		err = log.truncate(valid)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	s.writeLog = log
+	return
+}
+
+// CloseWriteLog stops logging writes and closes the log file.
+func (s *Server) CloseWriteLog() (err error) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	if s.writeLog == nil {
+		return
+	}
+	err = s.writeLog.file.Close()
+	s.writeLog = nil
+	return
+}
+
+func (s *Server) applyLoggedWrite(event WriteEvent) error {
+	if event.Table > TableInputRegisters || int(event.Address)+len(event.Values) > 65536 {
+		return fmt.Errorf("invalid logged write to %s %d", event.Table, event.Address)
+	}
+	s.initSlave(event.Slave)
+	s.applyWrite(event)
+	return nil
+}
+
+// append writes the records of the events with a single write and syncs the file. Only coils and holding
+// registers, the tables masters can write, are logged; input tables are restored from snapshots.
+func (l *writeLog) append(events ...WriteEvent) (err error) {
+	var records []byte
+	for _, event := range events {
+		if event.Table != TableCoils && event.Table != TableHoldingRegisters {
+			continue
+		}
+		payload := make([]byte, 8+2*len(event.Values))
+		payload[0] = event.Slave
+		payload[1] = byte(event.Table)
+		binary.BigEndian.PutUint16(payload[2:4], event.Address)
+		binary.BigEndian.PutUint32(payload[4:8], uint32(len(event.Values)))
+		copy(payload[8:], Uint16ToBytes(event.Values))
+		header := make([]byte, writeLogHeaderSize)
+		binary.BigEndian.PutUint32(header[0:4], crc32.ChecksumIEEE(payload))
+		binary.BigEndian.PutUint32(header[4:8], uint32(len(payload)))
+		records = append(append(records, header...), payload...)
+	}
+	if len(records) == 0 {
+		return
+	}
+	if _, err = l.file.Write(records); err != nil {
+		return
+	}
+	return l.file.Sync()
+}
+
+// replay applies the records of the log and returns the size of its valid part.
+func (l *writeLog) replay(apply func(WriteEvent) error) (valid int64, err error) {
+	if _, err = l.file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	reader := bufio.NewReader(l.file)
+	header := make([]byte, writeLogHeaderSize)
+	for {
+		if _, err = io.ReadFull(reader, header); err != nil {
+			break
+		}
+		size := binary.BigEndian.Uint32(header[4:8])
+		if size > 8+2*65536 {
+			break
+		}
+		payload := make([]byte, size)
+		if _, err = io.ReadFull(reader, payload); err != nil {
+			break
+		}
+		if len(payload) < 8 || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[0:4]) ||
+			len(payload) != 8+2*int(binary.BigEndian.Uint32(payload[4:8])) {
+			break
+		}
+		event := WriteEvent{
+			Slave:   payload[0],
+			Table:   Table(payload[1]),
+			Address: binary.BigEndian.Uint16(payload[2:4]),
+			Values:  BytesToUint16(payload[8:]),
+		}
+		if err = apply(event); err != nil {
+			return
+		}
+		valid += int64(writeLogHeaderSize + len(payload))
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return
+}
+
+// size returns the size of the records written to the log.
+func (l *writeLog) size() (int64, error) {
+	return l.file.Seek(0, io.SeekCurrent)
+}
+
+// discard removes the first size bytes of records from the log. Records appended after them are moved
+// to a new file which atomically replaces the log, so a crash never leaves the older records after them.
+func (l *writeLog) discard(size int64) (err error) {
+	end, err := l.size()
+	if err != nil {
+		return
+	}
+	if end == size {
+		return l.truncate(0)
+	}
+	kept := make([]byte, end-size)
+	if _, err = l.file.ReadAt(kept, size); err != nil {
+		return
+	}
+	path := l.file.Name()
+	temporary, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			temporary.Close()
+			os.Remove(temporary.Name())
+		}
+	}()
+	if err = temporary.Chmod(0o644); err != nil {
+		return
+	}
+	if _, err = temporary.Write(kept); err != nil {
+		return
+	}
+	if err = temporary.Sync(); err != nil {
+		return
+	}
+	if err = os.Rename(temporary.Name(), path); err != nil {
+		return
+	}
+	l.file.Close()
+	l.file = temporary
+	return
+}
+
+func (l *writeLog) truncate(size int64) (err error) {
+	if err = l.file.Truncate(size); err != nil {
+		return
+	}
+	if _, err = l.file.Seek(size, io.SeekStart); err != nil {
+		return
+	}
+	return l.file.Sync()
+}
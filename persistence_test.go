@@ -0,0 +1,142 @@
+package modbusserver
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slaves.snapshot")
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.InitSlave(2)
+	s.Slaves[1].Coils[1] = 1
+	s.Slaves[1].DiscreteInputs[2] = 1
+	s.Slaves[1].HoldingRegisters[3] = 3
+	s.Slaves[2].InputRegisters[4] = 4
+	s.SlaveStopResponse(2)
+	if err := s.SaveSnapshot(path); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	restored := NewServer(slog.Logger{})
+	restored.InitSlave(3)
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if _, ok := restored.Slaves[3]; ok {
+		t.Errorf("expected slave 3 to be removed")
+	}
+	if restored.Slaves[1].Coils[1] != 1 || restored.Slaves[1].DiscreteInputs[2] != 1 ||
+		restored.Slaves[1].HoldingRegisters[3] != 3 || restored.Slaves[2].InputRegisters[4] != 4 {
+		t.Errorf("restored memory differs from the saved one")
+	}
+	if !slices.Equal(restored.SlavesStoppedResponse, []uint8{2}) {
+		t.Errorf("expected %v, got %v", []uint8{2}, restored.SlavesStoppedResponse)
+	}
+}
+
+func TestWriteLogReplay(t *testing.T) {
+	directory := t.TempDir()
+	snapshotPath := filepath.Join(directory, "slaves.snapshot")
+	logPath := filepath.Join(directory, "slaves.log")
+
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	if err := s.OpenWriteLog(logPath); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	s.WriteTable(1, TableHoldingRegisters, 0, []uint16{1, 2})
+	if err := s.SaveSnapshot(snapshotPath); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	var frame TCPFrame
+	frame.Device = 1
+	SetDataWithRegisterAndNumberAndValues(&frame, 1, 2, []uint16{20, 30})
+	if _, exception := WriteHoldingRegisters(s, &frame); exception != &Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	SetDataWithRegisterAndNumberAndBytes(&frame, 5, 3, []byte{0x05})
+	WriteMultipleCoils(s, &frame)
+	s.CloseWriteLog()
+
+	// Simulate a crash in the middle of a record.
+	file, _ := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0)
+	file.Write([]byte{0, 1, 2})
+	file.Close()
+
+	restored := NewServer(slog.Logger{})
+	if err := restored.LoadSnapshot(snapshotPath); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := restored.OpenWriteLog(logPath); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer restored.CloseWriteLog()
+	if got := restored.Slaves[1].HoldingRegisters[0:3]; !slices.Equal(got, []uint16{1, 20, 30}) {
+		t.Errorf("expected %v, got %v", []uint16{1, 20, 30}, got)
+	}
+	if got := restored.Slaves[1].Coils[5:8]; !slices.Equal(got, []byte{1, 0, 1}) {
+		t.Errorf("expected %v, got %v", []byte{1, 0, 1}, got)
+	}
+	if info, _ := os.Stat(logPath); info.Size()%2 != 0 {
+		t.Errorf("expected torn record to be discarded, log size %d", info.Size())
+	}
+}
+
+func TestWriteLogDiscard(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "slaves.log"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	log := &writeLog{file: file}
+	defer func() { log.file.Close() }()
+	log.append(WriteEvent{Slave: 1, Table: TableHoldingRegisters, Address: 0, Values: []uint16{1}})
+	size, _ := log.size()
+	log.append(WriteEvent{Slave: 1, Table: TableHoldingRegisters, Address: 1, Values: []uint16{2}})
+	if err := log.discard(size); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// Only the write made after the snapshot copy is kept, and new writes follow it.
+	log.append(WriteEvent{Slave: 1, Table: TableHoldingRegisters, Address: 2, Values: []uint16{3}})
+	var addresses []uint16
+	if _, err := log.replay(func(event WriteEvent) error {
+		addresses = append(addresses, event.Address)
+		return nil
+	}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !slices.Equal(addresses, []uint16{1, 2}) {
+		t.Errorf("expected %v, got %v", []uint16{1, 2}, addresses)
+	}
+}
+
+func TestPeriodicSnapshotsConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slaves.snapshot")
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.StartPeriodicSnapshots(path, time.Millisecond)
+		}()
+		go func() {
+			defer wg.Done()
+			s.StopPeriodicSnapshots()
+		}()
+	}
+	wg.Wait()
+	s.StopPeriodicSnapshots()
+	if s.snapshotsStopChan != nil {
+		t.Error("expected periodic snapshots to be stopped")
+	}
+}
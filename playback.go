@@ -0,0 +1,360 @@
+package modbusserver
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// PlaybackColumn maps a CSV column to a typed value at a slave address. The raw value written is
+	// (column value - Offset) / Scale, as for tags.
+	PlaybackColumn struct {
+		Column    string    `json:"column" yaml:"column"`
+		Slave     uint8     `json:"slave" yaml:"slave"`
+		Table     Table     `json:"table" yaml:"table"`
+		Address   uint16    `json:"address" yaml:"address"`
+		Type      DataType  `json:"type" yaml:"type"`
+		ByteOrder ByteOrder `json:"byte_order,omitempty" yaml:"byte_order,omitempty"`
+		Scale     float64   `json:"scale,omitempty" yaml:"scale,omitempty"`
+		Offset    float64   `json:"offset,omitempty" yaml:"offset,omitempty"`
+	}
+	// PlaybackConfig describes how recorded CSV data is replayed.
+	PlaybackConfig struct {
+		// TimeColumn is the timestamp column, the first column if empty.
+		TimeColumn string `json:"time_column,omitempty" yaml:"time_column,omitempty"`
+		// TimeFormat is the time.Parse layout of timestamps. If empty, timestamps are seconds or RFC 3339.
+		TimeFormat string           `json:"time_format,omitempty" yaml:"time_format,omitempty"`
+		Columns    []PlaybackColumn `json:"columns" yaml:"columns"`
+		// Speed multiplies the recorded time, 1 if zero.
+		Speed float64 `json:"speed,omitempty" yaml:"speed,omitempty"`
+		Loop  bool    `json:"loop,omitempty" yaml:"loop,omitempty"`
+		// Interpolate writes linearly interpolated values between samples instead of holding the last sample.
+		Interpolate bool `json:"interpolate,omitempty" yaml:"interpolate,omitempty"`
+		// Resolution is the update interval, 100 ms if zero.
+		Resolution Duration `json:"resolution,omitempty" yaml:"resolution,omitempty"`
+	}
+	// Playback replays time series into slave memory.
+	Playback struct {
+		server   *Server
+		config   PlaybackConfig
+		times    []time.Duration
+		samples  [][]float64
+		mutex    sync.Mutex
+		position time.Duration
+		paused   bool
+		finished bool
+		stopChan chan struct{}
+		doneChan chan struct{}
+		wg       sync.WaitGroup
+	}
+)
+
+// LoadPlaybackFile reads a CSV file for playback with LoadPlayback.
+func LoadPlaybackFile(s *Server, path string, config PlaybackConfig) (*Playback, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	playback, err := LoadPlayback(s, file, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return playback, nil
+}
+
+// LoadPlayback reads CSV with a header row, a timestamp column and one column per value. Empty cells
+// repeat the previous value of the column. The playback is paused at the first sample; call Start.
+func LoadPlayback(s *Server, reader io.Reader, config PlaybackConfig) (*Playback, error) {
+	if config.Speed == 0 {
+		config.Speed = 1
+	}
+	if config.Speed < 0 {
+		return nil, fmt.Errorf("invalid speed %v", config.Speed)
+	}
+	if config.Resolution <= 0 {
+		config.Resolution = Duration(100 * time.Millisecond)
+	}
+	if len(config.Columns) == 0 {
+		return nil, errors.New("no columns mapped")
+	}
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("no samples")
+	}
+	header := make(map[string]int)
+	for i, column := range records[0] {
+		header[strings.TrimSpace(column)] = i
+	}
+	timeIndex := 0
+	if config.TimeColumn != "" {
+		var ok bool
+		if timeIndex, ok = header[config.TimeColumn]; !ok {
+			return nil, fmt.Errorf("missing time column %s", config.TimeColumn)
+		}
+	}
+	indexes := make([]int, len(config.Columns))
+	for i, column := range config.Columns {
+		var ok bool
+		if indexes[i], ok = header[column.Column]; !ok {
+			return nil, fmt.Errorf("missing column %s", column.Column)
+		}
+		if err = column.Type.Validate(); err == nil {
+			if err = column.ByteOrder.Validate(); err == nil {
+				err = checkBitType(column.Table, column.Type)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", column.Column, err)
+		}
+	}
+	playback := &Playback{server: s, config: config, paused: true}
+	var first time.Time
+	previous := make([]float64, len(indexes))
+	for i := range previous {
+		previous[i] = math.NaN()
+	}
+	for line, record := range records[1:] {
+		if timeIndex >= len(record) {
+			return nil, fmt.Errorf("line %d: missing timestamp", line+2)
+		}
+		timestamp, err := parseTimestamp(strings.TrimSpace(record[timeIndex]), config.TimeFormat)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+2, err)
+		}
+		if line == 0 {
+			first = timestamp
+		}
+		offset := timestamp.Sub(first)
+		if line != 0 && offset < playback.times[line-1] {
+			return nil, fmt.Errorf("line %d: timestamps must not decrease", line+2)
+		}
+		sample := make([]float64, len(indexes))
+		for i, index := range indexes {
+			sample[i] = previous[i]
+			if index < len(record) && strings.TrimSpace(record[index]) != "" {
+				if sample[i], err = strconv.ParseFloat(strings.TrimSpace(record[index]), 64); err != nil {
+					return nil, fmt.Errorf("line %d, column %s: %w", line+2, config.Columns[i].Column, err)
+				}
+			}
+		}
+		previous = sample
+		playback.times = append(playback.times, offset)
+		playback.samples = append(playback.samples, sample)
+	}
+	return playback, nil
+}
+
+// parseTimestamp parses seconds (relative or Unix) or a time in the layout, RFC 3339 by default.
+func parseTimestamp(field string, layout string) (time.Time, error) {
+	if layout == "" {
+		if seconds, err := strconv.ParseFloat(field, 64); err == nil {
+			return time.Unix(0, 0).Add(time.Duration(seconds * float64(time.Second))), nil
+		}
+		layout = time.RFC3339Nano
+	}
+	return time.Parse(layout, field)
+}
+
+// Duration returns the recorded duration of the time series.
+func (p *Playback) Duration() time.Duration {
+	return p.times[len(p.times)-1]
+}
+
+// Start writes the values at the current position and starts (or resumes) the playback. A finished
+// playback restarts from the beginning.
+func (p *Playback) Start() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.finished {
+		p.position = 0
+		p.finished = false
+		p.doneChan = make(chan struct{})
+	}
+	if p.stopChan == nil {
+		p.stopChan = make(chan struct{})
+		if p.doneChan == nil {
+			p.doneChan = make(chan struct{})
+		}
+		p.wg.Add(1)
+		go p.run()
+	}
+	p.paused = false
+	p.write()
+}
+
+// Pause stops advancing the playback, leaving the current values in memory.
+func (p *Playback) Pause() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.paused = true
+}
+
+// Paused reports whether the playback is paused.
+func (p *Playback) Paused() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.paused
+}
+
+// Seek moves the playback to the position in recorded time and writes the values there.
+func (p *Playback) Seek(position time.Duration) (err error) {
+	if position < 0 || position > p.Duration() {
+		return fmt.Errorf("position %v out of range [0, %v]", position, p.Duration())
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.position = position
+	if p.finished {
+		p.finished = false
+		p.doneChan = make(chan struct{})
+	}
+	p.write()
+	return
+}
+
+// Position returns the current position in recorded time.
+func (p *Playback) Position() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.position
+}
+
+// SetSpeed changes the playback speed.
+func (p *Playback) SetSpeed(speed float64) (err error) {
+	if speed <= 0 {
+		return fmt.Errorf("invalid speed %v", speed)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.config.Speed = speed
+	return
+}
+
+// Done returns a channel closed when a playback without looping reaches the end.
+func (p *Playback) Done() <-chan struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.doneChan == nil {
+		p.doneChan = make(chan struct{})
+	}
+	return p.doneChan
+}
+
+// Close stops the playback.
+func (p *Playback) Close() {
+	p.mutex.Lock()
+	stop := p.stopChan
+	p.stopChan = nil
+	p.mutex.Unlock()
+	if stop != nil {
+		close(stop)
+		p.wg.Wait()
+	}
+}
+
+func (p *Playback) run() {
+	defer p.wg.Done()
+	p.mutex.Lock()
+	stop := p.stopChan
+	p.mutex.Unlock()
+	ticker := time.NewTicker(time.Duration(p.config.Resolution))
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			p.mutex.Lock()
+			if !p.paused && !p.finished {
+				p.advance(time.Duration(float64(now.Sub(last)) * p.config.Speed))
+				p.write()
+			}
+			p.mutex.Unlock()
+			last = now
+		}
+	}
+}
+
+// advance moves the position forward, looping or finishing at the end. The playback must be locked.
+func (p *Playback) advance(elapsed time.Duration) {
+	p.position += elapsed
+	if p.position < p.Duration() {
+		return
+	}
+	if p.config.Loop && p.Duration() > 0 {
+		p.position %= p.Duration()
+		return
+	}
+	p.position = p.Duration()
+	p.finished = true
+	if p.doneChan == nil {
+		p.doneChan = make(chan struct{})
+	}
+	close(p.doneChan)
+}
+
+// valuesAt returns the column values at the position in recorded time.
+func (p *Playback) valuesAt(position time.Duration) []float64 {
+	next := sort.Search(len(p.times), func(i int) bool { return p.times[i] > position })
+	if next == 0 {
+		return p.samples[0]
+	}
+	current := p.samples[next-1]
+	if !p.config.Interpolate || next == len(p.times) {
+		return current
+	}
+	following := p.samples[next]
+	ratio := float64(position-p.times[next-1]) / float64(p.times[next]-p.times[next-1])
+	values := slices.Clone(current)
+	for i, column := range p.config.Columns {
+		if column.Type != TypeBool && !math.IsNaN(current[i]) && !math.IsNaN(following[i]) {
+			values[i] = current[i] + (following[i]-current[i])*ratio
+		}
+	}
+	return values
+}
+
+// write stores the values at the current position. The playback must be locked.
+func (p *Playback) write() {
+	values := p.valuesAt(p.position)
+	var slaves []uint8
+	for _, column := range p.config.Columns {
+		if !slices.Contains(slaves, column.Slave) {
+			slaves = append(slaves, column.Slave)
+		}
+	}
+	err := p.server.Update(slaves, func(tx *Transaction) error {
+		for i, column := range p.config.Columns {
+			if math.IsNaN(values[i]) {
+				continue
+			}
+			scale := column.Scale
+			if scale == 0 {
+				scale = 1
+			}
+			raw := clampValue((values[i]-column.Offset)/scale, column.Type)
+			if err := tx.WriteValue(column.Slave, column.Table, column.Address, column.Type, column.ByteOrder, raw); err != nil {
+				return fmt.Errorf("column %s: %w", column.Column, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.server.logger.Error(fmt.Sprintf("Server: unable to write playback values: %s", err.Error()))
+	}
+}
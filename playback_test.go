@@ -0,0 +1,148 @@
+package modbusserver
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+const playbackCSV = `time,pressure,running
+0,1.0,0
+1,2.0,
+3,4.0,1
+`
+
+func TestPlaybackValues(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	config := PlaybackConfig{
+		Columns: []PlaybackColumn{
+			{Column: "pressure", Slave: 1, Table: TableHoldingRegisters, Address: 0, Type: TypeUint16, Scale: 0.1},
+			{Column: "running", Slave: 1, Table: TableCoils, Address: 0, Type: TypeBool},
+		},
+		Interpolate: true,
+	}
+	playback, err := LoadPlayback(s, strings.NewReader(playbackCSV), config)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if playback.Duration() != 3*time.Second {
+		t.Errorf("expected %v, got %v", 3*time.Second, playback.Duration())
+	}
+
+	if err = playback.Seek(2 * time.Second); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if got := s.Slaves[1].HoldingRegisters[0]; got != 30 {
+		t.Errorf("expected interpolated %v, got %v", 30, got)
+	}
+	if got := s.Slaves[1].Coils[0]; got != 0 {
+		t.Errorf("expected repeated %v, got %v", 0, got)
+	}
+
+	playback.config.Interpolate = false
+	playback.Seek(2 * time.Second)
+	if got := s.Slaves[1].HoldingRegisters[0]; got != 20 {
+		t.Errorf("expected held %v, got %v", 20, got)
+	}
+	playback.Seek(3 * time.Second)
+	if got := s.Slaves[1].Coils[0]; got != 1 {
+		t.Errorf("expected %v, got %v", 1, got)
+	}
+
+	if err = playback.Seek(4 * time.Second); err == nil {
+		t.Errorf("expected out of range error, got nil")
+	}
+}
+
+func TestPlaybackRun(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	config := PlaybackConfig{
+		Columns:    []PlaybackColumn{{Column: "pressure", Slave: 1, Table: TableInputRegisters, Address: 5, Type: TypeFloat32}},
+		Speed:      100,
+		Resolution: Duration(time.Millisecond),
+	}
+	playback, err := LoadPlayback(s, strings.NewReader(playbackCSV), config)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer playback.Close()
+	playback.Start()
+	select {
+	case <-playback.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("playback didn't finish")
+	}
+	if value, _ := s.ReadValue(1, TableInputRegisters, 5, TypeFloat32, ""); value != 4 {
+		t.Errorf("expected %v, got %v", 4, value)
+	}
+
+	playback.config.Loop = true
+	playback.Seek(0)
+	time.Sleep(100 * time.Millisecond)
+	playback.Pause()
+	if position := playback.Position(); position >= playback.Duration() {
+		t.Errorf("expected looping position before the end, got %v", position)
+	}
+	select {
+	case <-playback.Done():
+		t.Errorf("expected looping playback not to finish")
+	default:
+	}
+}
+
+func TestLoadPlaybackErrors(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	columns := []PlaybackColumn{{Column: "pressure", Table: TableHoldingRegisters, Type: TypeUint16}}
+	for _, data := range []string{
+		"time,flow\n0,1\n",
+		"time,pressure\n1,1\n0,2\n",
+		"time,pressure\n0,high\n",
+		"time,pressure\n",
+	} {
+		if _, err := LoadPlayback(s, strings.NewReader(data), PlaybackConfig{Columns: columns}); err == nil {
+			t.Errorf("expected error for %q, got nil", data)
+		}
+	}
+	playback, err := LoadPlayback(s, strings.NewReader("stamp,pressure\n2024-01-01T00:00:00Z,1\n2024-01-01T00:01:00Z,2\n"),
+		PlaybackConfig{TimeColumn: "stamp", Columns: columns})
+	if err != nil || playback.Duration() != time.Minute {
+		t.Errorf("expected one minute, got %v, %v", err, playback)
+	}
+}
+
+func TestPlaybackRestart(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	config, err := decodeConfigFile[PlaybackConfig]("playback.yaml", "playback", []byte(`
+columns:
+  - column: pressure
+    slave: 1
+    table: input_registers
+    address: 5
+    type: float32
+speed: 100
+resolution: 1ms
+`))
+	if err != nil || config.Resolution != Duration(time.Millisecond) {
+		t.Fatalf("expected a resolution of 1ms, got %v, %v", config.Resolution, err)
+	}
+	playback, err := LoadPlayback(s, strings.NewReader(playbackCSV), config)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer playback.Close()
+	for run := 0; run < 2; run++ {
+		playback.Start()
+		if run == 1 && playback.Position() != 0 {
+			t.Errorf("expected a finished playback to restart, got position %v", playback.Position())
+		}
+		select {
+		case <-playback.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("run %d: playback didn't finish", run)
+		}
+	}
+}
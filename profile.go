@@ -0,0 +1,325 @@
+package modbusserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type (
+	// DeviceProfile is a reusable template of a slave: its memory map, initial values, identification
+	// objects, write rules and generators. Addresses are relative to the address offset of each instance.
+	//
+	// Initial values and identification objects may contain parameters written as ${name}. Parameters
+	// are given by the instance, default to the profile Parameters, and include the instance slave ID and
+	// name as ${slave} and ${name}.
+	DeviceProfile struct {
+		Name        string `json:"name" yaml:"name"`
+		Description string `json:"description,omitempty" yaml:"description,omitempty"`
+		// Parameters are the default parameter values.
+		Parameters map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+		// Identification maps object names (see ParseObjectID) to values of Read Device Identification.
+		Identification map[string]string `json:"identification,omitempty" yaml:"identification,omitempty"`
+		// Tags is the memory map; the slave of the tags is ignored.
+		Tags       []Tag          `json:"tags,omitempty" yaml:"tags,omitempty"`
+		Values     []ProfileValue `json:"values,omitempty" yaml:"values,omitempty"`
+		WriteRules []WriteRule    `json:"write_rules,omitempty" yaml:"write_rules,omitempty"`
+		// Generators are started when the slave is created; their slave is ignored.
+		Generators []Generator `json:"generators,omitempty" yaml:"generators,omitempty"`
+	}
+	// ProfileValue is an initial value of a profile, at a profile tag (in engineering units) or at an
+	// address. Value is a number; Text is ASCII stored two characters per register, high byte first,
+	// padded with zeros to Length registers.
+	ProfileValue struct {
+		Tag       string    `json:"tag,omitempty" yaml:"tag,omitempty"`
+		Table     Table     `json:"table,omitempty" yaml:"table,omitempty"`
+		Address   uint16    `json:"address,omitempty" yaml:"address,omitempty"`
+		Type      DataType  `json:"type,omitempty" yaml:"type,omitempty"`
+		ByteOrder ByteOrder `json:"byte_order,omitempty" yaml:"byte_order,omitempty"`
+		Value     string    `json:"value,omitempty" yaml:"value,omitempty"`
+		Text      string    `json:"text,omitempty" yaml:"text,omitempty"`
+		Length    uint16    `json:"length,omitempty" yaml:"length,omitempty"`
+	}
+	// DeviceInstance describes a slave created from a profile.
+	DeviceInstance struct {
+		Slave uint8 `json:"slave" yaml:"slave"`
+		// Name prefixes the names of the instance tags and generators as "name.tag", slave<ID> if empty.
+		Name          string            `json:"name,omitempty" yaml:"name,omitempty"`
+		AddressOffset uint16            `json:"address_offset,omitempty" yaml:"address_offset,omitempty"`
+		Parameters    map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	}
+	// deviceSetup is a profile resolved for an instance.
+	deviceSetup struct {
+		identification map[uint8]string
+		tags           []Tag
+		writes         []WriteEvent
+		writeRules     []WriteRule
+		generators     []Generator
+	}
+)
+
+// LoadDeviceProfileFile reads a device profile from a .json or .yaml/.yml file.
+func LoadDeviceProfileFile(path string) (*DeviceProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDeviceProfile(path, data)
+}
+
+// LoadDeviceProfileFS reads a device profile from a file system, for example profiles embedded in the
+// program with embed.FS.
+func LoadDeviceProfileFS(fsys fs.FS, path string) (*DeviceProfile, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDeviceProfile(path, data)
+}
+
+func decodeDeviceProfile(path string, data []byte) (*DeviceProfile, error) {
+	profile, err := decodeConfigFile[DeviceProfile](path, "profile", data)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// InitSlaveFromProfile creates the slave of the instance from the profile. Profile tags are added to
+// the tag database and generators to the scheduler, either may be nil to skip them. All errors of the
+// profile are reported together and nothing is created if any is found.
+func (s *Server) InitSlaveFromProfile(profile *DeviceProfile, instance DeviceInstance, tags *TagDatabase, generators *GeneratorScheduler) (err error) {
+	setup, err := profile.resolve(instance)
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", profile.Name, err)
+	}
+	s.memoryMutex.RLock()
+	_, exists := s.Slaves[instance.Slave]
+	s.memoryMutex.RUnlock()
+	if exists {
+		return fmt.Errorf("slave %d already exists", instance.Slave)
+	}
+	if generators != nil {
+		for _, existing := range generators.Generators() {
+			if slices.ContainsFunc(setup.generators, func(generator Generator) bool { return generator.Name == existing.Name }) {
+				return fmt.Errorf("generator %s already exists", existing.Name)
+			}
+		}
+	}
+	if tags != nil {
+		if err = tags.Add(setup.tags...); err != nil {
+			return
+		}
+	}
+	// Everything added is removed if a later step fails.
+	created := false
+	var started []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, name := range started {
+			generators.Remove(name)
+		}
+		if created {
+			s.RemoveSlave(instance.Slave)
+		}
+		if tags != nil {
+			for _, tag := range setup.tags {
+				tags.Remove(tag.Name)
+			}
+		}
+	}()
+
+	s.memoryMutex.Lock()
+	if _, exists = s.Slaves[instance.Slave]; exists {
+		err = fmt.Errorf("slave %d already exists", instance.Slave)
+	} else {
+		s.initSlave(instance.Slave)
+		if err = s.commitEvents(setup.writes); err != nil {
+			delete(s.Slaves, instance.Slave)
+			err = fmt.Errorf("unable to write initial values: %w", err)
+		} else if len(setup.identification) != 0 {
+			s.identification[instance.Slave] = setup.identification
+		}
+		created = err == nil
+	}
+	s.memoryMutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	for _, rule := range setup.writeRules {
+		if err = s.AddWriteRule(instance.Slave, rule); err != nil {
+			return
+		}
+	}
+	if generators != nil {
+		for _, generator := range setup.generators {
+			if err = generators.Add(generator); err != nil {
+				return
+			}
+			started = append(started, generator.Name)
+		}
+		for _, name := range started {
+			generators.Start(name)
+		}
+	}
+	return
+}
+
+// resolve applies the instance parameters, slave, name and address offset to the profile.
+func (p *DeviceProfile) resolve(instance DeviceInstance) (setup deviceSetup, err error) {
+	if instance.Name == "" {
+		instance.Name = fmt.Sprintf("slave%d", instance.Slave)
+	}
+	parameters := map[string]string{"slave": strconv.Itoa(int(instance.Slave)), "name": instance.Name}
+	for _, source := range []map[string]string{p.Parameters, instance.Parameters} {
+		for name, value := range source {
+			parameters[name] = value
+		}
+	}
+	var errs []error
+	expand := func(text string) string {
+		return os.Expand(text, func(name string) string {
+			value, ok := parameters[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("unknown parameter %q", name))
+			}
+			return value
+		})
+	}
+	offset := func(address uint16, count int) (uint16, error) {
+		shifted := int(address) + int(instance.AddressOffset)
+		if shifted+count > 65536 {
+			return 0, fmt.Errorf("address %d with offset %d out of range", address, instance.AddressOffset)
+		}
+		return uint16(shifted), nil
+	}
+
+	setup.identification = make(map[uint8]string)
+	for name, value := range p.Identification {
+		id, err := ParseObjectID(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if value = expand(value); len(value) > 245 {
+			errs = append(errs, fmt.Errorf("identification object %s longer than 245 bytes", name))
+		}
+		setup.identification[id] = value
+	}
+
+	profileTags := make(map[string]Tag)
+	for _, tag := range p.Tags {
+		if err := tag.Validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		profileTags[tag.Name] = tag
+		tag.Slave = instance.Slave
+		tag.Name = instance.Name + "." + tag.Name
+		if tag.Address, err = offset(tag.Address, tag.Type.Registers()); err != nil {
+			errs = append(errs, fmt.Errorf("tag %s: %w", tag.Name, err))
+			continue
+		}
+		setup.tags = append(setup.tags, tag)
+	}
+
+	for i, value := range p.Values {
+		event, err := value.resolve(profileTags, expand)
+		if err == nil {
+			event.Slave = instance.Slave
+			event.Address, err = offset(event.Address, len(event.Values))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("value %d: %w", i+1, err))
+			continue
+		}
+		setup.writes = append(setup.writes, event)
+	}
+
+	for i, rule := range p.WriteRules {
+		if rule.Address, err = offset(rule.Address, int(rule.Quantity)); err == nil {
+			err = rule.validate()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("write rule %d: %w", i+1, err))
+			continue
+		}
+		setup.writeRules = append(setup.writeRules, rule)
+	}
+
+	for _, generator := range p.Generators {
+		generator.Slave = instance.Slave
+		generator.Name = instance.Name + "." + generator.Name
+		generator.Steps = slices.Clone(generator.Steps)
+		if err := generator.Validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if generator.Address, err = offset(generator.Address, generator.Type.Registers()); err != nil {
+			errs = append(errs, fmt.Errorf("generator %s: %w", generator.Name, err))
+			continue
+		}
+		setup.generators = append(setup.generators, generator)
+	}
+	return setup, errors.Join(errs...)
+}
+
+// resolve returns the write of the initial value at its profile address.
+func (v *ProfileValue) resolve(tags map[string]Tag, expand func(string) string) (event WriteEvent, err error) {
+	event.Table, event.Address = v.Table, v.Address
+	dataType, order, scale, offset := v.Type, v.ByteOrder, 1.0, 0.0
+	if v.Tag != "" {
+		tag, ok := tags[v.Tag]
+		if !ok {
+			return event, fmt.Errorf("unknown tag %s", v.Tag)
+		}
+		event.Table, event.Address = tag.Table, tag.Address
+		dataType, order, scale, offset = tag.Type, tag.ByteOrder, tag.scale(), tag.Offset
+	}
+	if v.Text != "" {
+		if event.Table != TableHoldingRegisters && event.Table != TableInputRegisters {
+			return event, fmt.Errorf("text needs a register table, got %s", event.Table)
+		}
+		text := expand(v.Text)
+		length := max(int(v.Length), (len(text)+1)/2)
+		if v.Length != 0 && len(text) > int(v.Length)*2 {
+			return event, fmt.Errorf("text %q longer than %d registers", text, v.Length)
+		}
+		event.Values = make([]uint16, length)
+		for i := 0; i < len(text); i++ {
+			event.Values[i/2] |= uint16(text[i]) << (8 * (1 - i%2))
+		}
+		return
+	}
+	if event.Table > TableInputRegisters {
+		return event, fmt.Errorf("unknown table %d", event.Table)
+	}
+	if dataType == "" {
+		dataType = TypeUint16
+		if event.Table == TableCoils || event.Table == TableDiscreteInputs {
+			dataType = TypeBool
+		}
+	}
+	if err = dataType.Validate(); err == nil {
+		if err = order.Validate(); err == nil {
+			err = checkBitType(event.Table, dataType)
+		}
+	}
+	if err != nil {
+		return
+	}
+	text := expand(v.Value)
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return event, fmt.Errorf("invalid value %q", text)
+	}
+	event.Values, err = EncodeValue((value-offset)/scale, dataType, order)
+	return
+}
@@ -0,0 +1,148 @@
+package modbusserver
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const testProfile = `
+name: power meter
+parameters:
+  serial: "0000"
+identification:
+  vendor_name: Acme
+  product_code: PM-${model}
+  major_minor_revision: "1.2"
+  "0x80": ${serial}
+tags:
+  - {name: voltage, table: input_registers, address: 0, type: float32}
+  - {name: current, table: input_registers, address: 2, type: uint16, scale: 0.01}
+  - {name: setpoint, table: holding_registers, address: 0, type: uint16}
+values:
+  - {tag: current, value: "1.5"}
+  - {tag: setpoint, value: "${slave}"}
+  - {table: holding_registers, address: 10, text: "SN${serial}", length: 4}
+write_rules:
+  - {table: holding_registers, address: 0, quantity: 1, max: 100}
+generators:
+  - {name: voltage, table: input_registers, address: 0, type: float32, kind: sine, period: 1m, amplitude: 5, offset: 230}
+`
+
+func TestInitSlaveFromProfile(t *testing.T) {
+	files := fstest.MapFS{"profiles/meter.yaml": {Data: []byte(testProfile)}}
+	profile, err := LoadDeviceProfileFS(files, "profiles/meter.yaml")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if profile.Generators[0].Period != time.Minute {
+		t.Errorf("expected period %v, got %v", time.Minute, profile.Generators[0].Period)
+	}
+
+	s := NewServer(slog.Logger{})
+	tags := NewTagDatabase(s)
+	generators := NewGeneratorScheduler(s, time.Hour)
+	defer generators.Close()
+	for _, instance := range []DeviceInstance{
+		{Slave: 1, Name: "meter1", Parameters: map[string]string{"serial": "1234", "model": "3"}},
+		{Slave: 2, Name: "meter2", AddressOffset: 100, Parameters: map[string]string{"model": "3"}},
+	} {
+		if err = s.InitSlaveFromProfile(profile, instance, tags, generators); err != nil {
+			t.Fatalf("slave %d: expected nil, got %v", instance.Slave, err)
+		}
+	}
+
+	if got := s.Slaves[1].InputRegisters[2]; got != 150 {
+		t.Errorf("expected %v, got %v", 150, got)
+	}
+	if got := s.Slaves[2].HoldingRegisters[100]; got != 2 {
+		t.Errorf("expected %v, got %v", 2, got)
+	}
+	if got := s.Slaves[1].HoldingRegisters[10:14]; !slices.Equal(got, []uint16{0x534e, 0x3132, 0x3334, 0}) {
+		t.Errorf("expected serial number text, got %04x", got)
+	}
+	if value, err := tags.Get("meter2.current"); err != nil || value != 1.5 {
+		t.Errorf("expected 1.5, got %v, %v", value, err)
+	}
+	if tag, _ := tags.Tag("meter2.voltage"); tag.Slave != 2 || tag.Address != 100 {
+		t.Errorf("expected tag at slave 2 address 100, got %+v", tag)
+	}
+	if exception := s.ValidateWrite(2, TableHoldingRegisters, 100, []uint16{101}); exception != &IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception)
+	}
+	if !generators.Running("meter1.voltage") || !generators.Running("meter2.voltage") {
+		t.Errorf("expected instance generators running")
+	}
+	expected := map[uint8]string{ObjectVendorName: "Acme", ObjectProductCode: "PM-3", ObjectMajorMinorRevision: "1.2", 0x80: "0000"}
+	if got := s.DeviceIdentification(2); len(got) != len(expected) || got[0x80] != "0000" || got[ObjectProductCode] != "PM-3" {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	err = s.InitSlaveFromProfile(profile, DeviceInstance{Slave: 1}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown parameter \"model\"") {
+		t.Errorf("expected unknown parameter error, got %v", err)
+	}
+	err = s.InitSlaveFromProfile(profile, DeviceInstance{Slave: 1, Parameters: map[string]string{"model": "3"}}, nil, nil)
+	if err == nil {
+		t.Errorf("expected existing slave error, got nil")
+	}
+	err = s.InitSlaveFromProfile(profile, DeviceInstance{Slave: 3, Name: "meter1", Parameters: map[string]string{"model": "3"}}, tags, generators)
+	if _, ok := s.Slaves[3]; err == nil || ok {
+		t.Errorf("expected duplicate generator error without slave, got %v", err)
+	}
+	err = s.InitSlaveFromProfile(profile, DeviceInstance{Slave: 3, AddressOffset: 65535, Parameters: map[string]string{"model": "3"}}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("expected out of range error, got %v", err)
+	}
+}
+
+func TestInitSlaveFromProfileRollback(t *testing.T) {
+	profile := &DeviceProfile{
+		Name: "pump",
+		Tags: []Tag{{Name: "flow", Table: TableInputRegisters, Type: TypeUint16}},
+		Generators: []Generator{
+			{Name: "flow", Table: TableInputRegisters, Type: TypeUint16, Kind: GeneratorNoise},
+			{Name: "flow", Table: TableInputRegisters, Address: 1, Type: TypeUint16, Kind: GeneratorNoise},
+		},
+	}
+	s := NewServer(slog.Logger{})
+	tags := NewTagDatabase(s)
+	generators := NewGeneratorScheduler(s, time.Hour)
+	defer generators.Close()
+	if err := s.InitSlaveFromProfile(profile, DeviceInstance{Slave: 1, Name: "pump"}, tags, generators); err == nil {
+		t.Fatalf("expected duplicate generator error, got nil")
+	}
+	if _, ok := s.Slaves[1]; ok {
+		t.Errorf("expected no slave")
+	}
+	if _, ok := tags.Tag("pump.flow"); ok {
+		t.Errorf("expected no tag")
+	}
+	if got := generators.Generators(); len(got) != 0 {
+		t.Errorf("expected no generators, got %v", got)
+	}
+}
+
+func TestLoadDeviceProfileErrors(t *testing.T) {
+	files := fstest.MapFS{
+		"unknown.yaml": {Data: []byte("name: x\nregisters: []\n")},
+		"unknown.json": {Data: []byte(`{"name": "x", "generators": [{"name": "g", "kind": "sine", "period": 1, "phase": 1}]}`)},
+		"meter.json":   {Data: []byte(`{"name": "x", "generators": [{"name": "g", "kind": "sine", "period": "2s"}]}`)},
+		"meter.txt":    {Data: []byte("name: x")},
+	}
+	for _, name := range []string{"unknown.yaml", "unknown.json"} {
+		if _, err := LoadDeviceProfileFS(files, name); err == nil {
+			t.Errorf("%s: expected unknown field error, got nil", name)
+		}
+	}
+	if _, err := LoadDeviceProfileFS(files, "meter.txt"); err == nil {
+		t.Errorf("expected unsupported extension error, got nil")
+	}
+	profile, err := LoadDeviceProfileFS(files, "meter.json")
+	if err != nil || profile.Generators[0].Period != 2*time.Second {
+		t.Errorf("expected period 2s, got %v, %v", profile, err)
+	}
+}
@@ -0,0 +1,332 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+type (
+	// ProxyWriteMode is how a CachingProxy handles the writes of Modbus masters.
+	ProxyWriteMode string
+	// ProxyBlock is an address block polled from the upstream device.
+	ProxyBlock struct {
+		Table    Table    `json:"table" yaml:"table"`
+		Address  uint16   `json:"address" yaml:"address"`
+		Quantity uint16   `json:"quantity" yaml:"quantity"`
+		Interval Duration `json:"interval" yaml:"interval"`
+		// MaxAge is the staleness limit of the block, 3 intervals if zero. Reads of a block not updated
+		// for longer get GatewayTargetDeviceFailedtoRespond.
+		MaxAge Duration `json:"max_age,omitempty" yaml:"max_age,omitempty"`
+	}
+	// ProxyConfig configures a CachingProxy.
+	ProxyConfig struct {
+		// Slave is the local slave caching the upstream device, created if needed.
+		Slave     uint8          `json:"slave" yaml:"slave"`
+		Blocks    []ProxyBlock   `json:"blocks" yaml:"blocks"`
+		WriteMode ProxyWriteMode `json:"write_mode,omitempty" yaml:"write_mode,omitempty"`
+		// QueueSize is the number of queued writes, 64 if zero. Writes get SlaveDeviceBusy when the queue
+		// is full.
+		QueueSize int `json:"queue_size,omitempty" yaml:"queue_size,omitempty"`
+	}
+	// ProxyBlockStatus is the state of a polled block.
+	ProxyBlockStatus struct {
+		ProxyBlock
+		// Updated is the time of the last successful poll.
+		Updated time.Time
+		// Error is the error of the last poll, nil if it succeeded.
+		Error error
+	}
+	// CachingProxy polls address blocks from an upstream device into a local slave and serves the reads
+	// of Modbus masters from this cache, so many masters can poll a slow device.
+	CachingProxy struct {
+		server *Server
+		client modbus.Client
+		config ProxyConfig
+		hook   *slaveHook
+		// clientMutex serialises the requests to the upstream device.
+		clientMutex sync.Mutex
+		mutex       sync.Mutex
+		status      []ProxyBlockStatus
+		queue       chan proxyWrite
+		closed      bool
+		stopChan    chan struct{}
+		wg          sync.WaitGroup
+	}
+	// proxyWrite is a write of a Modbus master for the upstream device.
+	proxyWrite struct {
+		function uint8
+		data     []byte
+	}
+)
+
+const (
+	// ProxyWriteThrough writes to the upstream device first, and to the cache if it succeeds. Other
+	// requests wait for the upstream write.
+	ProxyWriteThrough ProxyWriteMode = "write_through"
+	// ProxyWriteQueued writes to the cache and queues the write for the upstream device. A failed
+	// upstream write is logged and dropped, and the next poll restores the upstream values.
+	ProxyWriteQueued ProxyWriteMode = "queued"
+)
+
+// NewCachingProxy starts polling the blocks from the upstream device with the client, and serves the
+// requests for the slave from the cache: reads of polled blocks get GatewayTargetDeviceFailedtoRespond once
+// stale, and writes are sent to the upstream device according to the write mode. The requests of
+// functions 1 to 6, 15 and 16 for the slave are handled by the proxy before the function handlers. Close
+// must be called to stop polling.
+func NewCachingProxy(s *Server, client modbus.Client, config ProxyConfig) (*CachingProxy, error) {
+	if config.WriteMode == "" {
+		config.WriteMode = ProxyWriteThrough
+	}
+	if config.WriteMode != ProxyWriteThrough && config.WriteMode != ProxyWriteQueued {
+		return nil, fmt.Errorf("unknown proxy write mode %q", config.WriteMode)
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 64
+	}
+	p := &CachingProxy{
+		server:   s,
+		client:   client,
+		config:   config,
+		status:   make([]ProxyBlockStatus, len(config.Blocks)),
+		queue:    make(chan proxyWrite, config.QueueSize),
+		stopChan: make(chan struct{}),
+	}
+	for i, block := range config.Blocks {
+		if block.Table > TableInputRegisters || block.Quantity == 0 || int(block.Address)+int(block.Quantity) > 65536 {
+			return nil, fmt.Errorf("proxy block %d: invalid range: %s %d, quantity %d", i, block.Table, block.Address, block.Quantity)
+		}
+		if block.Interval <= 0 || block.MaxAge < 0 {
+			return nil, fmt.Errorf("proxy block %d: invalid interval or max age", i)
+		}
+		if block.MaxAge == 0 {
+			block.MaxAge = 3 * block.Interval
+		}
+		p.status[i].ProxyBlock = block
+	}
+	s.InitSlave(config.Slave)
+	p.hook = s.addSlaveHook(config.Slave, p.handle)
+	for i := range p.status {
+		p.wg.Add(1)
+		go p.poll(i)
+	}
+	if config.WriteMode == ProxyWriteQueued {
+		p.wg.Add(1)
+		go p.writeLoop()
+	}
+	return p, nil
+}
+
+// Close stops polling and sending queued writes. The slave is then served from local memory.
+func (p *CachingProxy) Close() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.closed = true
+	p.mutex.Unlock()
+	p.server.removeSlaveHook(p.config.Slave, p.hook)
+	close(p.stopChan)
+	p.wg.Wait()
+}
+
+// Status returns the state of the polled blocks.
+func (p *CachingProxy) Status() []ProxyBlockStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]ProxyBlockStatus(nil), p.status...)
+}
+
+func (p *CachingProxy) poll(index int) {
+	defer p.wg.Done()
+	block := p.status[index].ProxyBlock
+	ticker := time.NewTicker(time.Duration(block.Interval))
+	defer ticker.Stop()
+	for {
+		values, err := p.read(block)
+		if err == nil {
+			err = p.server.WriteTable(p.config.Slave, block.Table, block.Address, values)
+		}
+		p.mutex.Lock()
+		if p.status[index].Error = err; err == nil {
+			p.status[index].Updated = time.Now()
+		}
+		p.mutex.Unlock()
+		if err != nil {
+			p.server.logger.Debug(fmt.Sprintf("Proxy of slave %d: poll of %s %d failed: %s", p.config.Slave, block.Table, block.Address, err.Error()))
+		}
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// read reads the block from the upstream device.
+func (p *CachingProxy) read(block ProxyBlock) (values []uint16, err error) {
+	p.clientMutex.Lock()
+	defer p.clientMutex.Unlock()
+	var results []byte
+	switch block.Table {
+	case TableCoils:
+		results, err = p.client.ReadCoils(block.Address, block.Quantity)
+	case TableDiscreteInputs:
+		results, err = p.client.ReadDiscreteInputs(block.Address, block.Quantity)
+	case TableHoldingRegisters:
+		results, err = p.client.ReadHoldingRegisters(block.Address, block.Quantity)
+	case TableInputRegisters:
+		results, err = p.client.ReadInputRegisters(block.Address, block.Quantity)
+	}
+	if err != nil {
+		return
+	}
+	if block.Table == TableCoils || block.Table == TableDiscreteInputs {
+		if len(results) < (int(block.Quantity)+7)/8 {
+			return nil, errors.New("short response")
+		}
+		values = make([]uint16, block.Quantity)
+		for i := range values {
+			values[i] = uint16(bitAtPosition(results[i/8], uint(i%8)))
+		}
+		return
+	}
+	if len(results) < 2*int(block.Quantity) {
+		return nil, errors.New("short response")
+	}
+	return BytesToUint16(results), nil
+}
+
+// handle is the slave hook of the proxy: it answers the reads of stale blocks and the writes, leaving the
+// other reads to the function handlers. The upstream writes are sent without the memory lock.
+func (p *CachingProxy) handle(frame Framer) ([]byte, *Exception, bool) {
+	function := frame.GetFunction()
+	switch function {
+	case 1, 2, 3, 4:
+		if p.stale(Table(function-1), frame) {
+			return []byte{}, &GatewayTargetDeviceFailedtoRespond, true
+		}
+		return nil, nil, false
+	case 5, 6, 15, 16:
+	default:
+		return nil, nil, false
+	}
+
+	s := p.server
+	table, address, values, exception := writeRequest(frame)
+	if exception != &Success {
+		return []byte{}, exception, true
+	}
+	if exception = s.ValidateWrite(p.config.Slave, table, address, values); exception != &Success {
+		return []byte{}, exception, true
+	}
+	write := proxyWrite{function: function, data: bytes.Clone(frame.GetData())}
+	if p.config.WriteMode == ProxyWriteQueued {
+		select {
+		case p.queue <- write:
+		default:
+			return []byte{}, &SlaveDeviceBusy, true
+		}
+	} else if err := p.write(write); err != nil {
+		var modbusError *modbus.ModbusError
+		if errors.As(err, &modbusError) {
+			exception := Exception(modbusError.ExceptionCode)
+			return []byte{}, &exception, true
+		}
+		return []byte{}, &GatewayTargetDeviceFailedtoRespond, true
+	}
+	s.memoryMutex.Lock()
+	exception = s.commitWrite(p.config.Slave, table, address, values)
+	s.memoryMutex.Unlock()
+	if exception != &Success {
+		return []byte{}, exception, true
+	}
+	return frame.GetData()[0:4], &Success, true
+}
+
+// stale returns true if the read request covers a stale block.
+func (p *CachingProxy) stale(table Table, frame Framer) bool {
+	address, quantity, ok := requestRange(frame)
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, status := range p.status {
+		if status.Table == table && int(status.Address) < address+quantity && address < int(status.Address)+int(status.Quantity) &&
+			now.Sub(status.Updated) > time.Duration(status.MaxAge) {
+			return true
+		}
+	}
+	return false
+}
+
+// write sends a write request to the upstream device.
+func (p *CachingProxy) write(write proxyWrite) (err error) {
+	p.clientMutex.Lock()
+	defer p.clientMutex.Unlock()
+	address, value := binary.BigEndian.Uint16(write.data[0:2]), binary.BigEndian.Uint16(write.data[2:4])
+	switch write.function {
+	case 5:
+		_, err = p.client.WriteSingleCoil(address, value)
+	case 6:
+		_, err = p.client.WriteSingleRegister(address, value)
+	case 15:
+		_, err = p.client.WriteMultipleCoils(address, value, write.data[5:])
+	case 16:
+		_, err = p.client.WriteMultipleRegisters(address, value, write.data[5:])
+	}
+	return
+}
+
+func (p *CachingProxy) writeLoop() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case write := <-p.queue:
+			if err := p.write(write); err != nil {
+				p.server.logger.Error(fmt.Sprintf("Proxy of slave %d: queued write of function %d dropped: %s", p.config.Slave, write.function, err.Error()))
+			}
+		}
+	}
+}
+
+// writeRequest returns the table, address and values of a request of function 5, 6, 15 or 16.
+func writeRequest(frame Framer) (table Table, address int, values []uint16, exception *Exception) {
+	data := frame.GetData()
+	if len(data) < 4 {
+		return table, 0, nil, &IllegalDataValue
+	}
+	address = int(binary.BigEndian.Uint16(data[0:2]))
+	value := binary.BigEndian.Uint16(data[2:4])
+	switch frame.GetFunction() {
+	case 5:
+		return TableCoils, address, []uint16{uint16(bitValue(value))}, &Success
+	case 6:
+		return TableHoldingRegisters, address, []uint16{value}, &Success
+	case 15:
+		if len(data) < 5+(int(value)+7)/8 {
+			return table, 0, nil, &IllegalDataValue
+		}
+		values = make([]uint16, value)
+		for i := range values {
+			values[i] = uint16(bitAtPosition(data[5+i/8], uint(i%8)))
+		}
+		return TableCoils, address, values, &Success
+	case 16:
+		if len(data) < 5+2*int(value) {
+			return table, 0, nil, &IllegalDataValue
+		}
+		return TableHoldingRegisters, address, BytesToUint16(data[5 : 5+2*int(value)]), &Success
+	}
+	return table, 0, nil, &IllegalFunction
+}
@@ -0,0 +1,170 @@
+package modbusserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestCachingProxy(t *testing.T) {
+	upstream := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	upstream.InitSlave(1)
+	upstream.WriteTable(1, TableHoldingRegisters, 0, []uint16{1, 2, 3, 4})
+	upstream.WriteTable(1, TableDiscreteInputs, 0, []uint16{1, 0, 1})
+	upstreamAddr := getFreePort()
+	if err := upstream.ListenTCP(upstreamAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer upstream.Close()
+	upstreamHandler := modbus.NewTCPClientHandler(upstreamAddr)
+	upstreamHandler.SlaveId = 1
+	upstreamHandler.Timeout = 200 * time.Millisecond
+	defer upstreamHandler.Close()
+
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	if _, err := NewCachingProxy(s, modbus.NewClient(upstreamHandler), ProxyConfig{Slave: 7, WriteMode: "lazy"}); err == nil {
+		t.Errorf("expected write mode error, got nil")
+	}
+	proxy, err := NewCachingProxy(s, modbus.NewClient(upstreamHandler), ProxyConfig{
+		Slave: 7,
+		Blocks: []ProxyBlock{
+			{Table: TableHoldingRegisters, Address: 0, Quantity: 4, Interval: Duration(20 * time.Millisecond), MaxAge: Duration(150 * time.Millisecond)},
+			{Table: TableDiscreteInputs, Address: 0, Quantity: 3, Interval: Duration(20 * time.Millisecond)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer proxy.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for status := proxy.Status(); status[0].Updated.IsZero() || status[1].Updated.IsZero(); status = proxy.Status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected blocks polled, got %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.SlaveId = 7
+	handler.Timeout = time.Second
+	if err = handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+	if results, err := client.ReadHoldingRegisters(0, 4); err != nil || !slices.Equal(BytesToUint16(results), []uint16{1, 2, 3, 4}) {
+		t.Errorf("expected cached registers, got %v, %v", results, err)
+	}
+	if results, err := client.ReadDiscreteInputs(0, 3); err != nil || results[0] != 5 {
+		t.Errorf("expected cached inputs, got %v, %v", results, err)
+	}
+
+	if _, err = client.WriteSingleRegister(2, 30); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	upstreamValues, _ := upstream.ReadTable(1, TableHoldingRegisters, 2, 1)
+	cachedValues, _ := s.ReadTable(7, TableHoldingRegisters, 2, 1)
+	if upstreamValues[0] != 30 || cachedValues[0] != 30 {
+		t.Errorf("expected write through, got upstream %v, cache %v", upstreamValues, cachedValues)
+	}
+
+	upstream.SlaveStopResponse(1)
+	var modbusError *modbus.ModbusError
+	if _, err = client.WriteSingleRegister(2, 40); !errors.As(err, &modbusError) || modbusError.ExceptionCode != uint8(GatewayTargetDeviceFailedtoRespond) {
+		t.Errorf("expected GatewayTargetDeviceFailedtoRespond on write, got %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err = client.ReadHoldingRegisters(1, 1); !errors.As(err, &modbusError) || modbusError.ExceptionCode != uint8(GatewayTargetDeviceFailedtoRespond) {
+		t.Errorf("expected GatewayTargetDeviceFailedtoRespond on stale read, got %v", err)
+	}
+	if _, err = client.ReadHoldingRegisters(100, 1); err != nil {
+		t.Errorf("expected nil outside the blocks, got %v", err)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for status := proxy.Status(); status[0].Error == nil; status = proxy.Status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected failing block, got %+v", status[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCachingProxyQueuedWrites(t *testing.T) {
+	upstream := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	upstream.InitSlave(1)
+	upstreamAddr := getFreePort()
+	if err := upstream.ListenTCP(upstreamAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer upstream.Close()
+	upstreamHandler := modbus.NewTCPClientHandler(upstreamAddr)
+	upstreamHandler.SlaveId = 1
+	upstreamHandler.Timeout = time.Second
+	defer upstreamHandler.Close()
+
+	s := NewServer(slog.Logger{})
+	proxy, err := NewCachingProxy(s, modbus.NewClient(upstreamHandler), ProxyConfig{Slave: 1, WriteMode: ProxyWriteQueued})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer proxy.Close()
+	frame := &TCPFrame{Device: 1, Function: 15}
+	SetDataWithRegisterAndNumberAndBytes(frame, 8, 3, []byte{0b101})
+	response := s.respond(frame)
+	if data := response.GetData(); GetException(response) != Success || binary.BigEndian.Uint16(data[2:4]) != 3 {
+		t.Fatalf("expected queued write, got %v", response)
+	}
+	if cached, _ := s.ReadTable(1, TableCoils, 8, 3); !slices.Equal(cached, []uint16{1, 0, 1}) {
+		t.Errorf("expected cache written, got %v", cached)
+	}
+	deadline := time.Now().Add(time.Second)
+	for values, _ := upstream.ReadTable(1, TableCoils, 8, 3); !slices.Equal(values, []uint16{1, 0, 1}); values, _ = upstream.ReadTable(1, TableCoils, 8, 3) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected upstream written, got %v", values)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCachingProxyClose(t *testing.T) {
+	// The upstream device doesn't exist, so the writes through the proxy fail.
+	upstreamHandler := modbus.NewTCPClientHandler(getFreePort())
+	upstreamHandler.Timeout = 100 * time.Millisecond
+	defer upstreamHandler.Close()
+
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(2)
+	proxy, err := NewCachingProxy(s, modbus.NewClient(upstreamHandler), ProxyConfig{Slave: 1})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	write := func(id uint8) Exception {
+		frame := &TCPFrame{Device: id, Function: 6}
+		SetDataWithRegisterAndNumber(frame, 3, 9)
+		return GetException(s.respond(frame))
+	}
+	if exception := write(1); exception != GatewayTargetDeviceFailedtoRespond {
+		t.Errorf("expected GatewayTargetDeviceFailedtoRespond, got %v", exception)
+	}
+	if exception := write(2); exception != Success {
+		t.Errorf("expected other slaves served locally, got %v", exception)
+	}
+	proxy.Close()
+	if exception := write(1); exception != Success {
+		t.Errorf("expected slave served locally after Close, got %v", exception)
+	}
+	if values, _ := s.ReadTable(1, TableHoldingRegisters, 3, 1); values[0] != 9 {
+		t.Errorf("expected register written, got %v", values)
+	}
+}
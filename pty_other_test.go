@@ -0,0 +1,14 @@
+//go:build !linux
+
+package modbusserver
+
+import (
+	"os"
+	"testing"
+)
+
+// openPTY skips the test, pseudo terminals are only opened on Linux.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Skip("pseudo terminals are only opened on Linux")
+	return nil, ""
+}
@@ -0,0 +1,521 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// RecordedTransaction is a request and its response read from a capture.
+	RecordedTransaction struct {
+		Time    time.Time
+		Request Framer
+		// Response is the recorded response ADU, nil if the device didn't respond.
+		Response []byte
+	}
+	// ReplayResult compares the response of the server to a recorded transaction.
+	ReplayResult struct {
+		// Index is the index of the transaction in the replayed session.
+		Index       int
+		Transaction RecordedTransaction
+		// Actual is the response ADU of the server, nil if it didn't respond.
+		Actual      []byte
+		Differences []string
+	}
+	// capturedPacket is a packet of a pcap or pcapng file with its link type.
+	capturedPacket struct {
+		time     time.Time
+		linkType uint32
+		data     []byte
+	}
+	// capturedADU is a Modbus ADU extracted from the captured packets. channel identifies the connection
+	// or serial line.
+	capturedADU struct {
+		time    time.Time
+		channel string
+		// fromClient is true for a Modbus TCP request, unknown for RTU frames.
+		fromClient bool
+		rtu        bool
+		data       []byte
+	}
+	// capturedConversation reassembles the TCP streams of a connection.
+	capturedConversation struct {
+		server  netip.AddrPort
+		next    map[bool]uint32
+		started map[bool]bool
+		buffer  map[bool][]byte
+	}
+)
+
+// LoadCaptureFile reads the Modbus transactions of a pcap or pcapng capture file, like those written by
+// StartCaptureFile.
+func LoadCaptureFile(path string) (transactions []RecordedTransaction, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return ReadCapture(file)
+}
+
+// ReadCapture reads the Modbus transactions of a pcap or pcapng capture. Modbus TCP is read from TCP
+// streams, pairing the responses with the requests by transaction ID; RTU frames are read from exported
+// PDUs and from TCP streams not carrying Modbus TCP, pairing each request with the following frame of the
+// same slave and function.
+func ReadCapture(r io.Reader) (transactions []RecordedTransaction, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	var packets []capturedPacket
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == pcapngSectionHeader {
+		packets, err = readPcapng(data)
+	} else {
+		packets, err = readPcap(data)
+	}
+	if err != nil {
+		return
+	}
+	return pairTransactions(extractADUs(packets)), nil
+}
+
+func readPcap(data []byte) (packets []capturedPacket, err error) {
+	if len(data) < 24 {
+		return nil, errors.New("capture too short")
+	}
+	var order binary.ByteOrder
+	var nanoseconds bool
+	for _, candidate := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch candidate.Uint32(data) {
+		case 0xA1B2C3D4:
+			order = candidate
+		case 0xA1B23C4D:
+			order, nanoseconds = candidate, true
+		}
+	}
+	if order == nil {
+		return nil, errors.New("unknown capture format")
+	}
+	linkType := order.Uint32(data[20:24]) & 0x0FFFFFFF
+	for data = data[24:]; len(data) != 0; {
+		if len(data) < 16 {
+			return nil, errors.New("truncated pcap record header")
+		}
+		length := int(order.Uint32(data[8:12]))
+		if len(data) < 16+length {
+			return nil, errors.New("truncated pcap record")
+		}
+		fraction := int64(order.Uint32(data[4:8]))
+		if !nanoseconds {
+			fraction *= 1000
+		}
+		packets = append(packets, capturedPacket{time.Unix(int64(order.Uint32(data[0:4])), fraction), linkType, data[16 : 16+length]})
+		data = data[16+length:]
+	}
+	return
+}
+
+func readPcapng(data []byte) (packets []capturedPacket, err error) {
+	type interfaceInfo struct {
+		linkType uint32
+		// resolution is the number of timestamp units per second.
+		resolution float64
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	var interfaces []interfaceInfo
+	for len(data) != 0 {
+		if len(data) < 12 {
+			return nil, errors.New("truncated pcapng block")
+		}
+		if binary.LittleEndian.Uint32(data) == pcapngSectionHeader {
+			switch {
+			case binary.LittleEndian.Uint32(data[8:12]) == pcapngByteOrderMagic:
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint32(data[8:12]) == pcapngByteOrderMagic:
+				order = binary.BigEndian
+			default:
+				return nil, errors.New("invalid pcapng byte order magic")
+			}
+			interfaces = nil
+		}
+		blockType, length := order.Uint32(data[0:4]), order.Uint32(data[4:8])
+		if length < 12 || length%4 != 0 || int(length) > len(data) {
+			return nil, fmt.Errorf("invalid pcapng block length %d", length)
+		}
+		body := data[8 : length-4]
+		data = data[length:]
+		switch blockType {
+		case pcapngInterface:
+			if len(body) < 8 {
+				return nil, errors.New("truncated pcapng interface block")
+			}
+			info := interfaceInfo{linkType: uint32(order.Uint16(body[0:2])), resolution: 1e6}
+			for options := body[8:]; len(options) >= 4; {
+				code, size := order.Uint16(options[0:2]), int(order.Uint16(options[2:4]))
+				if code == pcapngOptionEnd || len(options) < 4+size {
+					break
+				}
+				if code == pcapngOptionTimestampRes && size == 1 {
+					if resolution := options[4]; resolution&0x80 != 0 {
+						info.resolution = math.Pow(2, float64(resolution&0x7F))
+					} else {
+						info.resolution = math.Pow(10, float64(resolution))
+					}
+				}
+				options = options[4+(size+3)/4*4:]
+			}
+			interfaces = append(interfaces, info)
+		case pcapngEnhancedPacket:
+			if len(body) < 20 {
+				return nil, errors.New("truncated pcapng packet block")
+			}
+			id, length := int(order.Uint32(body[0:4])), int(order.Uint32(body[12:16]))
+			if id >= len(interfaces) || len(body) < 20+length {
+				return nil, errors.New("invalid pcapng packet block")
+			}
+			timestamp := float64(uint64(order.Uint32(body[4:8]))<<32|uint64(order.Uint32(body[8:12]))) / interfaces[id].resolution
+			seconds, fraction := math.Modf(timestamp)
+			packets = append(packets, capturedPacket{time.Unix(int64(seconds), int64(fraction*1e9)), interfaces[id].linkType, body[20 : 20+length]})
+		}
+	}
+	return
+}
+
+// extractADUs decodes the link, IP and TCP layers of the packets and returns the Modbus ADUs they carry.
+func extractADUs(packets []capturedPacket) (adus []capturedADU) {
+	conversations := make(map[string]*capturedConversation)
+	for _, packet := range packets {
+		if packet.linkType == linkTypeUpperPDU {
+			if adu, ok := decodeExportedPDU(packet.data); ok {
+				adu.time = packet.time
+				adus = append(adus, adu)
+			}
+			continue
+		}
+		src, dst, seq, flags, payload, ok := decodeTCP(packet.linkType, packet.data)
+		if !ok {
+			continue
+		}
+		channel := src.String() + " " + dst.String()
+		if dst.Compare(src) < 0 {
+			channel = dst.String() + " " + src.String()
+		}
+		conversation, ok := conversations[channel]
+		if !ok {
+			conversation = &capturedConversation{next: make(map[bool]uint32), started: make(map[bool]bool), buffer: make(map[bool][]byte)}
+			conversations[channel] = conversation
+		}
+		// The client sends the SYN or, for a capture started later, the first data.
+		if !conversation.server.IsValid() && (flags&(tcpFlagSYN|tcpFlagACK) == tcpFlagSYN || len(payload) != 0) {
+			conversation.server = dst
+		}
+		fromClient := dst == conversation.server
+		if flags&tcpFlagSYN != 0 {
+			conversation.next[fromClient], conversation.started[fromClient] = seq+1, true
+			continue
+		}
+		if len(payload) == 0 || !conversation.server.IsValid() {
+			continue
+		}
+		// Skip retransmitted data.
+		if next := conversation.next[fromClient]; conversation.started[fromClient] {
+			if int32(seq+uint32(len(payload))-next) <= 0 {
+				continue
+			}
+			if offset := int32(next - seq); offset > 0 {
+				payload = payload[offset:]
+			}
+		}
+		conversation.next[fromClient] = seq + uint32(len(payload))
+		conversation.started[fromClient] = true
+
+		buffer := append(conversation.buffer[fromClient], payload...)
+		if len(buffer) >= 4 && binary.BigEndian.Uint16(buffer[2:4]) == 0 {
+			// Modbus TCP: split the stream by the MBAP length.
+			for len(buffer) >= 6 && len(buffer) >= 6+int(binary.BigEndian.Uint16(buffer[4:6])) {
+				length := 6 + int(binary.BigEndian.Uint16(buffer[4:6]))
+				adus = append(adus, capturedADU{packet.time, channel, fromClient, false, bytes.Clone(buffer[:length])})
+				buffer = buffer[length:]
+			}
+			conversation.buffer[fromClient] = buffer
+		} else if len(buffer) >= 4 {
+			// RTU over TCP: a frame per segment.
+			adus = append(adus, capturedADU{packet.time, channel, fromClient, true, bytes.Clone(buffer)})
+			conversation.buffer[fromClient] = nil
+		} else {
+			conversation.buffer[fromClient] = buffer
+		}
+	}
+	return
+}
+
+// decodeTCP returns the TCP segment of a packet.
+func decodeTCP(linkType uint32, data []byte) (src netip.AddrPort, dst netip.AddrPort, seq uint32, flags uint8, payload []byte, ok bool) {
+	switch linkType {
+	case 0: // BSD loopback
+		if len(data) < 4 {
+			return
+		}
+		data = data[4:]
+	case 1: // Ethernet
+		if len(data) < 14 {
+			return
+		}
+		etherType, offset := binary.BigEndian.Uint16(data[12:14]), 14
+		for (etherType == 0x8100 || etherType == 0x88A8) && len(data) >= offset+4 {
+			etherType, offset = binary.BigEndian.Uint16(data[offset+2:offset+4]), offset+4
+		}
+		data = data[offset:]
+	case 113: // Linux cooked capture
+		if len(data) < 16 {
+			return
+		}
+		data = data[16:]
+	case 276: // Linux cooked capture v2
+		if len(data) < 20 {
+			return
+		}
+		data = data[20:]
+	case linkTypeRaw, 228, 229:
+	default:
+		return
+	}
+	var srcAddr, dstAddr netip.Addr
+	switch {
+	case len(data) >= 20 && data[0]>>4 == 4:
+		headerLength, totalLength := int(data[0]&0x0F)*4, int(binary.BigEndian.Uint16(data[2:4]))
+		if data[9] != 6 || headerLength < 20 || totalLength < headerLength || totalLength > len(data) {
+			return
+		}
+		srcAddr, dstAddr = netip.AddrFrom4([4]byte(data[12:16])), netip.AddrFrom4([4]byte(data[16:20]))
+		data = data[headerLength:totalLength]
+	case len(data) >= 40 && data[0]>>4 == 6:
+		payloadLength := int(binary.BigEndian.Uint16(data[4:6]))
+		if data[6] != 6 || 40+payloadLength > len(data) {
+			return
+		}
+		srcAddr, dstAddr = netip.AddrFrom16([16]byte(data[8:24])), netip.AddrFrom16([16]byte(data[24:40]))
+		data = data[40 : 40+payloadLength]
+	default:
+		return
+	}
+	if len(data) < 20 || int(data[12]>>4)*4 < 20 || int(data[12]>>4)*4 > len(data) {
+		return
+	}
+	src = netip.AddrPortFrom(srcAddr, binary.BigEndian.Uint16(data[0:2]))
+	dst = netip.AddrPortFrom(dstAddr, binary.BigEndian.Uint16(data[2:4]))
+	return src, dst, binary.BigEndian.Uint32(data[4:8]), data[13], data[int(data[12]>>4)*4:], true
+}
+
+// decodeExportedPDU returns the Modbus ADU of a Wireshark exported PDU for the Modbus RTU or TCP dissector.
+func decodeExportedPDU(data []byte) (adu capturedADU, ok bool) {
+	var protocol string
+	var src, dst netip.Addr
+	var srcPort, dstPort uint32
+	for {
+		if len(data) < 4 {
+			return
+		}
+		tag, length := binary.BigEndian.Uint16(data[0:2]), int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+length {
+			return
+		}
+		value := data[4 : 4+length]
+		data = data[4+length:]
+		switch tag {
+		case exportedPDUProtoName:
+			protocol = string(bytes.TrimRight(value, "\x00"))
+		case exportedPDUIPv4Src, exportedPDUIPv6Src:
+			src, _ = netip.AddrFromSlice(value)
+		case exportedPDUIPv4Dst, exportedPDUIPv6Dst:
+			dst, _ = netip.AddrFromSlice(value)
+		case exportedPDUSrcPort, exportedPDUDstPort:
+			if len(value) != 4 {
+				return
+			}
+			if tag == exportedPDUSrcPort {
+				srcPort = binary.BigEndian.Uint32(value)
+			} else {
+				dstPort = binary.BigEndian.Uint32(value)
+			}
+		}
+		if tag == exportedPDUEnd {
+			break
+		}
+	}
+	if protocol != "mbrtu" || len(data) == 0 {
+		return
+	}
+	channel := "serial"
+	if src.IsValid() && dst.IsValid() {
+		a, b := netip.AddrPortFrom(src, uint16(srcPort)), netip.AddrPortFrom(dst, uint16(dstPort))
+		if b.Compare(a) < 0 {
+			a, b = b, a
+		}
+		channel = a.String() + " " + b.String()
+	}
+	return capturedADU{channel: channel, rtu: true, data: bytes.Clone(data)}, true
+}
+
+// pairTransactions pairs the captured requests with their responses, in the order of the requests.
+func pairTransactions(adus []capturedADU) (transactions []RecordedTransaction) {
+	pendingTCP := make(map[string]map[uint16][]int)
+	pendingRTU := make(map[string]int)
+	for _, adu := range adus {
+		if adu.rtu {
+			if index, ok := pendingRTU[adu.channel]; ok && len(adu.data) >= 2 {
+				request := transactions[index].Request
+				if adu.data[0] == request.GetSlaveId() && adu.data[1]&0x7F == request.GetFunction() {
+					transactions[index].Response = adu.data
+					delete(pendingRTU, adu.channel)
+					continue
+				}
+			}
+			if frame, err := NewRTUFrame(adu.data); err == nil {
+				pendingRTU[adu.channel] = len(transactions)
+				transactions = append(transactions, RecordedTransaction{Time: adu.time, Request: frame})
+			}
+			continue
+		}
+		pending, ok := pendingTCP[adu.channel]
+		if !ok {
+			pending = make(map[uint16][]int)
+			pendingTCP[adu.channel] = pending
+		}
+		id := binary.BigEndian.Uint16(adu.data[0:2])
+		if !adu.fromClient {
+			if indexes := pending[id]; len(indexes) != 0 {
+				transactions[indexes[0]].Response = adu.data
+				pending[id] = indexes[1:]
+			}
+			continue
+		}
+		if frame, err := NewTCPFrame(adu.data); err == nil {
+			pending[id] = append(pending[id], len(transactions))
+			transactions = append(transactions, RecordedTransaction{Time: adu.time, Request: frame})
+		}
+	}
+	return
+}
+
+// Replay sends the recorded requests to the server in order, as received from a connection, and compares
+// the responses with the recorded ones. Requests go through the routing table and the injected faults as
+// on the wire; differences in transaction IDs are ignored. Network conditions and response timing are
+// not applied.
+func (s *Server) Replay(transactions []RecordedTransaction) []ReplayResult {
+	results := make([]ReplayResult, len(transactions))
+	for i, transaction := range transactions {
+		var actual []byte
+		request := &Request{frame: transaction.Request, address: "replay"}
+		faults := s.injectFaults(request.frame)
+		if respond, _ := s.routeRequest(request, faults); respond != nil {
+			if response := respond(); response != nil {
+				actual = bytes.Join(faults.packets(response), nil)
+			}
+		}
+		results[i] = ReplayResult{
+			Index:       i,
+			Transaction: transaction,
+			Actual:      actual,
+			Differences: compareResponses(transaction.Request, transaction.Response, actual),
+		}
+	}
+	return results
+}
+
+// Equal returns true if the server responded as recorded.
+func (r ReplayResult) Equal() bool {
+	return len(r.Differences) == 0
+}
+
+func (r ReplayResult) String() string {
+	status := "equal"
+	if !r.Equal() {
+		status = strings.Join(r.Differences, "; ")
+	}
+	return fmt.Sprintf("transaction %d (slave %d, function %d): %s",
+		r.Index, r.Transaction.Request.GetSlaveId(), r.Transaction.Request.GetFunction(), status)
+}
+
+// compareResponses describes the differences between the expected and actual response ADUs to the request.
+func compareResponses(request Framer, expected []byte, actual []byte) (differences []string) {
+	switch {
+	case expected == nil && actual == nil:
+		return
+	case expected == nil:
+		return []string{"unexpected response"}
+	case actual == nil:
+		return []string{"missing response"}
+	}
+	expectedPDU, expectedErr := responsePDU(request, expected)
+	actualPDU, actualErr := responsePDU(request, actual)
+	if expectedErr != nil || actualErr != nil {
+		if !bytes.Equal(expected, actual) {
+			differences = append(differences, fmt.Sprintf("response: expected % X, got % X", expected, actual))
+		}
+		return
+	}
+	if expectedPDU[0] != actualPDU[0] {
+		differences = append(differences, fmt.Sprintf("unit ID: expected %d, got %d", expectedPDU[0], actualPDU[0]))
+	}
+	if expectedPDU[1] != actualPDU[1] {
+		return append(differences, fmt.Sprintf("function: expected %d, got %d", expectedPDU[1], actualPDU[1]))
+	}
+	expectedData, actualData := expectedPDU[2:], actualPDU[2:]
+	if expectedPDU[1]&0x80 != 0 && len(expectedData) == 1 && len(actualData) == 1 {
+		if expectedData[0] != actualData[0] {
+			differences = append(differences, fmt.Sprintf("exception: expected %v, got %v", Exception(expectedData[0]), Exception(actualData[0])))
+		}
+		return
+	}
+	address, quantity, ok := requestRange(request)
+	switch function := expectedPDU[1]; {
+	case ok && (function == 3 || function == 4) && len(expectedData) == 1+2*quantity && len(actualData) == len(expectedData):
+		for i := 0; i < quantity; i++ {
+			expectedValue, actualValue := binary.BigEndian.Uint16(expectedData[1+2*i:]), binary.BigEndian.Uint16(actualData[1+2*i:])
+			if expectedValue != actualValue {
+				differences = append(differences, fmt.Sprintf("register %d: expected %d, got %d", address+i, expectedValue, actualValue))
+			}
+		}
+	case ok && (function == 1 || function == 2) && len(expectedData) == 1+(quantity+7)/8 && len(actualData) == len(expectedData):
+		for i := 0; i < quantity; i++ {
+			expectedBit, actualBit := expectedData[1+i/8]>>(i%8)&1, actualData[1+i/8]>>(i%8)&1
+			if expectedBit != actualBit {
+				differences = append(differences, fmt.Sprintf("bit %d: expected %d, got %d", address+i, expectedBit, actualBit))
+			}
+		}
+	case len(expectedData) != len(actualData):
+		differences = append(differences, fmt.Sprintf("data: expected % X, got % X", expectedData, actualData))
+	default:
+		for i := range expectedData {
+			if expectedData[i] != actualData[i] {
+				differences = append(differences, fmt.Sprintf("data byte %d: expected 0x%02X, got 0x%02X", i, expectedData[i], actualData[i]))
+			}
+		}
+	}
+	return
+}
+
+// responsePDU returns the unit ID, function code and data of a response ADU in the framing of the request.
+func responsePDU(request Framer, adu []byte) ([]byte, error) {
+	if _, ok := request.(*TCPFrame); ok {
+		frame, err := NewTCPFrame(adu)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{frame.Device, frame.Function}, frame.Data...), nil
+	}
+	frame, err := NewRTUFrame(adu)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{frame.SlaveId, frame.Function}, frame.Data...), nil
+}
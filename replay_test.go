@@ -0,0 +1,185 @@
+package modbusserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestReplayCapture(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.WriteTable(1, TableHoldingRegisters, 10, []uint16{1, 2, 3})
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	var buffer bytes.Buffer
+	s.StartCapture(addr, &buffer)
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.SlaveId = 1
+	handler.Timeout = time.Second
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+	client.ReadHoldingRegisters(10, 3)
+	client.WriteSingleCoil(4, 0xFF00)
+	client.ReadCoils(0, 8)
+	client.ReadHoldingRegisters(65535, 2)
+	s.StopCapture(addr)
+
+	transactions, err := ReadCapture(&buffer)
+	if err != nil || len(transactions) != 4 {
+		t.Fatalf("expected 4 transactions, got %d, %v", len(transactions), err)
+	}
+
+	replayed := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	replayed.InitSlave(1)
+	replayed.WriteTable(1, TableHoldingRegisters, 10, []uint16{1, 5, 3})
+	// Transaction IDs are ignored.
+	transactions[2].Request.(*TCPFrame).TransactionIdentifier += 100
+	results := replayed.Replay(transactions)
+	expected := [][]string{{"register 11: expected 2, got 5"}, nil, nil, nil}
+	for i, result := range results {
+		if len(result.Differences) != len(expected[i]) || (len(expected[i]) != 0 && result.Differences[0] != expected[i][0]) {
+			t.Errorf("transaction %d: expected %v, got %v", i, expected[i], result.Differences)
+		}
+	}
+	if coils, _ := replayed.ReadTable(1, TableCoils, 4, 1); coils[0] != 1 {
+		t.Errorf("expected replayed write, got %v", coils)
+	}
+
+	replayed.SlaveStopResponse(1)
+	if result := replayed.Replay(transactions[:1])[0]; result.Equal() || result.Differences[0] != "missing response" {
+		t.Errorf("expected missing response, got %v", result)
+	}
+}
+
+func TestReadPcap(t *testing.T) {
+	client, server := netip.MustParseAddrPort("10.0.0.2:40000"), netip.MustParseAddrPort("10.0.0.1:502")
+	request := (&TCPFrame{TransactionIdentifier: 7, Length: 6, Device: 1, Function: 3, Data: []byte{0, 0, 0, 1}}).Bytes()
+	response := (&TCPFrame{TransactionIdentifier: 7, Length: 5, Device: 1, Function: 3, Data: []byte{2, 0, 9}}).Bytes()
+	segment := func(src netip.AddrPort, dst netip.AddrPort, seq uint32, payload []byte) []byte {
+		tcp := binary.BigEndian.AppendUint16(nil, src.Port())
+		tcp = binary.BigEndian.AppendUint16(tcp, dst.Port())
+		tcp = binary.BigEndian.AppendUint32(tcp, seq)
+		tcp = append(tcp, 0, 0, 0, 0, 5<<4, tcpFlagPSH|tcpFlagACK, 0xFF, 0xFF, 0, 0, 0, 0)
+		ethernet := append(make([]byte, 12), 0x08, 0x00)
+		return append(ethernet, ipPacket(src.Addr(), dst.Addr(), 0, append(tcp, payload...))...)
+	}
+	// The request is split in two segments and its second part retransmitted.
+	packets := [][]byte{
+		segment(client, server, 100, request[:5]),
+		segment(client, server, 105, request[5:]),
+		segment(client, server, 105, request[5:]),
+		segment(server, client, 900, response),
+	}
+	capture := binary.LittleEndian.AppendUint32(nil, 0xA1B2C3D4)
+	capture = append(capture, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 0, 0, 1, 0, 0, 0)
+	for i, packet := range packets {
+		capture = binary.LittleEndian.AppendUint32(capture, uint32(1700000000+i))
+		capture = binary.LittleEndian.AppendUint32(capture, 0)
+		capture = binary.LittleEndian.AppendUint32(capture, uint32(len(packet)))
+		capture = binary.LittleEndian.AppendUint32(capture, uint32(len(packet)))
+		capture = append(capture, packet...)
+	}
+
+	transactions, err := ReadCapture(bytes.NewReader(capture))
+	if err != nil || len(transactions) != 1 {
+		t.Fatalf("expected 1 transaction, got %v, %v", transactions, err)
+	}
+	if !bytes.Equal(transactions[0].Request.Bytes(), request) || !bytes.Equal(transactions[0].Response, response) ||
+		!transactions[0].Time.Equal(time.Unix(1700000001, 0)) {
+		t.Errorf("expected request and response, got %+v", transactions[0])
+	}
+	if _, err = ReadCapture(bytes.NewReader([]byte("not a capture file at all"))); err == nil {
+		t.Errorf("expected format error, got nil")
+	}
+}
+
+func TestReplayRTUCapture(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenRTUOverTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	var buffer bytes.Buffer
+	s.StartCapture(addr, &buffer)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, request := range []*RTUFrame{
+		{SlaveId: 1, Function: 3, Data: []byte{0, 0, 0, 1}},
+		{SlaveId: 1, Function: 8, Data: []byte{0, 0, 0, 0}},
+	} {
+		conn.Write(request.Bytes())
+		if _, err = conn.Read(make([]byte, 16)); err != nil {
+			t.Fatalf("failed to read, got %v", err)
+		}
+	}
+	s.StopCapture(addr)
+
+	transactions, err := ReadCapture(&buffer)
+	if err != nil || len(transactions) != 2 || transactions[1].Response[1] != 0x88 {
+		t.Fatalf("expected 2 transactions, got %v, %v", transactions, err)
+	}
+	replayed := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	replayed.InitSlave(1)
+	replayed.RegisterFunctionHandler(8, func(*Server, Framer) ([]byte, *Exception) { return []byte{0}, &Success })
+	results := replayed.Replay(transactions)
+	if !results[0].Equal() || results[1].Differences[0] != "function: expected 136, got 8" {
+		t.Errorf("expected only a function difference, got %v, %v", results[0], results[1])
+	}
+}
+
+func TestReplayRoutes(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(2)
+	s.WriteTable(2, TableHoldingRegisters, 0, []uint16{20})
+	err := s.SetRoutes([]Route{
+		{Units: UnitRange{10, 10}, Action: RouteTranslate, Unit: 2},
+		{Units: UnitRange{30, 30}, Action: RouteException, Exception: SlaveDeviceBusy},
+		{Units: AllUnits, Action: RouteIgnore},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	var transactions []RecordedTransaction
+	for i, unit := range []uint8{10, 30, 2} {
+		request := &TCPFrame{TransactionIdentifier: uint16(i), Device: unit, Function: 3}
+		request.SetData([]byte{0, 0, 0, 1})
+		var response []byte
+		switch unit {
+		case 10:
+			frame := request.Copy()
+			frame.SetData([]byte{2, 0, 20})
+			response = frame.Bytes()
+		case 30:
+			frame := request.Copy()
+			frame.SetException(&SlaveDeviceBusy)
+			response = frame.Bytes()
+		}
+		transactions = append(transactions, RecordedTransaction{Request: request, Response: response})
+	}
+	for _, result := range s.Replay(transactions) {
+		if !result.Equal() {
+			t.Errorf("expected the routed response, got %s", result)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package modbusserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type (
+	// RouteAction is what a Route does with the requests for its unit IDs.
+	RouteAction string
+	// UnitRange is an inclusive range of unit IDs, written as "5", "10-20" or "*" for all unit IDs.
+	UnitRange struct {
+		First, Last uint8
+	}
+	// Route is an entry of the routing table, applied to the requests for its unit IDs.
+	Route struct {
+		Units  UnitRange   `json:"units" yaml:"units"`
+		Action RouteAction `json:"action" yaml:"action"`
+		// Backend is the name of the backend of RouteForward.
+		Backend string `json:"backend,omitempty" yaml:"backend,omitempty"`
+		// Unit is the unit ID on the backend of RouteForward (the requested one if zero), or the local
+		// slave of RouteTranslate.
+		Unit uint8 `json:"unit,omitempty" yaml:"unit,omitempty"`
+		// Timeout is the response timeout of each attempt of RouteForward, 1s if zero.
+		Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+		// Retries is the number of attempts of RouteForward after the first one.
+		Retries int `json:"retries,omitempty" yaml:"retries,omitempty"`
+		// Exception is the exception code of RouteException.
+		Exception Exception `json:"exception,omitempty" yaml:"exception,omitempty"`
+	}
+)
+
+const (
+	// RouteLocal serves the requests from the local slave with the unit ID.
+	RouteLocal RouteAction = "local"
+	// RouteForward forwards the requests to a backend.
+	RouteForward RouteAction = "forward"
+	// RouteTranslate serves the requests from the local slave Unit.
+	RouteTranslate RouteAction = "translate"
+	// RouteException answers the requests with Exception.
+	RouteException RouteAction = "exception"
+	// RouteIgnore doesn't answer the requests.
+	RouteIgnore RouteAction = "ignore"
+)
+
+// AllUnits is the wildcard unit range.
+var AllUnits = UnitRange{0, 255}
+
+// Contains returns true if the unit ID is in the range.
+func (r UnitRange) Contains(unit uint8) bool {
+	return r.First <= unit && unit <= r.Last
+}
+
+func (r UnitRange) String() string {
+	switch {
+	case r == AllUnits:
+		return "*"
+	case r.First == r.Last:
+		return strconv.Itoa(int(r.First))
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// ParseUnitRange parses a unit ID range: "5", "10-20" or "*".
+func ParseUnitRange(text string) (r UnitRange, err error) {
+	if text == "*" {
+		return AllUnits, nil
+	}
+	first, last, isRange := strings.Cut(text, "-")
+	if !isRange {
+		last = first
+	}
+	firstUnit, err := strconv.ParseUint(strings.TrimSpace(first), 10, 8)
+	if err != nil {
+		return r, fmt.Errorf("invalid unit range %q", text)
+	}
+	lastUnit, err := strconv.ParseUint(strings.TrimSpace(last), 10, 8)
+	if err != nil || lastUnit < firstUnit {
+		return r, fmt.Errorf("invalid unit range %q", text)
+	}
+	return UnitRange{uint8(firstUnit), uint8(lastUnit)}, nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (r UnitRange) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (r *UnitRange) UnmarshalText(text []byte) (err error) {
+	*r, err = ParseUnitRange(string(text))
+	return
+}
+
+// UnmarshalJSON implements json.Unmarshaler, also accepting a single unit ID as a number.
+func (r *UnitRange) UnmarshalJSON(data []byte) error {
+	var unit uint8
+	if err := json.Unmarshal(data, &unit); err == nil {
+		*r = UnitRange{unit, unit}
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	return r.UnmarshalText([]byte(text))
+}
+
+// Validate checks the route definition.
+func (r *Route) Validate() error {
+	if r.Units.First > r.Units.Last {
+		return fmt.Errorf("route of units %d-%d: invalid range", r.Units.First, r.Units.Last)
+	}
+	switch r.Action {
+	case RouteForward:
+		if r.Backend == "" {
+			return fmt.Errorf("route of units %s: forward needs a backend", r.Units)
+		}
+		if r.Timeout < 0 || r.Retries < 0 {
+			return fmt.Errorf("route of units %s: negative timeout or retries", r.Units)
+		}
+	case RouteException:
+		if r.Exception == Success {
+			return fmt.Errorf("route of units %s: exception needs an exception code", r.Units)
+		}
+	case RouteLocal, RouteTranslate, RouteIgnore:
+	default:
+		return fmt.Errorf("route of units %s: unknown action %q", r.Units, r.Action)
+	}
+	return nil
+}
+
+// LoadRoutesFile reads a routing table from a JSON or YAML file, chosen by extension.
+// Unknown fields are errors.
+func LoadRoutesFile(path string) ([]Route, error) {
+	return loadConfigFile[[]Route](path, "routes")
+}
+
+// SetRoutes replaces the routing table. A request is routed by the first route containing its unit ID;
+// a last route for AllUnits is the default. Without a matching route, requests are served by the local
+// slave, or get GatewayPathUnavailable if there is no such slave and a backend was added. The table can
+// be changed while the server runs, without closing connections. All errors are reported together.
+func (s *Server) SetRoutes(routes []Route) error {
+	s.routingMutex.Lock()
+	defer s.routingMutex.Unlock()
+	var errs []error
+	for _, route := range routes {
+		if err := route.Validate(); err != nil {
+			errs = append(errs, err)
+		} else if _, ok := s.backends[route.Backend]; route.Action == RouteForward && !ok {
+			errs = append(errs, fmt.Errorf("route of units %s: unknown backend %q", route.Units, route.Backend))
+		}
+	}
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+	s.routes = slices.Clone(routes)
+	return nil
+}
+
+// Routes returns the routing table.
+func (s *Server) Routes() []Route {
+	s.routingMutex.RLock()
+	defer s.routingMutex.RUnlock()
+	return slices.Clone(s.routes)
+}
+
+// route returns the route of the unit ID.
+func (s *Server) route(unit uint8) Route {
+	s.routingMutex.RLock()
+	defer s.routingMutex.RUnlock()
+	for _, route := range s.routes {
+		if route.Units.Contains(unit) {
+			return route
+		}
+	}
+	if len(s.backends) != 0 {
+		s.memoryMutex.RLock()
+		defer s.memoryMutex.RUnlock()
+		if _, ok := s.Slaves[unit]; !ok {
+			return Route{Units: UnitRange{unit, unit}, Action: RouteException, Exception: GatewayPathUnavailable}
+		}
+	}
+	return Route{Units: UnitRange{unit, unit}, Action: RouteLocal}
+}
+
+// routeResponds reports whether requests for the unit ID are answered.
+func (s *Server) routeResponds(unit uint8) bool {
+	switch route := s.route(unit); route.Action {
+	case RouteIgnore:
+		return false
+	case RouteLocal:
+		return s.slaveResponds(unit)
+	case RouteTranslate:
+		return s.slaveResponds(route.Unit)
+	}
+	return true
+}
+
+// handleRoute returns the response of a request for a local slave, nil if the slave doesn't respond.
+func (s *Server) handleRoute(request *Request, route Route) Framer {
+	unit := request.frame.GetSlaveId()
+	if route.Action == RouteTranslate {
+		unit = route.Unit
+	}
+	if !s.slaveResponds(unit) {
+		s.logger.Warn(fmt.Sprintf("Server %s: invalid slave Id: requested slave Id doesn't initialized or disabled", request.address))
+		return nil
+	}
+	if route.Action != RouteTranslate {
+		return s.handle(request)
+	}
+	translated := *request
+	translated.frame = request.frame.Copy()
+	setSlaveId(translated.frame, unit)
+	response := s.handle(&translated)
+	setSlaveId(response, request.frame.GetSlaveId())
+	return response
+}
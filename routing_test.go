@@ -0,0 +1,186 @@
+package modbusserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestRouting(t *testing.T) {
+	remote := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	remote.InitSlave(5)
+	remote.WriteTable(5, TableHoldingRegisters, 0, []uint16{50})
+	remoteAddr := getFreePort()
+	if err := remote.ListenTCP(remoteAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer remote.Close()
+
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.InitSlave(2)
+	s.WriteTable(1, TableHoldingRegisters, 0, []uint16{10})
+	s.WriteTable(2, TableHoldingRegisters, 0, []uint16{20})
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	s.AddTCPBackend("remote", remoteAddr)
+	err := s.SetRoutes([]Route{
+		{Units: UnitRange{1, 2}, Action: RouteLocal},
+		{Units: UnitRange{10, 10}, Action: RouteTranslate, Unit: 2},
+		{Units: UnitRange{20, 29}, Action: RouteForward, Backend: "remote", Unit: 5, Timeout: Duration(200 * time.Millisecond)},
+		{Units: UnitRange{30, 30}, Action: RouteException, Exception: SlaveDeviceBusy},
+		{Units: AllUnits, Action: RouteIgnore},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.Timeout = 300 * time.Millisecond
+	if err = handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+	check := func(unit uint8, expected uint16, exception Exception) {
+		t.Helper()
+		handler.SlaveId = unit
+		results, err := client.ReadHoldingRegisters(0, 1)
+		var modbusError *modbus.ModbusError
+		switch {
+		case exception != Success:
+			if !errors.As(err, &modbusError) || modbusError.ExceptionCode != uint8(exception) {
+				t.Errorf("unit %d: expected %v, got %v", unit, exception, err)
+			}
+		case expected == 0:
+			if err == nil {
+				t.Errorf("unit %d: expected no response, got %v", unit, results)
+			}
+		case err != nil || binary.BigEndian.Uint16(results) != expected:
+			t.Errorf("unit %d: expected %d, got %v, %v", unit, expected, results, err)
+		}
+	}
+	check(1, 10, Success)
+	check(10, 20, Success)
+	check(25, 50, Success)
+	check(30, 0, SlaveDeviceBusy)
+	check(40, 0, Success)
+
+	// Routes change without closing the connection.
+	s.SetRoutes([]Route{{Units: AllUnits, Action: RouteForward, Backend: "remote", Unit: 5}})
+	check(1, 50, Success)
+	s.RemoveBackend("remote")
+	if routes := s.Routes(); len(routes) != 0 {
+		t.Errorf("expected routes of the backend removed, got %v", routes)
+	}
+	check(2, 20, Success)
+	check(3, 0, Success)
+}
+
+func TestRoutingOrder(t *testing.T) {
+	remote := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	remote.InitSlave(5)
+	remote.SetTurnaround(5, 300*time.Millisecond)
+	remote.WriteTable(5, TableHoldingRegisters, 0, []uint16{50})
+	remoteAddr := getFreePort()
+	if err := remote.ListenTCP(remoteAddr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer remote.Close()
+
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	s.AddTCPBackend("remote", remoteAddr)
+	s.SetRoutes([]Route{
+		{Units: UnitRange{1, 1}, Action: RouteLocal},
+		{Units: UnitRange{5, 5}, Action: RouteForward, Backend: "remote", Unit: 5},
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	// The local request follows the slow forwarded one on the same connection, and is answered after it.
+	for i, unit := range []uint8{5, 1} {
+		request := &TCPFrame{TransactionIdentifier: uint16(i + 1), Device: unit, Function: 3}
+		SetDataWithRegisterAndNumber(request, 0, 1)
+		conn.Write(request.Bytes())
+		// The requests are read separately, the first one after the server waited for the first client.
+		time.Sleep(100 * time.Millisecond)
+	}
+	responses := make([]byte, 22)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = io.ReadFull(conn, responses); err != nil {
+		t.Fatalf("expected 2 responses, got %v", err)
+	}
+	if first, second := binary.BigEndian.Uint16(responses[0:2]), binary.BigEndian.Uint16(responses[11:13]); first != 1 || second != 2 {
+		t.Errorf("expected responses in request order, got transactions %d and %d", first, second)
+	}
+}
+
+func TestRoutesValidation(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	err := s.SetRoutes([]Route{
+		{Units: AllUnits, Action: RouteForward, Backend: "missing"},
+		{Units: AllUnits, Action: RouteException},
+		{Units: AllUnits, Action: "drop"},
+		{Units: UnitRange{5, 1}, Action: RouteLocal},
+	})
+	if err == nil || len(err.(interface{ Unwrap() []error }).Unwrap()) != 4 {
+		t.Errorf("expected 4 errors, got %v", err)
+	}
+
+	for _, c := range []struct {
+		text     string
+		expected UnitRange
+		valid    bool
+	}{
+		{"*", AllUnits, true},
+		{"7", UnitRange{7, 7}, true},
+		{"10-20", UnitRange{10, 20}, true},
+		{"20-10", UnitRange{}, false},
+		{"256", UnitRange{}, false},
+	} {
+		if r, err := ParseUnitRange(c.text); (err == nil) != c.valid || r != c.expected {
+			t.Errorf("%q: expected %v, got %v, %v", c.text, c.expected, r, err)
+		}
+	}
+
+	directory := t.TempDir()
+	files := map[string]string{
+		"routes.json": `[{"units": 1, "action": "local"}, {"units": "2-9", "action": "translate", "unit": 1},
+			{"units": "*", "action": "exception", "exception": 10}]`,
+		"routes.yaml": "- units: 1\n  action: local\n- units: 2-9\n  action: translate\n  unit: 1\n- units: '*'\n  action: exception\n  exception: 10\n",
+	}
+	expected := []Route{
+		{Units: UnitRange{1, 1}, Action: RouteLocal},
+		{Units: UnitRange{2, 9}, Action: RouteTranslate, Unit: 1},
+		{Units: AllUnits, Action: RouteException, Exception: GatewayPathUnavailable},
+	}
+	for name, content := range files {
+		path := filepath.Join(directory, name)
+		os.WriteFile(path, []byte(content), 0o644)
+		routes, err := LoadRoutesFile(path)
+		if err != nil || !slices.Equal(routes, expected) {
+			t.Errorf("%s: expected %v, got %v, %v", name, expected, routes, err)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package modbusserver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Rule sets a slave value from expressions over slave memory, see Reference for the syntax of
+	// addresses. The rule is active while When is true (always if empty); with Delay, When must stay true
+	// for the delay first. A Latch rule stays active after When turns false until Reset is true. An active
+	// rule writes Value (1 if empty) to Set, an inactive one writes Else if given.
+	//
+	// For example: when "hr(1, 5) > 100", set "coil(1, 7)"; or set "ir(1, 3)" to "hr(1, 1) * 0.1".
+	Rule struct {
+		Name  string   `json:"name" yaml:"name"`
+		When  string   `json:"when,omitempty" yaml:"when,omitempty"`
+		Delay Duration `json:"delay,omitempty" yaml:"delay,omitempty"`
+		Latch bool     `json:"latch,omitempty" yaml:"latch,omitempty"`
+		Reset string   `json:"reset,omitempty" yaml:"reset,omitempty"`
+		Set   string   `json:"set" yaml:"set"`
+		Value string   `json:"value,omitempty" yaml:"value,omitempty"`
+		Else  string   `json:"else,omitempty" yaml:"else,omitempty"`
+	}
+	// RulesEngine evaluates rules after every write to slave memory and on every tick. A rule referring to
+	// a missing slave, for example one removed after the engine was created, is stopped and the error is
+	// logged.
+	RulesEngine struct {
+		server      *Server
+		rules       []*compiledRule
+		tick        time.Duration
+		mutex       sync.Mutex
+		evaluating  atomic.Bool
+		closed      atomic.Bool
+		changedChan chan struct{}
+		stopChan    chan struct{}
+		wg          sync.WaitGroup
+	}
+	compiledRule struct {
+		Rule
+		when, reset, value, otherwise expression
+		target                        Reference
+		slaves                        []uint8
+		since                         time.Time
+		latched                       bool
+		stopped                       bool
+	}
+)
+
+// maxRulePasses limits the evaluation passes of chained rules after a change.
+const maxRulePasses = 10
+
+// LoadRulesFile reads rules from a .json or .yaml/.yml file holding a list of rules.
+// Unknown fields are errors.
+func LoadRulesFile(path string) ([]Rule, error) {
+	return loadConfigFile[[]Rule](path, "rules")
+}
+
+// NewRulesEngine compiles the rules and starts evaluating them on every write and every tick
+// (100 ms if zero). All compile errors are reported together. Close must be called to stop the engine.
+func NewRulesEngine(s *Server, rules []Rule, tick time.Duration) (*RulesEngine, error) {
+	if tick <= 0 {
+		tick = 100 * time.Millisecond
+	}
+	engine := &RulesEngine{
+		server:      s,
+		tick:        tick,
+		changedChan: make(chan struct{}, 1),
+		stopChan:    make(chan struct{}),
+	}
+	var errs []error
+	for _, rule := range rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var found []Reference
+		for _, node := range []expression{compiled.when, compiled.reset, compiled.value, compiled.otherwise} {
+			if node != nil {
+				found = references(node, found)
+			}
+		}
+		for _, reference := range append(found, compiled.target) {
+			if !slices.Contains(compiled.slaves, reference.Slave) {
+				compiled.slaves = append(compiled.slaves, reference.Slave)
+			}
+		}
+		engine.rules = append(engine.rules, compiled)
+	}
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+	s.AddWriteHook(engine.onWrite)
+	engine.wg.Add(1)
+	go engine.run()
+	return engine, nil
+}
+
+func compileRule(rule Rule) (compiled *compiledRule, err error) {
+	compiled = &compiledRule{Rule: rule}
+	if rule.Name == "" {
+		return nil, errors.New("rule name is empty")
+	}
+	if rule.Delay < 0 {
+		return nil, fmt.Errorf("rule %s: negative delay", rule.Name)
+	}
+	if rule.Latch && rule.Reset == "" {
+		return nil, fmt.Errorf("rule %s: latch needs a reset expression", rule.Name)
+	}
+	if compiled.target, err = ParseReference(rule.Set); err != nil {
+		return nil, fmt.Errorf("rule %s: set: %w", rule.Name, err)
+	}
+	if rule.Value == "" {
+		rule.Value = "1"
+	}
+	for _, field := range []struct {
+		text string
+		node *expression
+		name string
+	}{
+		{rule.When, &compiled.when, "when"},
+		{rule.Reset, &compiled.reset, "reset"},
+		{rule.Value, &compiled.value, "value"},
+		{rule.Else, &compiled.otherwise, "else"},
+	} {
+		if field.text == "" {
+			continue
+		}
+		if *field.node, err = parseExpression(field.text); err != nil {
+			return nil, fmt.Errorf("rule %s: %s: %w", rule.Name, field.name, err)
+		}
+	}
+	return
+}
+
+// Close stops the engine.
+func (e *RulesEngine) Close() {
+	if e.closed.Swap(true) {
+		return
+	}
+	close(e.stopChan)
+	e.wg.Wait()
+}
+
+// Evaluate runs the rules once, as done after a write or on a tick.
+func (e *RulesEngine) Evaluate() error {
+	return e.evaluate(time.Now())
+}
+
+// onWrite requests an evaluation after writes not made by the engine itself.
+func (e *RulesEngine) onWrite(event WriteEvent) error {
+	if !e.closed.Load() && !e.evaluating.Load() {
+		select {
+		case e.changedChan <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (e *RulesEngine) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.tick)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-e.stopChan:
+			return
+		case now := <-ticker.C:
+			err = e.evaluate(now)
+		case <-e.changedChan:
+			err = e.evaluate(time.Now())
+		}
+		if err != nil {
+			e.server.logger.Error(fmt.Sprintf("Server: rules evaluation error: %s", err.Error()))
+		}
+	}
+}
+
+// evaluate runs the rules in a transaction, repeating while chained rules keep changing values.
+func (e *RulesEngine) evaluate(now time.Time) error {
+	if len(e.rules) == 0 {
+		return nil
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	slaves := e.activeSlaves()
+	if len(slaves) == 0 {
+		return nil
+	}
+	e.evaluating.Store(true)
+	defer e.evaluating.Store(false)
+	return e.server.Update(slaves, func(tx *Transaction) error {
+		var errs []error
+		for pass := 0; pass < maxRulePasses; pass++ {
+			changed := false
+			errs = errs[:0]
+			for _, rule := range e.rules {
+				if rule.stopped {
+					continue
+				}
+				ruleChanged, err := rule.apply(tx, now)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("rule %s: %w", rule.Name, err))
+				}
+				changed = changed || ruleChanged
+			}
+			if !changed {
+				break
+			}
+		}
+		if len(errs) != 0 {
+			e.server.logger.Error(fmt.Sprintf("Server: rules evaluation error: %s", errors.Join(errs...).Error()))
+		}
+		return nil
+	})
+}
+
+// activeSlaves stops the rules referring to missing slaves, so the error is logged once, and returns the
+// slaves referred to by the other rules. The engine mutex must be held.
+func (e *RulesEngine) activeSlaves() (slaves []uint8) {
+	e.server.memoryMutex.RLock()
+	defer e.server.memoryMutex.RUnlock()
+	for _, rule := range e.rules {
+		if rule.stopped {
+			continue
+		}
+		for _, id := range rule.slaves {
+			if _, ok := e.server.Slaves[id]; !ok {
+				rule.stopped = true
+				e.server.logger.Error(fmt.Sprintf("Server: rule %s stopped: slave with %d ID didn't implemented on server", rule.Name, id))
+				break
+			}
+		}
+		if rule.stopped {
+			continue
+		}
+		for _, id := range rule.slaves {
+			if !slices.Contains(slaves, id) {
+				slaves = append(slaves, id)
+			}
+		}
+	}
+	return
+}
+
+// apply evaluates the rule and writes its target if the value changes.
+func (r *compiledRule) apply(tx *Transaction, now time.Time) (changed bool, err error) {
+	condition := true
+	if r.when != nil {
+		value, err := r.when.eval(tx)
+		if err != nil {
+			return false, err
+		}
+		condition = value != 0
+	}
+	active := condition
+	if r.Delay > 0 {
+		if !condition {
+			r.since = time.Time{}
+		} else if r.since.IsZero() {
+			r.since = now
+		}
+		active = condition && now.Sub(r.since) >= time.Duration(r.Delay)
+	}
+	if r.Latch {
+		if active {
+			r.latched = true
+		} else if r.latched {
+			reset, err := r.reset.eval(tx)
+			if err != nil {
+				return false, err
+			}
+			r.latched = reset == 0
+		}
+		active = r.latched
+	}
+	node := r.value
+	if !active {
+		if node = r.otherwise; node == nil {
+			return
+		}
+	}
+	value, err := node.eval(tx)
+	if err != nil {
+		return
+	}
+	value = clampValue(value, r.target.Type)
+	current, err := tx.ReadValue(r.target.Slave, r.target.Table, r.target.Address, r.target.Type, r.target.ByteOrder)
+	if err != nil || current == value {
+		return
+	}
+	if err = tx.WriteValue(r.target.Slave, r.target.Table, r.target.Address, r.target.Type, r.target.ByteOrder, value); err != nil {
+		return
+	}
+	return true, nil
+}
@@ -0,0 +1,218 @@
+package modbusserver
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpressionEval(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.WriteValue(1, TableHoldingRegisters, 5, TypeUint16, OrderABCD, 120)
+	s.WriteValue(1, TableInputRegisters, 10, TypeFloat32, OrderCDAB, -2.5)
+	s.WriteValue(1, TableCoils, 3, TypeBool, OrderABCD, 1)
+
+	cases := []struct {
+		text     string
+		expected float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"7 % 4 + -1", 2},
+		{"hr(1, 5) > 100 && coil(1, 3)", 1},
+		{"hr(1, 5) < 100 || !coil(1, 3)", 0},
+		{"ir(1, 10, float32, CDAB) * 2", -5},
+		{"abs(ir(1, 10, float32, CDAB)) == 2.5", 1},
+		{"max(hr(1, 5), 200) + min(1, 2) + round(1.6)", 203},
+		{"true != false", 1},
+		{"1.5e1 >= 15", 1},
+	}
+	for _, c := range cases {
+		node, err := parseExpression(c.text)
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v", c.text, err)
+			continue
+		}
+		if value, err := node.eval(s); err != nil || value != c.expected {
+			t.Errorf("%s: expected %v, got %v, %v", c.text, c.expected, value, err)
+		}
+	}
+
+	for _, text := range []string{"", "1 +", "hr(1)", "hr(1, 70000)", "coil(1, 2, uint16)", "hr(1, 2, int8)", "foo(1)", "1 & 2", "(1", "1 2"} {
+		if _, err := parseExpression(text); err == nil {
+			t.Errorf("%q: expected error, got nil", text)
+		}
+	}
+	node, _ := parseExpression("1 / (hr(1, 0) - 0)")
+	if _, err := node.eval(s); err == nil {
+		t.Errorf("expected division by zero error, got nil")
+	}
+
+	reference, err := ParseReference("hr(2, 100, int32, DCBA)")
+	expected := Reference{Slave: 2, Table: TableHoldingRegisters, Address: 100, Type: TypeInt32, ByteOrder: OrderDCBA}
+	if err != nil || reference != expected {
+		t.Errorf("expected %v, got %v, %v", expected, reference, err)
+	}
+	if _, err = ParseReference("hr(2, 100) + 1"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestRulesEngine(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	engine, err := NewRulesEngine(s, []Rule{
+		{Name: "alarm", When: "hr(1, 5) > 100", Set: "coil(1, 7)", Else: "0"},
+		{Name: "scaled", Set: "ir(1, 3)", Value: "hr(1, 1) * 0.1"},
+		{Name: "chained", When: "coil(1, 7)", Set: "di(1, 0)", Else: "false"},
+		{Name: "trip", When: "hr(1, 5) > 200", Latch: true, Reset: "coil(1, 0)", Set: "coil(1, 8)", Else: "0"},
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer engine.Close()
+
+	read := func(table Table, address uint16) float64 {
+		dataType := TypeUint16
+		if table == TableCoils || table == TableDiscreteInputs {
+			dataType = TypeBool
+		}
+		value, _ := s.ReadValue(1, table, address, dataType, OrderABCD)
+		return value
+	}
+	s.WriteValue(1, TableHoldingRegisters, 1, TypeUint16, OrderABCD, 456)
+	s.WriteValue(1, TableHoldingRegisters, 5, TypeUint16, OrderABCD, 250)
+	engine.Evaluate()
+	if read(TableCoils, 7) != 1 || read(TableDiscreteInputs, 0) != 1 || read(TableCoils, 8) != 1 || read(TableInputRegisters, 3) != 46 {
+		t.Errorf("expected alarm, chained, trip and scaled value set")
+	}
+
+	s.WriteValue(1, TableHoldingRegisters, 5, TypeUint16, OrderABCD, 50)
+	engine.Evaluate()
+	if read(TableCoils, 7) != 0 || read(TableDiscreteInputs, 0) != 0 {
+		t.Errorf("expected alarm and chained value cleared")
+	}
+	if read(TableCoils, 8) != 1 {
+		t.Errorf("expected trip to stay latched")
+	}
+	s.WriteValue(1, TableCoils, 0, TypeBool, OrderABCD, 1)
+	engine.Evaluate()
+	if read(TableCoils, 8) != 0 {
+		t.Errorf("expected trip reset")
+	}
+
+	// Writes from masters trigger an evaluation without waiting for the tick.
+	s.WriteTable(1, TableHoldingRegisters, 5, []uint16{150})
+	deadline := time.Now().Add(time.Second)
+	for read(TableCoils, 7) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if read(TableCoils, 7) != 1 {
+		t.Errorf("expected alarm set after write")
+	}
+}
+
+func TestRulesEngineDelay(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	engine, err := NewRulesEngine(s, []Rule{
+		{Name: "delayed", When: "hr(1, 0) == 1", Delay: Duration(2 * time.Second), Set: "coil(1, 0)", Else: "0"},
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	engine.Close()
+
+	start := time.Now()
+	s.WriteValue(1, TableHoldingRegisters, 0, TypeUint16, OrderABCD, 1)
+	for _, step := range []struct {
+		at       time.Duration
+		hr       float64
+		expected byte
+	}{
+		{0, 1, 0},
+		{time.Second, 1, 0},
+		{2 * time.Second, 1, 1},
+		{3 * time.Second, 0, 0},
+		{4 * time.Second, 1, 0},
+		{6 * time.Second, 1, 1},
+	} {
+		s.WriteValue(1, TableHoldingRegisters, 0, TypeUint16, OrderABCD, step.hr)
+		engine.evaluate(start.Add(step.at))
+		if got := s.Slaves[1].Coils[0]; got != step.expected {
+			t.Errorf("at %v: expected %v, got %v", step.at, step.expected, got)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	directory := t.TempDir()
+	yamlPath := filepath.Join(directory, "rules.yaml")
+	os.WriteFile(yamlPath, []byte(`
+- name: alarm
+  when: hr(1, 5) > 100
+  delay: 1.5s
+  set: coil(1, 7)
+`), 0o644)
+	jsonPath := filepath.Join(directory, "rules.json")
+	os.WriteFile(jsonPath, []byte(`[{"name": "alarm", "when": "hr(1, 5) > 100", "delay": "1.5s", "set": "coil(1, 7)"}]`), 0o644)
+	expected := Rule{Name: "alarm", When: "hr(1, 5) > 100", Delay: Duration(1500 * time.Millisecond), Set: "coil(1, 7)"}
+	for _, path := range []string{yamlPath, jsonPath} {
+		rules, err := LoadRulesFile(path)
+		if err != nil || len(rules) != 1 || rules[0] != expected {
+			t.Errorf("%s: expected %v, got %v, %v", filepath.Base(path), expected, rules, err)
+		}
+	}
+
+	s := NewServer(slog.Logger{})
+	_, err := NewRulesEngine(s, []Rule{
+		{Name: "no target", When: "1"},
+		{Name: "bad", When: "hr(1, 5) >", Set: "coil(1, 7)"},
+		{Name: "latch", Latch: true, Set: "coil(1, 7)"},
+	}, 0)
+	if err == nil {
+		t.Errorf("expected errors, got nil")
+	}
+}
+
+func TestRulesEngineMissingSlave(t *testing.T) {
+	var logs bytes.Buffer
+	s := NewServer(*slog.New(slog.NewTextHandler(&logs, nil)))
+	s.InitSlave(1)
+	s.InitSlave(2)
+	engine, err := NewRulesEngine(s, []Rule{
+		{Name: "missing", Set: "coil(3, 0)"},
+		{Name: "removed", Set: "coil(2, 0)", Value: "hr(1, 0)"},
+		{Name: "kept", Set: "coil(1, 0)"},
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	engine.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := engine.Evaluate(); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if i == 0 {
+			s.RemoveSlave(2)
+		}
+	}
+	if s.Slaves[1].Coils[0] != 1 {
+		t.Errorf("expected rule of existing slave to be evaluated")
+	}
+	for _, name := range []string{"missing", "removed"} {
+		if count := strings.Count(logs.String(), "rule "+name+" stopped"); count != 1 {
+			t.Errorf("expected rule %s stopped once, logged %d times", name, count)
+		}
+	}
+	if strings.Contains(logs.String(), "kept") {
+		t.Errorf("expected rule kept to run, got %s", logs.String())
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"slices"
 	"strings"
 	"time"
 
@@ -21,9 +20,10 @@ func (s *Server) acceptRTUOverTCP(listen net.Listener) error {
 			if strings.Contains(err.Error(), "use of closed network connection") {
 				return nil
 			}
-			s.logger.Info(fmt.Sprintf("Server %s: unable to accept connections: %s", s.listeners[0].Addr().String(), err.Error()))
+			s.logger.Info(fmt.Sprintf("Server %s: unable to accept connections: %s", listen.Addr().String(), err.Error()))
 			return err
 		}
+		conn = s.emulateNetwork(listen, conn)
 		s.logger.Debug(fmt.Sprintf("Server %s: new connection: type - %s, address - %s",
 			conn.LocalAddr().String(), conn.RemoteAddr().Network(), conn.RemoteAddr().String()))
 		if isFirstClient {
@@ -55,15 +55,16 @@ func (s *Server) acceptRTUOverTCP(listen net.Listener) error {
 			s.logger.Debug(fmt.Sprintf("Server %s: current packet preparing", conn.LocalAddr().String()))
 			frame, err := NewRTUFrame(packet)
 			if err != nil {
+				s.metrics.frameError(transportRTUOverTCP, err)
 				s.logger.Error(fmt.Sprintf("Server %s: current packet preparing error: %s", conn.LocalAddr().String(), err.Error()))
 				continue
 			}
 			slaveID := frame.GetSlaveId()
 			s.logger.Debug(fmt.Sprintf("Server %s: current packet successfully prepared: slave Id = %d", conn.LocalAddr().String(), slaveID))
-			if _, ok := s.Slaves[slaveID]; ok && !slices.Contains(s.SlavesStoppedResponse, slaveID) {
-				request := &Request{conn, frame}
+			if s.routeResponds(slaveID) {
+				request := &Request{conn, frame, listen.Addr().String()}
 				s.logger.Debug(fmt.Sprintf("Server %s: current request successfully starts procesing", conn.LocalAddr().String()))
-				s.requestChan <- request
+				s.enqueue(request)
 			} else {
 				s.logger.Warn(fmt.Sprintf("Server %s: invalid slave Id: requested slave Id doesn't initialized or disabled", conn.LocalAddr().String()))
 			}
@@ -76,7 +77,7 @@ func (s *Server) acceptRTUOverTCP(listen net.Listener) error {
 func (s *Server) ListenRTUOverTCP(addressPort string) (err error) {
 	listen, err := reuse.Listen("tcp", addressPort)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Server %s: Failed to Listen: %s", s.listeners[0].Addr().String(), err.Error()))
+		s.logger.Error(fmt.Sprintf("Server %s: Failed to Listen: %s", addressPort, err.Error()))
 		return err
 	}
 	s.listeners = append(s.listeners, listen)
@@ -6,8 +6,10 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"net/http"
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/goburrow/serial"
 	"golang.org/x/exp/maps"
@@ -25,6 +27,8 @@ type (
 	Request struct {
 		conn  io.ReadWriteCloser
 		frame Framer
+		// address is the address of the listener or serial device that received the request.
+		address string
 	}
 	Server struct {
 		// Debug enables more verbose messaging.
@@ -35,17 +39,85 @@ type (
 		portsCloseChan        chan struct{}
 		requestChan           chan *Request
 		ConnectionChanel      chan bool
+		functionMutex         sync.RWMutex
 		function              [256](func(*Server, Framer) ([]byte, *Exception))
+		registered            [256]bool
+		slaveHooks            map[uint8][]*slaveHook
 		Slaves                map[uint8]SlaveData
 		SlavesStoppedResponse []uint8
 		logger                slog.Logger
+		writeRules            map[uint8][]WriteRule
+		writeRulesMutex       sync.RWMutex
+		aliasRules            map[uint8]uint8
+		memoryMutex           sync.RWMutex
+		writeHooks            []func(WriteEvent) error
+		writeLog              *writeLog
+		snapshotsMutex        sync.Mutex
+		snapshotsStopChan     chan struct{}
+		snapshotsWG           sync.WaitGroup
+		slaveAliases          map[uint8]slaveAlias
+		aliasWindows          []AliasWindow
+		identification        map[uint8]map[uint8]string
+		faults                []*activeFault
+		faultsMutex           sync.Mutex
+		networkMutex          sync.RWMutex
+		listenerConditions    map[string]NetworkConditions
+		connectionConditions  map[string]NetworkConditions
+		connections           map[*emulatedConn]struct{}
+		timingMutex           sync.RWMutex
+		turnarounds           map[uint8]time.Duration
+		rtuPacing             map[string]time.Duration
+		serialBaudRates       map[string]int
+		serialCharacterTimes  map[string]time.Duration
+		captureMutex          sync.Mutex
+		captures              map[string]*capture
+		routingMutex          sync.RWMutex
+		sendingMutex          sync.Mutex
+		sending               map[io.ReadWriteCloser]chan struct{}
+		routes                []Route
+		backends              map[string]backend
+		httpMutex             sync.Mutex
+		httpServers           []*http.Server
+		eventsMutex           sync.Mutex
+		events                *eventLog
+		metrics               *serverMetrics
 	}
+	// slaveHook handles the requests for a slave before its function handlers, without the memory lock. It
+	// returns false to leave the request to the function handler.
+	slaveHook struct {
+		handle func(frame Framer) (data []byte, exception *Exception, handled bool)
+	}
+	// Table identifies one of the four Modbus memory tables of a slave.
+	Table uint8
+)
+
+const (
+	TableCoils Table = iota
+	TableDiscreteInputs
+	TableHoldingRegisters
+	TableInputRegisters
 )
 
 // NewServer creates a new Modbus server (slave).
 func NewServer(logger slog.Logger) *Server {
 	s := &Server{}
 	s.Slaves = make(map[uint8]SlaveData)
+	s.writeRules = make(map[uint8][]WriteRule)
+	s.slaveAliases = make(map[uint8]slaveAlias)
+	s.aliasRules = make(map[uint8]uint8)
+	s.identification = make(map[uint8]map[uint8]string)
+	s.listenerConditions = make(map[string]NetworkConditions)
+	s.connectionConditions = make(map[string]NetworkConditions)
+	s.connections = make(map[*emulatedConn]struct{})
+	s.turnarounds = make(map[uint8]time.Duration)
+	s.rtuPacing = make(map[string]time.Duration)
+	s.serialBaudRates = make(map[string]int)
+	s.serialCharacterTimes = make(map[string]time.Duration)
+	s.captures = make(map[string]*capture)
+	s.backends = make(map[string]backend)
+	s.sending = make(map[io.ReadWriteCloser]chan struct{})
+	s.slaveHooks = make(map[uint8][]*slaveHook)
+	s.metrics = newServerMetrics()
 
 	// Add default functions.
 	s.function[1] = ReadCoils
@@ -56,6 +128,7 @@ func NewServer(logger slog.Logger) *Server {
 	s.function[6] = WriteHoldingRegister
 	s.function[15] = WriteMultipleCoils
 	s.function[16] = WriteHoldingRegisters
+	s.function[43] = ReadDeviceIdentification
 
 	s.requestChan = make(chan *Request)
 	s.portsCloseChan = make(chan struct{})
@@ -67,28 +140,87 @@ func NewServer(logger slog.Logger) *Server {
 }
 
 // RegisterFunctionHandler override the default behavior for a given Modbus function.
+//
+// Registered handlers run without the memory lock, so they access slave memory through the Server
+// methods (ReadTable, WriteTable, WriteChecked, Begin, Update...) and not through Slaves.
 func (s *Server) RegisterFunctionHandler(funcCode uint8, function func(*Server, Framer) ([]byte, *Exception)) {
-	s.function[funcCode] = function
+	s.functionMutex.Lock()
+	defer s.functionMutex.Unlock()
+	s.function[funcCode], s.registered[funcCode] = function, true
+}
+
+// addSlaveHook adds a hook handling the requests for the slave before its function handlers.
+func (s *Server) addSlaveHook(id uint8, handle func(Framer) ([]byte, *Exception, bool)) *slaveHook {
+	s.functionMutex.Lock()
+	defer s.functionMutex.Unlock()
+	hook := &slaveHook{handle: handle}
+	s.slaveHooks[id] = append(s.slaveHooks[id], hook)
+	return hook
+}
+
+// removeSlaveHook removes a hook added for the slave.
+func (s *Server) removeSlaveHook(id uint8, hook *slaveHook) {
+	s.functionMutex.Lock()
+	defer s.functionMutex.Unlock()
+	if s.slaveHooks[id] = slices.DeleteFunc(s.slaveHooks[id], func(current *slaveHook) bool { return current == hook }); len(s.slaveHooks[id]) == 0 {
+		delete(s.slaveHooks, id)
+	}
 }
 
 func (s *Server) handle(request *Request) Framer {
+	response := s.respond(request.frame)
+	if response == nil {
+		s.logger.Warn(fmt.Sprintf("Server %s: slave %d was removed before its request was handled", request.address, request.frame.GetSlaveId()))
+		return nil
+	}
+	s.logger.Debug(fmt.Sprintf("Server %s: current response: %v", request.address, response))
+	return response
+}
+
+// respond runs the slave hooks and the function handler of the request frame. The default handlers run
+// with the memory locked, the hooks and the registered handlers without it. It returns nil if the slave
+// was removed meanwhile.
+func (s *Server) respond(frame Framer) Framer {
 	var exception *Exception
 	var data []byte
 
-	response := request.frame.Copy()
+	response := frame.Copy()
 
-	function := request.frame.GetFunction()
-	if s.function[function] != nil {
-		data, exception = s.function[function](s, request.frame)
+	function := frame.GetFunction()
+	s.functionMutex.RLock()
+	handler, registered := s.function[function], s.registered[function]
+	hooks := slices.Clone(s.slaveHooks[frame.GetSlaveId()])
+	s.functionMutex.RUnlock()
+	handled := false
+	for _, hook := range hooks {
+		if data, exception, handled = hook.handle(frame); handled {
+			break
+		}
+	}
+	switch {
+	case handled:
 		response.SetData(data)
-	} else {
+	case handler == nil:
 		exception = &IllegalFunction
+	case registered:
+		data, exception = handler(s, frame)
+		response.SetData(data)
+	default:
+		s.memoryMutex.Lock()
+		_, ok := s.Slaves[frame.GetSlaveId()]
+		if ok {
+			data, exception = handler(s, frame)
+		}
+		s.memoryMutex.Unlock()
+		if !ok {
+			return nil
+		}
+		response.SetData(data)
 	}
 
 	if exception != &Success {
 		response.SetException(exception)
 	}
-	s.logger.Debug(fmt.Sprintf("Server %s: current response: %v", s.listeners[0].Addr().String(), response))
 	return response
 }
 
@@ -96,16 +228,110 @@ func (s *Server) handle(request *Request) Framer {
 func (s *Server) handler() {
 	for {
 		request := <-s.requestChan
-		s.logger.Debug(fmt.Sprintf("Server %s: current request: %v", s.listeners[0].Addr().String(), request))
-		response := s.handle(request)
-		if _, err := request.conn.Write(response.Bytes()); err != nil {
-			s.logger.Error(fmt.Sprintf("Server %s: error on writting response: %s", s.listeners[0].Addr().String(), err.Error()))
+		received := time.Now()
+		s.metrics.received(request)
+		s.capturePacket(request, request.frame.Bytes(), true)
+		s.logger.Debug(fmt.Sprintf("Server %s: current request: %v", request.address, request))
+		faults := s.injectFaults(request.frame)
+		if respond, forwarded := s.routeRequest(request, faults); respond != nil {
+			s.sendInOrder(request, respond, faults, received, forwarded)
+		}
+	}
+}
+
+// routeRequest returns the function computing the response to the request according to the injected
+// faults and the routing table, nil if the request is ignored, and whether the request is forwarded.
+func (s *Server) routeRequest(request *Request, faults injectedFaults) (respond func() Framer, forwarded bool) {
+	exceptionResponse := func(exception *Exception) func() Framer {
+		return func() Framer {
+			response := request.frame.Copy()
+			response.SetException(exception)
+			return response
+		}
+	}
+	if faults.exception != nil {
+		return exceptionResponse(faults.exception), false
+	}
+	switch route := s.route(request.frame.GetSlaveId()); route.Action {
+	case RouteForward:
+		return func() Framer { return s.forward(request.frame, route) }, true
+	case RouteException:
+		return exceptionResponse(&route.Exception), false
+	case RouteIgnore:
+		return nil, false
+	default:
+		return func() Framer { return s.handleRoute(request, route) }, false
+	}
+}
+
+// sendInOrder sends the response returned by respond. Local requests are processed in the order they
+// are received, but forwarded requests and delayed responses are sent by a goroutine, like the following
+// responses of the same connection while one is pending, so a slow response only holds back its own
+// connection and the responses of a connection keep the order of its requests.
+func (s *Server) sendInOrder(request *Request, respond func() Framer, faults injectedFaults, received time.Time, forwarded bool) {
+	var response Framer
+	if !forwarded {
+		response = respond()
+	}
+	s.sendingMutex.Lock()
+	previous, pending := s.sending[request.conn]
+	if !forwarded && !pending && !s.delayed(request, faults) {
+		s.sendingMutex.Unlock()
+		s.send(request, response, faults, received)
+		return
+	}
+	done := make(chan struct{})
+	s.sending[request.conn] = done
+	s.sendingMutex.Unlock()
+	go func() {
+		defer close(done)
+		if pending {
+			<-previous
+		}
+		if forwarded {
+			response = respond()
+		}
+		s.send(request, response, faults, received)
+		s.sendingMutex.Lock()
+		if s.sending[request.conn] == done {
+			delete(s.sending, request.conn)
+		}
+		s.sendingMutex.Unlock()
+	}()
+}
+
+// delayed returns true if the response to the request is delayed by a fault, a turnaround time or RTU
+// pacing.
+func (s *Server) delayed(request *Request, faults injectedFaults) bool {
+	return faults.delay > 0 || s.Turnaround(request.frame.GetSlaveId()) > 0 || s.transmissionTime(request, nil) > 0
+}
+
+// send writes the response to the request, if any, applying the injected faults and response timing.
+func (s *Server) send(request *Request, response Framer, faults injectedFaults, received time.Time) {
+	if response == nil {
+		return
+	}
+	// Packets are sent when they would be completely received from a real device.
+	sendAt := received.Add(faults.delay + s.Turnaround(request.frame.GetSlaveId()))
+	for _, packet := range faults.packets(response) {
+		sendAt = maxTime(sendAt, time.Now()).Add(s.transmissionTime(request, packet))
+		time.Sleep(time.Until(sendAt))
+		s.capturePacket(request, packet, false)
+		if _, err := request.conn.Write(packet); err != nil {
+			s.logger.Error(fmt.Sprintf("Server %s: error on writting response: %s", request.address, err.Error()))
 		}
-		s.logger.Debug(fmt.Sprintf("Server %s: current response successfully sended: %v", s.listeners[0].Addr().String(), response))
 	}
+	s.metrics.sent(request, response, received)
+	s.logger.Debug(fmt.Sprintf("Server %s: current response successfully sended: %v", request.address, response))
 }
 
 func (s *Server) InitSlave(id uint8) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	s.initSlave(id)
+}
+
+func (s *Server) initSlave(id uint8) {
 	if _, ok := s.Slaves[id]; ok {
 		return
 	}
@@ -114,7 +340,28 @@ func (s *Server) InitSlave(id uint8) {
 	s.Slaves[id] = slave
 }
 
+// RemoveSlave deletes the slave with its memory, write rules, alias windows and identification. Aliases
+// of the slave keep the memory they share.
+func (s *Server) RemoveSlave(id uint8) (err error) {
+	s.RemoveAliases(id)
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	if _, ok := s.Slaves[id]; !ok {
+		err = fmt.Errorf("slave with %d ID didn't implemented on server (must be in %v)", id, maps.Keys(s.Slaves))
+		return
+	}
+	delete(s.Slaves, id)
+	delete(s.identification, id)
+	s.SlavesStoppedResponse = slices.DeleteFunc(s.SlavesStoppedResponse, func(stopped uint8) bool { return stopped == id })
+	s.writeRulesMutex.Lock()
+	delete(s.writeRules, id)
+	s.writeRulesMutex.Unlock()
+	return
+}
+
 func (s *Server) SlaveStopResponse(id uint8) (err error) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
 	if _, ok := s.Slaves[id]; !ok {
 		err = fmt.Errorf("slave with %d ID didn't implemented on server (must be in %v)", id, maps.Keys(s.Slaves))
 		return
@@ -123,10 +370,13 @@ func (s *Server) SlaveStopResponse(id uint8) (err error) {
 		return
 	}
 	s.SlavesStoppedResponse = append(s.SlavesStoppedResponse, id)
+	s.emit(Event{Type: EventSlaveStop, Slave: &id})
 	return
 }
 
 func (s *Server) SlaveStartResponse(id uint8) (err error) {
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
 	if _, ok := s.Slaves[id]; !ok {
 		err = fmt.Errorf("slave with %d ID didn't implemented on server (must be in %v)", id, maps.Keys(s.Slaves))
 		return
@@ -136,6 +386,7 @@ func (s *Server) SlaveStartResponse(id uint8) (err error) {
 	}
 	removeIndex := slices.Index(s.SlavesStoppedResponse, id)
 	s.SlavesStoppedResponse = append(s.SlavesStoppedResponse[:removeIndex], s.SlavesStoppedResponse[removeIndex+1:]...)
+	s.emit(Event{Type: EventSlaveStart, Slave: &id})
 	return
 }
 
@@ -150,6 +401,48 @@ func (s *Server) Close() {
 	for _, port := range s.ports {
 		port.Close()
 	}
+	s.StopPeriodicSnapshots()
+	s.CloseWriteLog()
+	s.StopCaptures()
+	s.closeBackends()
+	s.closeHTTP()
+	s.closeEvents()
+}
+
+func (t Table) String() string {
+	switch t {
+	case TableCoils:
+		return "coils"
+	case TableDiscreteInputs:
+		return "discrete_inputs"
+	case TableHoldingRegisters:
+		return "holding_registers"
+	case TableInputRegisters:
+		return "input_registers"
+	}
+	return "unknown"
+}
+
+// ParseTable converts a table name ("coils", "discrete_inputs", "holding_registers", "input_registers") to a Table.
+func ParseTable(name string) (t Table, err error) {
+	for t = TableCoils; t <= TableInputRegisters; t++ {
+		if t.String() == name {
+			return
+		}
+	}
+	err = fmt.Errorf("unknown table %q", name)
+	return
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t Table) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *Table) UnmarshalText(text []byte) (err error) {
+	*t, err = ParseTable(string(text))
+	return
 }
 
 func (sD *SlaveData) AllocateMemory() {
@@ -1,7 +1,9 @@
 package modbusserver
 
 import (
+	"io"
 	"log/slog"
+	"slices"
 	"testing"
 	"time"
 
@@ -138,3 +140,61 @@ func TestModbus(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+func TestRegisteredHandlerUsesServerMethods(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.WriteTable(1, TableHoldingRegisters, 0, []uint16{7, 8})
+	// The handler doubles the registers, reading and writing them through the Server methods.
+	s.RegisterFunctionHandler(3, func(s *Server, frame Framer) ([]byte, *Exception) {
+		address, quantity, _ := registerAddressAndNumber(frame)
+		values, err := s.ReadTable(frame.GetSlaveId(), TableHoldingRegisters, uint16(address), quantity)
+		if err != nil {
+			return []byte{}, &IllegalDataAddress
+		}
+		for i := range values {
+			values[i] *= 2
+		}
+		if err = s.WriteTable(frame.GetSlaveId(), TableHoldingRegisters, uint16(address), values); err != nil {
+			return []byte{}, &SlaveDeviceFailure
+		}
+		return append([]byte{byte(2 * quantity)}, Uint16ToBytes(values)...), &Success
+	})
+	address := getFreePort()
+	if err := s.ListenTCP(address); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	handler := modbus.NewTCPClientHandler(address)
+	handler.Timeout = time.Second
+	handler.SlaveId = 1
+	defer handler.Close()
+	results, err := modbus.NewClient(handler).ReadHoldingRegisters(0, 2)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if expect := []byte{0, 14, 0, 16}; !slices.Equal(expect, results) {
+		t.Errorf("expected %v, got %v", expect, results)
+	}
+	if values, _ := s.ReadTable(1, TableHoldingRegisters, 0, 2); !slices.Equal(values, []uint16{14, 16}) {
+		t.Errorf("expected registers written, got %v", values)
+	}
+}
+
+func TestRespondRemovedSlave(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	frame := &TCPFrame{Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	if !s.slaveResponds(1) {
+		t.Fatal("expected slave 1 to respond")
+	}
+	// The slave is removed between the routing of the request and its handling.
+	if err := s.RemoveSlave(1); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if response := s.handle(&Request{frame: frame}); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+}
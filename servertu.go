@@ -1,31 +1,43 @@
 package modbusserver
 
 import (
+	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/goburrow/serial"
 )
 
+// serialReadTimeout is the read timeout of serial devices configured without one.
+const serialReadTimeout = 500 * time.Millisecond
+
 // ListenRTU starts the Modbus server listening to a serial device.
 // For example:  err := s.ListenRTU(&serial.Config{Address: "/dev/ttyUSB0"})
+// Without a Timeout, the device is read with a 500ms timeout, so Close doesn't wait for a frame.
 func (s *Server) ListenRTU(serialConfig *serial.Config) (err error) {
+	if serialConfig.Timeout <= 0 {
+		config := *serialConfig
+		config.Timeout = serialReadTimeout
+		serialConfig = &config
+	}
 	port, err := serial.Open(serialConfig)
 	if err != nil {
-		log.Fatalf("failed to open %s: %v\n", serialConfig.Address, err)
+		return fmt.Errorf("failed to open %s: %w", serialConfig.Address, err)
 	}
 	s.ports = append(s.ports, port)
+	s.registerSerialTiming(serialConfig)
 
 	s.portsWG.Add(1)
 	go func() {
 		defer s.portsWG.Done()
-		s.acceptSerialRequests(port)
+		s.acceptSerialRequests(port, serialConfig.Address)
 	}()
 
 	return err
 }
 
-func (s *Server) acceptSerialRequests(port serial.Port) {
+func (s *Server) acceptSerialRequests(port serial.Port, address string) {
 	SkipFrameError:
 	for {
 		select {
@@ -37,6 +49,9 @@ func (s *Server) acceptSerialRequests(port serial.Port) {
 		buffer := make([]byte, 512)
 
 		bytesRead, err := port.Read(buffer)
+		if err == serial.ErrTimeout {
+			continue
+		}
 		if err != nil {
 			if err != io.EOF {
 				log.Printf("serial read error %v\n", err)
@@ -51,6 +66,7 @@ func (s *Server) acceptSerialRequests(port serial.Port) {
 
 			frame, err := NewRTUFrame(packet)
 			if err != nil {
+				s.metrics.frameError(transportRTU, err)
 				log.Printf("bad serial frame error %v\n", err)
 				//The next line prevents RTU server from exiting when it receives a bad frame. Simply discard the erroneous 
 				//frame and wait for next frame by jumping back to the beginning of the 'for' loop.
@@ -59,9 +75,9 @@ func (s *Server) acceptSerialRequests(port serial.Port) {
 				//return
 			}
 
-			request := &Request{port, frame}
+			request := &Request{port, frame, address}
 
-			s.requestChan <- request
+			s.enqueue(request)
 		}
 	}
 }
@@ -7,7 +7,6 @@ import (
 	"io"
 	"log"
 	"net"
-	"slices"
 	"strings"
 	"time"
 
@@ -23,9 +22,10 @@ func (s *Server) accept(listen net.Listener) error {
 			if strings.Contains(err.Error(), "use of closed network connection") {
 				return nil
 			}
-			s.logger.Info(fmt.Sprintf("Server %s: unable to accept connections: %s", s.listeners[0].Addr().String(), err.Error()))
+			s.logger.Info(fmt.Sprintf("Server %s: unable to accept connections: %s", listen.Addr().String(), err.Error()))
 			return err
 		}
+		conn = s.emulateNetwork(listen, conn)
 		s.logger.Debug(fmt.Sprintf("Server %s: new connection: type - %s, address - %s",
 			conn.LocalAddr().String(), conn.RemoteAddr().Network(), conn.RemoteAddr().String()))
 		if isFirstClient {
@@ -57,15 +57,16 @@ func (s *Server) accept(listen net.Listener) error {
 			s.logger.Debug(fmt.Sprintf("Server %s: current packet preparing", conn.LocalAddr().String()))
 			frame, err := NewTCPFrame(packet)
 			if err != nil {
+				s.metrics.frameError(transportTCP, err)
 				s.logger.Error(fmt.Sprintf("Server %s: current packet preparing error: %s", conn.LocalAddr().String(), err.Error()))
 				continue
 			}
 			slaveID := frame.GetSlaveId()
 			s.logger.Debug(fmt.Sprintf("Server %s: current packet successfully prepared: slave Id = %d", conn.LocalAddr().String(), slaveID))
-			if _, ok := s.Slaves[slaveID]; ok && !slices.Contains(s.SlavesStoppedResponse, slaveID) {
-				request := &Request{conn, frame}
+			if s.routeResponds(slaveID) {
+				request := &Request{conn, frame, listen.Addr().String()}
 				s.logger.Debug(fmt.Sprintf("Server %s: current request successfully starts procesing", conn.LocalAddr().String()))
-				s.requestChan <- request
+				s.enqueue(request)
 			} else {
 				s.logger.Warn(fmt.Sprintf("Server %s: invalid slave Id: requested slave Id doesn't initialized or disabled", conn.LocalAddr().String()))
 			}
@@ -79,7 +80,7 @@ func (s *Server) accept(listen net.Listener) error {
 func (s *Server) ListenTCP(addressPort string) (err error) {
 	listen, err := reuse.Listen("tcp", addressPort)
 	if err != nil {
-		s.logger.Error(fmt.Sprintf("Server %s: Failed to listen: %s", s.listeners[0].Addr().String(), err.Error()))
+		s.logger.Error(fmt.Sprintf("Server %s: Failed to listen: %s", addressPort, err.Error()))
 		return err
 	}
 	s.listeners = append(s.listeners, listen)
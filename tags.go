@@ -0,0 +1,280 @@
+package modbusserver
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Tag names a typed value stored at a slave address. Engineering value = raw value * Scale + Offset.
+	Tag struct {
+		Name        string    `json:"name" yaml:"name"`
+		Slave       uint8     `json:"slave" yaml:"slave"`
+		Table       Table     `json:"table" yaml:"table"`
+		Address     uint16    `json:"address" yaml:"address"`
+		Type        DataType  `json:"type" yaml:"type"`
+		ByteOrder   ByteOrder `json:"byte_order,omitempty" yaml:"byte_order,omitempty"`
+		Scale       float64   `json:"scale,omitempty" yaml:"scale,omitempty"`
+		Offset      float64   `json:"offset,omitempty" yaml:"offset,omitempty"`
+		Units       string    `json:"units,omitempty" yaml:"units,omitempty"`
+		Description string    `json:"description,omitempty" yaml:"description,omitempty"`
+	}
+	// TagDatabase maps tag names to the memory of the server slaves.
+	TagDatabase struct {
+		server *Server
+		tags   map[string]Tag
+		names  []string
+		mutex  sync.RWMutex
+	}
+)
+
+// NewTagDatabase creates an empty tag database for the server.
+func NewTagDatabase(s *Server) *TagDatabase {
+	return &TagDatabase{server: s, tags: make(map[string]Tag)}
+}
+
+// End returns the address following the last register (or bit) of the tag.
+func (t *Tag) End() int {
+	return int(t.Address) + t.Type.Registers()
+}
+
+func (t *Tag) scale() float64 {
+	if t.Scale == 0 {
+		return 1
+	}
+	return t.Scale
+}
+
+// Validate checks the tag definition itself, without comparing it to other tags.
+func (t *Tag) Validate() error {
+	if t.Name == "" {
+		return errors.New("tag name is empty")
+	}
+	if err := t.Type.Validate(); err != nil {
+		return fmt.Errorf("tag %s: %w", t.Name, err)
+	}
+	if err := t.ByteOrder.Validate(); err != nil {
+		return fmt.Errorf("tag %s: %w", t.Name, err)
+	}
+	if err := checkBitType(t.Table, t.Type); err != nil {
+		return fmt.Errorf("tag %s: %w", t.Name, err)
+	}
+	if t.End() > 65536 {
+		return fmt.Errorf("tag %s: address %d out of range", t.Name, t.Address)
+	}
+	return nil
+}
+
+func (t *Tag) overlaps(other *Tag) bool {
+	return t.Slave == other.Slave && t.Table == other.Table && int(t.Address) < other.End() && int(other.Address) < t.End()
+}
+
+// Add adds tags to the database. All conflicts (invalid definitions, duplicate names, overlapping
+// addresses) are reported together and no tag is added if any is found.
+func (db *TagDatabase) Add(tags ...Tag) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	var errs []error
+	added := make([]Tag, 0, len(tags))
+	for _, tag := range tags {
+		if err := tag.Validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, ok := db.tags[tag.Name]; ok || slices.ContainsFunc(added, func(other Tag) bool { return other.Name == tag.Name }) {
+			errs = append(errs, fmt.Errorf("tag %s: duplicate name", tag.Name))
+			continue
+		}
+		for _, name := range db.names {
+			if other := db.tags[name]; tag.overlaps(&other) {
+				errs = append(errs, fmt.Errorf("tag %s: overlaps tag %s", tag.Name, other.Name))
+			}
+		}
+		for _, other := range added {
+			if tag.overlaps(&other) {
+				errs = append(errs, fmt.Errorf("tag %s: overlaps tag %s", tag.Name, other.Name))
+			}
+		}
+		added = append(added, tag)
+	}
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+	for _, tag := range added {
+		db.tags[tag.Name] = tag
+		db.names = append(db.names, tag.Name)
+	}
+	return nil
+}
+
+// Remove deletes the tag from the database.
+func (db *TagDatabase) Remove(name string) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if _, ok := db.tags[name]; !ok {
+		return
+	}
+	delete(db.tags, name)
+	db.names = slices.DeleteFunc(db.names, func(current string) bool { return current == name })
+}
+
+// Tag returns the tag definition by name.
+func (db *TagDatabase) Tag(name string) (tag Tag, ok bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	tag, ok = db.tags[name]
+	return
+}
+
+// Tags returns all tag definitions in the order they were added.
+func (db *TagDatabase) Tags() []Tag {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	tags := make([]Tag, len(db.names))
+	for i, name := range db.names {
+		tags[i] = db.tags[name]
+	}
+	return tags
+}
+
+// Get returns the tag value in engineering units.
+func (db *TagDatabase) Get(name string) (value float64, err error) {
+	tag, ok := db.Tag(name)
+	if !ok {
+		err = fmt.Errorf("unknown tag %s", name)
+		return
+	}
+	raw, err := db.server.ReadValue(tag.Slave, tag.Table, tag.Address, tag.Type, tag.ByteOrder)
+	if err != nil {
+		err = fmt.Errorf("tag %s: %w", name, err)
+		return
+	}
+	value = raw*tag.scale() + tag.Offset
+	return
+}
+
+// Set writes the tag value given in engineering units.
+func (db *TagDatabase) Set(name string, value float64) (err error) {
+	tag, ok := db.Tag(name)
+	if !ok {
+		err = fmt.Errorf("unknown tag %s", name)
+		return
+	}
+	if err = db.server.WriteValue(tag.Slave, tag.Table, tag.Address, tag.Type, tag.ByteOrder, (value-tag.Offset)/tag.scale()); err != nil {
+		err = fmt.Errorf("tag %s: %w", name, err)
+	}
+	return
+}
+
+// LoadFile loads tags from a .json, .yaml/.yml or .csv file.
+func (db *TagDatabase) LoadFile(path string) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = db.LoadJSON(file)
+	case ".yaml", ".yml":
+		err = db.LoadYAML(file)
+	case ".csv":
+		err = db.LoadCSV(file)
+	default:
+		err = fmt.Errorf("unsupported tag file extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+	}
+	return
+}
+
+// LoadJSON loads tags from a JSON array of tag objects.
+func (db *TagDatabase) LoadJSON(reader io.Reader) (err error) {
+	var tags []Tag
+	if err = json.NewDecoder(reader).Decode(&tags); err != nil {
+		return
+	}
+	return db.Add(tags...)
+}
+
+// LoadYAML loads tags from a YAML sequence of tag mappings.
+func (db *TagDatabase) LoadYAML(reader io.Reader) (err error) {
+	var tags []Tag
+	if err = yaml.NewDecoder(reader).Decode(&tags); err != nil && err != io.EOF {
+		return
+	}
+	return db.Add(tags...)
+}
+
+// LoadCSV loads tags from CSV with a header row naming the columns after the JSON keys of Tag.
+// The name, slave, table, address and type columns are required.
+func (db *TagDatabase) LoadCSV(reader io.Reader) (err error) {
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+	columns := make(map[string]int)
+	for i, column := range records[0] {
+		columns[strings.TrimSpace(column)] = i
+	}
+	for _, required := range []string{"name", "slave", "table", "address", "type"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("missing %s column", required)
+		}
+	}
+	tags := make([]Tag, 0, len(records)-1)
+	for line, record := range records[1:] {
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		tag := Tag{
+			Name:        field("name"),
+			Type:        DataType(field("type")),
+			ByteOrder:   ByteOrder(field("byte_order")),
+			Units:       field("units"),
+			Description: field("description"),
+		}
+		var slave, address uint64
+		if slave, err = strconv.ParseUint(field("slave"), 10, 8); err == nil {
+			if address, err = strconv.ParseUint(field("address"), 10, 16); err == nil {
+				if tag.Table, err = ParseTable(field("table")); err == nil {
+					tag.Scale, err = parseOptionalFloat(field("scale"))
+					if err == nil {
+						tag.Offset, err = parseOptionalFloat(field("offset"))
+					}
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line+2, err)
+		}
+		tag.Slave, tag.Address = uint8(slave), uint16(address)
+		tags = append(tags, tag)
+	}
+	return db.Add(tags...)
+}
+
+func parseOptionalFloat(field string) (float64, error) {
+	if field == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(field, 64)
+}
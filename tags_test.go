@@ -0,0 +1,108 @@
+package modbusserver
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestTagDatabaseGetSet(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	db := NewTagDatabase(s)
+	err := db.Add(
+		Tag{Name: "Pump1.Speed", Slave: 1, Table: TableHoldingRegisters, Address: 12, Type: TypeUint16, Scale: 0.1, Units: "rpm"},
+		Tag{Name: "Pump1.Flow", Slave: 1, Table: TableInputRegisters, Address: 0, Type: TypeFloat32, ByteOrder: OrderCDAB},
+		Tag{Name: "Pump1.Running", Slave: 1, Table: TableCoils, Address: 3, Type: TypeBool},
+	)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if err = db.Set("Pump1.Speed", 1450.3); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if got := s.Slaves[1].HoldingRegisters[12]; got != 14503 {
+		t.Errorf("expected %v, got %v", 14503, got)
+	}
+	if value, _ := db.Get("Pump1.Speed"); value < 1450.29 || value > 1450.31 {
+		t.Errorf("expected %v, got %v", 1450.3, value)
+	}
+
+	db.Set("Pump1.Flow", 2.5)
+	if got := s.Slaves[1].InputRegisters[0:2]; got[0] != 0 || got[1] != 0x4020 {
+		t.Errorf("expected [0 4020], got %04x", got)
+	}
+
+	db.Set("Pump1.Running", 1)
+	if got := s.Slaves[1].Coils[3]; got != 1 {
+		t.Errorf("expected %v, got %v", 1, got)
+	}
+
+	if err = db.Set("Pump1.Speed", -1); err == nil {
+		t.Errorf("expected out of range error, got nil")
+	}
+	if _, err = db.Get("Pump2.Speed"); err == nil {
+		t.Errorf("expected unknown tag error, got nil")
+	}
+}
+
+func TestTagDatabaseConflicts(t *testing.T) {
+	db := NewTagDatabase(NewServer(slog.Logger{}))
+	db.Add(Tag{Name: "A", Slave: 1, Table: TableHoldingRegisters, Address: 10, Type: TypeFloat32})
+
+	err := db.Add(
+		Tag{Name: "A", Slave: 1, Table: TableHoldingRegisters, Address: 20, Type: TypeUint16},
+		Tag{Name: "B", Slave: 1, Table: TableHoldingRegisters, Address: 11, Type: TypeUint16},
+		Tag{Name: "C", Slave: 1, Table: TableCoils, Address: 0, Type: TypeUint16},
+		Tag{Name: "D", Slave: 2, Table: TableHoldingRegisters, Address: 10, Type: TypeUint32},
+		Tag{Name: "E", Slave: 2, Table: TableHoldingRegisters, Address: 11, Type: TypeUint16},
+	)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	for _, expect := range []string{"tag A: duplicate name", "tag B: overlaps tag A", "tag C:", "tag E: overlaps tag D"} {
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("expected %q in %q", expect, err.Error())
+		}
+	}
+	if len(db.Tags()) != 1 {
+		t.Errorf("expected no tags added, got %v", db.Tags())
+	}
+}
+
+func TestTagDatabaseLoad(t *testing.T) {
+	const jsonTags = `[{"name": "A", "slave": 1, "table": "holding_registers", "address": 0, "type": "int32", "scale": 0.01}]`
+	const yamlTags = `
+- name: B
+  slave: 1
+  table: input_registers
+  address: 5
+  type: float32
+  byte_order: CDAB
+  units: bar
+`
+	const csvTags = "name,slave,table,address,type,scale,offset,units,description\n" +
+		"C,1,coils,0,bool,,,,Pump running\n" +
+		"D,2,holding_registers,7,int16,0.5,-20,degC,Temperature\n"
+
+	db := NewTagDatabase(NewServer(slog.Logger{}))
+	if err := db.LoadJSON(strings.NewReader(jsonTags)); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := db.LoadYAML(strings.NewReader(yamlTags)); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := db.LoadCSV(strings.NewReader(csvTags)); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if tag, _ := db.Tag("B"); tag.Table != TableInputRegisters || tag.ByteOrder != OrderCDAB || tag.Units != "bar" {
+		t.Errorf("unexpected YAML tag %+v", tag)
+	}
+	if tag, _ := db.Tag("D"); tag.Slave != 2 || tag.Address != 7 || tag.Scale != 0.5 || tag.Offset != -20 {
+		t.Errorf("unexpected CSV tag %+v", tag)
+	}
+	if err := db.LoadCSV(strings.NewReader("name,slave,table,address,type\nE,1,holding_registers,1,uint16\n")); err == nil {
+		t.Errorf("expected overlap error, got nil")
+	}
+}
@@ -0,0 +1,106 @@
+package modbusserver
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// rtuCharacterBits is the size of an RTU character without a serial configuration: a start bit, 8 data
+// bits, a parity bit and a stop bit.
+const rtuCharacterBits = 11
+
+// SetTurnaround sets the turnaround time of the slave: the time between receiving a request and starting
+// to send the response, including the processing time. Zero removes the turnaround time. The following
+// responses of the same connection wait as they would on a bus, without holding other connections.
+func (s *Server) SetTurnaround(id uint8, turnaround time.Duration) {
+	s.timingMutex.Lock()
+	defer s.timingMutex.Unlock()
+	if turnaround <= 0 {
+		delete(s.turnarounds, id)
+		return
+	}
+	s.turnarounds[id] = turnaround
+}
+
+// Turnaround returns the turnaround time of the slave.
+func (s *Server) Turnaround(id uint8) time.Duration {
+	s.timingMutex.RLock()
+	defer s.timingMutex.RUnlock()
+	return s.turnarounds[id]
+}
+
+// EnableRTUPacing paces the RTU responses sent on the serial device or RTU over TCP listener with the
+// address, so that a response completes as late as it would on a bus at the baud rate: after the 3.5
+// character silence and one character time per byte. For a serial device, zero baudRate uses the
+// configured serial settings; an RTU over TCP listener needs a baud rate and assumes 11 bit characters.
+func (s *Server) EnableRTUPacing(address string, baudRate int) error {
+	if baudRate < 0 {
+		return fmt.Errorf("invalid baud rate %d", baudRate)
+	}
+	s.timingMutex.Lock()
+	defer s.timingMutex.Unlock()
+	characterTime, ok := s.serialCharacterTimes[address]
+	switch {
+	case ok && baudRate != 0:
+		characterTime = characterTime * time.Duration(s.serialBaudRates[address]) / time.Duration(baudRate)
+	case !ok && !slices.Contains(s.ListenerAddresses(), address):
+		return fmt.Errorf("no serial device or listener with address %s", address)
+	case !ok && baudRate == 0:
+		return fmt.Errorf("RTU over TCP listener %s needs a baud rate", address)
+	case !ok:
+		characterTime = rtuCharacterBits * time.Second / time.Duration(baudRate)
+	}
+	s.rtuPacing[address] = characterTime
+	return nil
+}
+
+// DisableRTUPacing stops pacing the responses sent on the serial device or listener with the address.
+func (s *Server) DisableRTUPacing(address string) {
+	s.timingMutex.Lock()
+	defer s.timingMutex.Unlock()
+	delete(s.rtuPacing, address)
+}
+
+// registerSerialTiming records the character time of the serial device, with the defaults of serial.Open.
+func (s *Server) registerSerialTiming(config *serial.Config) {
+	baudRate, dataBits, stopBits := config.BaudRate, config.DataBits, config.StopBits
+	if baudRate == 0 {
+		baudRate = 19200
+	}
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	if stopBits == 0 {
+		stopBits = 1
+	}
+	bits := 1 + dataBits + stopBits
+	if config.Parity != "N" {
+		bits++
+	}
+	s.timingMutex.Lock()
+	defer s.timingMutex.Unlock()
+	s.serialBaudRates[config.Address] = baudRate
+	s.serialCharacterTimes[config.Address] = time.Duration(bits) * time.Second / time.Duration(baudRate)
+}
+
+// transmissionTime returns the time taken by the packet answering the request on a paced RTU bus.
+func (s *Server) transmissionTime(request *Request, packet []byte) time.Duration {
+	if _, ok := request.frame.(*RTUFrame); !ok {
+		return 0
+	}
+	s.timingMutex.RLock()
+	defer s.timingMutex.RUnlock()
+	characterTime, ok := s.rtuPacing[request.address]
+	if !ok {
+		return 0
+	}
+	return rtuSilence(characterTime) + time.Duration(len(packet))*characterTime
+}
+
+// rtuSilence returns the inter-frame silence: 3.5 character times, fixed to 1.75ms above 19200 baud.
+func rtuSilence(characterTime time.Duration) time.Duration {
+	return max(characterTime*7/2, 1750*time.Microsecond)
+}
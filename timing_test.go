@@ -0,0 +1,162 @@
+package modbusserver
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+)
+
+func TestRTUPacing(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenRTUOverTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	listener := s.ListenerAddresses()[0]
+	if err := s.EnableRTUPacing(listener, 0); err == nil {
+		t.Errorf("expected missing baud rate error, got nil")
+	}
+	if err := s.EnableRTUPacing("/dev/ttyUSB9", 9600); err == nil {
+		t.Errorf("expected unknown address error, got nil")
+	}
+	if err := s.EnableRTUPacing(listener, 9600); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	request := &RTUFrame{SlaveId: 1, Function: 3, Data: []byte{0, 0, 0, 125}}
+	start := time.Now()
+	if _, err = conn.Write(request.Bytes()); err != nil {
+		t.Fatalf("failed to write, got %v", err)
+	}
+	// 255 bytes of 11 bits at 9600 baud, after 3.5 characters of silence.
+	expected := 258 * 11 * time.Second / 9600
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = io.ReadFull(conn, make([]byte, 255)); err != nil {
+		t.Fatalf("failed to read, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < expected {
+		t.Errorf("expected at least %v, got %v", expected, elapsed)
+	}
+}
+
+func TestTurnaround(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.InitSlave(2)
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	s.SetTurnaround(1, 100*time.Millisecond)
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.Timeout = time.Second
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+	for _, c := range []struct {
+		slave   uint8
+		minimum time.Duration
+		maximum time.Duration
+	}{
+		{1, 100 * time.Millisecond, time.Second},
+		{2, 0, 50 * time.Millisecond},
+	} {
+		handler.SlaveId = c.slave
+		start := time.Now()
+		if _, err := client.ReadCoils(0, 1); err != nil {
+			t.Errorf("slave %d: expected nil, got %v", c.slave, err)
+		}
+		if elapsed := time.Since(start); elapsed < c.minimum || elapsed > c.maximum {
+			t.Errorf("slave %d: expected between %v and %v, got %v", c.slave, c.minimum, c.maximum, elapsed)
+		}
+	}
+	s.SetTurnaround(1, 0)
+	if turnaround := s.Turnaround(1); turnaround != 0 {
+		t.Errorf("expected no turnaround, got %v", turnaround)
+	}
+}
+
+func TestTimingConnections(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.InitSlave(2)
+	paced, addr := getFreePort(), getFreePort()
+	if err := s.ListenRTUOverTCP(paced); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	s.SetTurnaround(1, 200*time.Millisecond)
+	if err := s.EnableRTUPacing(s.ListenerAddresses()[0], 1200); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	conn, err := net.Dial("tcp", paced)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.SlaveId = 2
+	handler.Timeout = time.Second
+	if err = handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	// The paced response of slave 1 doesn't hold the responses of the other listener.
+	request := &RTUFrame{SlaveId: 1, Function: 3, Data: []byte{0, 0, 0, 10}}
+	if _, err = conn.Write(request.Bytes()); err != nil {
+		t.Fatalf("failed to write, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if _, err = client.ReadCoils(0, 1); err != nil || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("expected an immediate response, got %v after %v", err, time.Since(start))
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = io.ReadFull(conn, make([]byte, 25)); err != nil {
+		t.Errorf("failed to read the paced response, got %v", err)
+	}
+}
+
+func TestSerialTiming(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.registerSerialTiming(&serial.Config{Address: "/dev/ttyS0", BaudRate: 9600, Parity: "N", StopBits: 2})
+	s.registerSerialTiming(&serial.Config{Address: "/dev/ttyS1"})
+	for _, c := range []struct {
+		address  string
+		baudRate int
+		expected time.Duration
+	}{
+		{"/dev/ttyS0", 0, 11 * time.Second / 9600},
+		{"/dev/ttyS0", 19200, 11 * time.Second / 19200},
+		{"/dev/ttyS1", 0, 11 * time.Second / 19200},
+	} {
+		if err := s.EnableRTUPacing(c.address, c.baudRate); err != nil || s.rtuPacing[c.address] != c.expected {
+			t.Errorf("%s at %d: expected %v, got %v, %v", c.address, c.baudRate, c.expected, s.rtuPacing[c.address], err)
+		}
+	}
+	if silence := rtuSilence(11 * time.Second / 115200); silence != 1750*time.Microsecond {
+		t.Errorf("expected 1.75ms silence, got %v", silence)
+	}
+}
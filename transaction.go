@@ -0,0 +1,165 @@
+package modbusserver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Transaction groups reads and writes of slave memory made by the application. While a transaction is
+// open, Modbus requests are not processed, so masters see either all or none of its writes. Writes are
+// buffered and applied by Commit; reads inside the transaction see the buffered writes.
+type Transaction struct {
+	server   *Server
+	slaves   []uint8
+	writable bool
+	writes   []WriteEvent
+	done     bool
+}
+
+// Begin opens a read-write transaction limited to the given slaves. The transaction must be finished
+// with Commit or Rollback. It holds the memory lock of the server until then, so the memory must only
+// be accessed through the transaction: Server methods such as ReadTable, WriteTable or InitSlave called
+// before it is finished deadlock.
+func (s *Server) Begin(ids ...uint8) (*Transaction, error) {
+	s.memoryMutex.Lock()
+	for _, id := range ids {
+		if _, ok := s.Slaves[id]; !ok {
+			s.memoryMutex.Unlock()
+			return nil, fmt.Errorf("slave with %d ID didn't implemented on server", id)
+		}
+	}
+	return &Transaction{server: s, slaves: slices.Clone(ids), writable: true}, nil
+}
+
+// Update runs the function in a read-write transaction limited to the given slaves. The transaction is
+// committed if the function returns nil and rolled back otherwise. The function must only access memory
+// through tx, see Begin.
+func (s *Server) Update(ids []uint8, function func(tx *Transaction) error) (err error) {
+	tx, err := s.Begin(ids...)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if err = function(tx); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// View runs the function in a read-only transaction, giving it a consistent view of all slaves.
+// Modbus writes are delayed until the function returns. The function must only access memory through
+// tx: Server methods called by it may deadlock.
+func (s *Server) View(function func(tx *Transaction) error) error {
+	s.memoryMutex.RLock()
+	tx := &Transaction{server: s}
+	defer tx.Rollback()
+	return function(tx)
+}
+
+// Commit applies all buffered writes atomically and finishes the transaction. If a write hook or the
+// write log fails, nothing is written.
+func (tx *Transaction) Commit() (err error) {
+	if tx.done {
+		return errors.New("transaction is already finished")
+	}
+	if tx.writable {
+		err = tx.server.commitEvents(tx.writes)
+	}
+	tx.finish()
+	return
+}
+
+// Rollback discards all buffered writes and finishes the transaction. It does nothing if the
+// transaction is already finished.
+func (tx *Transaction) Rollback() {
+	if !tx.done {
+		tx.finish()
+	}
+}
+
+func (tx *Transaction) finish() {
+	tx.done = true
+	tx.writes = nil
+	if tx.writable {
+		tx.server.memoryMutex.Unlock()
+	} else {
+		tx.server.memoryMutex.RUnlock()
+	}
+}
+
+func (tx *Transaction) check(id uint8, write bool) error {
+	if tx.done {
+		return errors.New("transaction is already finished")
+	}
+	if write && !tx.writable {
+		return errors.New("transaction is read-only")
+	}
+	if tx.writable && !slices.Contains(tx.slaves, id) {
+		return fmt.Errorf("slave %d isn't part of the transaction (must be in %v)", id, tx.slaves)
+	}
+	return nil
+}
+
+// ReadTable returns quantity values of a slave table, including the writes buffered by the transaction.
+func (tx *Transaction) ReadTable(id uint8, table Table, address uint16, quantity int) (values []uint16, err error) {
+	if err = tx.check(id, false); err != nil {
+		return
+	}
+	if values, err = tx.server.readRaw(id, table, address, quantity); err != nil {
+		return
+	}
+	for _, write := range tx.writes {
+		if write.Slave != id || write.Table != table {
+			continue
+		}
+		for i, value := range write.Values {
+			if current := int(write.Address) + i - int(address); current >= 0 && current < quantity {
+				values[current] = value
+			}
+		}
+	}
+	return
+}
+
+// WriteTable buffers a write of values to a slave table, bypassing write validation rules.
+func (tx *Transaction) WriteTable(id uint8, table Table, address uint16, values []uint16) (err error) {
+	if err = tx.check(id, true); err != nil {
+		return
+	}
+	if _, err = tx.server.checkRange(id, table, address, len(values)); err != nil {
+		return
+	}
+	values = slices.Clone(values)
+	if table == TableCoils || table == TableDiscreteInputs {
+		for i, value := range values {
+			values[i] = uint16(bitValue(value))
+		}
+	}
+	tx.writes = append(tx.writes, WriteEvent{Slave: id, Table: table, Address: address, Values: values})
+	return
+}
+
+// ReadValue reads a typed value, including the writes buffered by the transaction.
+func (tx *Transaction) ReadValue(id uint8, table Table, address uint16, dataType DataType, order ByteOrder) (value float64, err error) {
+	if err = checkBitType(table, dataType); err != nil {
+		return
+	}
+	registers, err := tx.ReadTable(id, table, address, dataType.Registers())
+	if err != nil {
+		return
+	}
+	return DecodeValue(registers, dataType, order)
+}
+
+// WriteValue buffers a write of a typed value.
+func (tx *Transaction) WriteValue(id uint8, table Table, address uint16, dataType DataType, order ByteOrder, value float64) (err error) {
+	if err = checkBitType(table, dataType); err != nil {
+		return
+	}
+	registers, err := EncodeValue(value, dataType, order)
+	if err != nil {
+		return
+	}
+	return tx.WriteTable(id, table, address, registers)
+}
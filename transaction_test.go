@@ -0,0 +1,197 @@
+package modbusserver
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestTransactionCommitRollback(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.InitSlave(2)
+
+	err := s.Update([]uint8{1, 2}, func(tx *Transaction) error {
+		tx.WriteValue(1, TableInputRegisters, 0, TypeFloat32, OrderABCD, 1.5)
+		tx.WriteTable(2, TableCoils, 3, []uint16{5})
+		if value, _ := tx.ReadValue(1, TableInputRegisters, 0, TypeFloat32, OrderABCD); value != 1.5 {
+			t.Errorf("expected buffered write to be visible, got %v", value)
+		}
+		if s.Slaves[1].InputRegisters[0] != 0 {
+			t.Errorf("expected write to be buffered until commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if got := s.Slaves[1].InputRegisters[0:2]; !slices.Equal(got, []uint16{0x3FC0, 0}) {
+		t.Errorf("expected %04x, got %04x", []uint16{0x3FC0, 0}, got)
+	}
+	if got := s.Slaves[2].Coils[3]; got != 1 {
+		t.Errorf("expected %v, got %v", 1, got)
+	}
+
+	err = s.Update([]uint8{1}, func(tx *Transaction) error {
+		tx.WriteTable(1, TableHoldingRegisters, 0, []uint16{7})
+		return errors.New("abort")
+	})
+	if err == nil || s.Slaves[1].HoldingRegisters[0] != 0 {
+		t.Errorf("expected rolled back transaction, got %v, %v", err, s.Slaves[1].HoldingRegisters[0])
+	}
+
+	tx, _ := s.Begin(1)
+	if err = tx.WriteTable(2, TableHoldingRegisters, 0, []uint16{1}); err == nil {
+		t.Errorf("expected error on slave outside the transaction, got nil")
+	}
+	tx.Rollback()
+	if err = tx.Commit(); err == nil {
+		t.Errorf("expected error on finished transaction, got nil")
+	}
+
+	s.AddWriteHook(func(event WriteEvent) error {
+		if event.Table == TableHoldingRegisters {
+			return errors.New("refused")
+		}
+		return nil
+	})
+	err = s.Update([]uint8{1}, func(tx *Transaction) error {
+		tx.WriteTable(1, TableCoils, 0, []uint16{1})
+		return tx.WriteTable(1, TableHoldingRegisters, 0, []uint16{1})
+	})
+	if err == nil || s.Slaves[1].Coils[0] != 0 {
+		t.Errorf("expected no write after hook failure, got %v, %v", err, s.Slaves[1].Coils[0])
+	}
+
+	err = s.View(func(tx *Transaction) error {
+		return tx.WriteTable(1, TableCoils, 0, []uint16{1})
+	})
+	if err == nil {
+		t.Errorf("expected read-only error, got nil")
+	}
+}
+
+func TestTransactionConsistentReads(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for value := 0; ; value++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.Update([]uint8{1}, func(tx *Transaction) error {
+				return tx.WriteValue(1, TableInputRegisters, 0, TypeUint32, OrderABCD, float64(value%65536*65537))
+			})
+			time.Sleep(10 * time.Microsecond)
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-finished
+	}()
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.SlaveId = 1
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+	for i := 0; i < 100; i++ {
+		results, err := client.ReadInputRegisters(0, 2)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if results[0] != results[2] || results[1] != results[3] {
+			t.Fatalf("torn read %v", results)
+		}
+		s.View(func(tx *Transaction) error {
+			values, _ := tx.ReadTable(1, TableInputRegisters, 0, 2)
+			if values[0] != values[1] {
+				t.Errorf("torn view %v", values)
+			}
+			return nil
+		})
+	}
+}
+
+func TestTransactionAPI(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.WriteTable(1, TableHoldingRegisters, 10, []uint16{7})
+	s.WriteValue(1, TableInputRegisters, 0, TypeFloat32, OrderCDAB, 1.5)
+
+	// Read-modify-write of every table with the methods of tx only, which must not deadlock.
+	done := make(chan error)
+	go func() {
+		done <- s.Update([]uint8{1}, func(tx *Transaction) error {
+			for _, table := range []Table{TableCoils, TableDiscreteInputs, TableHoldingRegisters, TableInputRegisters} {
+				values, err := tx.ReadTable(1, table, 10, 1)
+				if err != nil {
+					return err
+				}
+				if err = tx.WriteTable(1, table, 10, []uint16{values[0] + 1}); err != nil {
+					return err
+				}
+			}
+			value, err := tx.ReadValue(1, TableInputRegisters, 0, TypeFloat32, OrderCDAB)
+			if err != nil {
+				return err
+			}
+			return tx.WriteValue(1, TableInputRegisters, 0, TypeFloat32, OrderCDAB, value*2)
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("transaction deadlocked")
+	}
+	for table, expected := range map[Table]uint16{TableCoils: 1, TableDiscreteInputs: 1, TableHoldingRegisters: 8, TableInputRegisters: 1} {
+		if values, _ := s.ReadTable(1, table, 10, 1); values[0] != expected {
+			t.Errorf("%s: expected %v, got %v", table, expected, values[0])
+		}
+	}
+	if value, _ := s.ReadValue(1, TableInputRegisters, 0, TypeFloat32, OrderCDAB); value != 3 {
+		t.Errorf("expected %v, got %v", 3, value)
+	}
+
+	// Server methods wait for the transaction to finish.
+	tx, err := s.Begin(1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	tx.WriteTable(1, TableHoldingRegisters, 0, []uint16{42})
+	read := make(chan uint16)
+	go func() {
+		values, _ := s.ReadTable(1, TableHoldingRegisters, 0, 1)
+		read <- values[0]
+	}()
+	select {
+	case value := <-read:
+		t.Fatalf("expected the read to wait for the transaction, got %v", value)
+	case <-time.After(20 * time.Millisecond):
+	}
+	tx.Commit()
+	if value := <-read; value != 42 {
+		t.Errorf("expected %v, got %v", 42, value)
+	}
+}
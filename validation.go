@@ -0,0 +1,168 @@
+package modbusserver
+
+import (
+	"fmt"
+	"slices"
+
+	"golang.org/x/exp/maps"
+)
+
+// WriteRule restricts Modbus writes to an address range of a slave's holding registers or coils.
+//
+// A write touching a ReadOnly range is rejected with IllegalDataAddress. A value outside Min/Max,
+// not listed in Allowed or refused by Validator is rejected with IllegalDataValue. Coil values are
+// checked as 0 or 1.
+type WriteRule struct {
+	Table    Table    `json:"table" yaml:"table"`
+	Address  uint16   `json:"address" yaml:"address"`
+	Quantity uint16   `json:"quantity" yaml:"quantity"`
+	ReadOnly bool     `json:"read_only,omitempty" yaml:"read_only,omitempty"`
+	Min      *int     `json:"min,omitempty" yaml:"min,omitempty"`
+	Max      *int     `json:"max,omitempty" yaml:"max,omitempty"`
+	Signed   bool     `json:"signed,omitempty" yaml:"signed,omitempty"`
+	Allowed  []uint16 `json:"allowed,omitempty" yaml:"allowed,omitempty"`
+	// Validator is called for each written value; a non nil error rejects the write.
+	Validator func(address uint16, value uint16) error `json:"-" yaml:"-"`
+}
+
+// AddWriteRule appends a write validation rule to the slave.
+func (s *Server) AddWriteRule(id uint8, rule WriteRule) (err error) {
+	s.memoryMutex.RLock()
+	_, ok := s.Slaves[id]
+	if !ok {
+		err = fmt.Errorf("slave with %d ID didn't implemented on server (must be in %v)", id, maps.Keys(s.Slaves))
+	}
+	s.memoryMutex.RUnlock()
+	if err != nil {
+		return
+	}
+	if err = rule.validate(); err != nil {
+		return
+	}
+	s.writeRulesMutex.Lock()
+	defer s.writeRulesMutex.Unlock()
+	s.writeRules[id] = append(s.writeRules[id], rule)
+	return
+}
+
+func (rule *WriteRule) validate() error {
+	if rule.Table != TableCoils && rule.Table != TableHoldingRegisters {
+		return fmt.Errorf("write rules are supported only for coils and holding registers, got %s", rule.Table)
+	}
+	if rule.Quantity == 0 || int(rule.Address)+int(rule.Quantity) > 65536 {
+		return fmt.Errorf("invalid write rule range: address %d, quantity %d", rule.Address, rule.Quantity)
+	}
+	if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+		return fmt.Errorf("invalid write rule limits: min %d greater than max %d", *rule.Min, *rule.Max)
+	}
+	return nil
+}
+
+// ClearWriteRules removes all write validation rules of the slave.
+func (s *Server) ClearWriteRules(id uint8) {
+	s.writeRulesMutex.Lock()
+	defer s.writeRulesMutex.Unlock()
+	delete(s.writeRules, id)
+}
+
+// WriteRules returns a copy of the write validation rules of the slave.
+func (s *Server) WriteRules(id uint8) []WriteRule {
+	s.writeRulesMutex.RLock()
+	defer s.writeRulesMutex.RUnlock()
+	return slices.Clone(s.writeRules[id])
+}
+
+// ValidateWrite checks values about to be written from address onwards against the slave's rules, the
+// rules of its target if it is an alias and the rules of the slaves the write is mirrored to through alias
+// windows. Every value is checked before anything is written, so a rejected multiple write leaves memory
+// untouched.
+func (s *Server) ValidateWrite(id uint8, table Table, address int, values []uint16) *Exception {
+	s.memoryMutex.RLock()
+	defer s.memoryMutex.RUnlock()
+	return s.validateWrite(id, table, address, values)
+}
+
+// validateWrite is ValidateWrite with the memory locked.
+func (s *Server) validateWrite(id uint8, table Table, address int, values []uint16) *Exception {
+	if address+len(values) > 65536 {
+		return &IllegalDataAddress
+	}
+	if exception := s.checkRules(id, table, address, values); exception != &Success {
+		return exception
+	}
+	for _, mirrored := range s.mirroredWrites(WriteEvent{Slave: id, Table: table, Address: uint16(address), Values: values}) {
+		if exception := s.checkRules(mirrored.Slave, mirrored.Table, int(mirrored.Address), mirrored.Values); exception != &Success {
+			return exception
+		}
+	}
+	return &Success
+}
+
+// checkRules checks the values against the rules of the slave and of its target if it is an alias.
+func (s *Server) checkRules(id uint8, table Table, address int, values []uint16) *Exception {
+	s.writeRulesMutex.RLock()
+	defer s.writeRulesMutex.RUnlock()
+	rules := s.writeRules[id]
+	if target, ok := s.aliasRules[id]; ok {
+		rules = append(slices.Clip(rules), s.writeRules[target]...)
+	}
+	for _, rule := range rules {
+		if rule.Table != table {
+			continue
+		}
+		start := max(address, int(rule.Address))
+		end := min(address+len(values), int(rule.Address)+int(rule.Quantity))
+		if start >= end {
+			continue
+		}
+		if rule.ReadOnly {
+			return &IllegalDataAddress
+		}
+		for current := start; current < end; current++ {
+			if !rule.accepts(uint16(current), values[current-address]) {
+				return &IllegalDataValue
+			}
+		}
+	}
+	return &Success
+}
+
+func (rule *WriteRule) accepts(address uint16, value uint16) bool {
+	number := int(value)
+	if rule.Signed {
+		number = int(int16(value))
+	}
+	if rule.Min != nil && number < *rule.Min {
+		return false
+	}
+	if rule.Max != nil && number > *rule.Max {
+		return false
+	}
+	if len(rule.Allowed) != 0 && !slices.Contains(rule.Allowed, value) {
+		return false
+	}
+	if rule.Validator != nil && rule.Validator(address, value) != nil {
+		return false
+	}
+	return true
+}
+
+// WriteChecked writes coils or holding registers of a slave as a Modbus master write does: the write
+// rules and read-only aliases apply, and a rejected write returns the Exception the master would get.
+func (s *Server) WriteChecked(id uint8, table Table, address uint16, values []uint16) error {
+	if table != TableCoils && table != TableHoldingRegisters {
+		return fmt.Errorf("%s are not writable by Modbus masters", table)
+	}
+	s.memoryMutex.Lock()
+	defer s.memoryMutex.Unlock()
+	if _, err := s.checkRange(id, table, address, len(values)); err != nil {
+		return err
+	}
+	if exception := s.validateWrite(id, table, int(address), values); exception != &Success {
+		return *exception
+	}
+	if exception := s.commitWrite(id, table, int(address), values); exception != &Success {
+		return *exception
+	}
+	return nil
+}
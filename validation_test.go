@@ -0,0 +1,136 @@
+package modbusserver
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+func TestWriteRuleReadOnly(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	if err := s.AddWriteRule(1, WriteRule{Table: TableHoldingRegisters, Address: 10, Quantity: 5, ReadOnly: true}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	var frame TCPFrame
+	frame.Device = 1
+	frame.Function = 16
+	SetDataWithRegisterAndNumberAndValues(&frame, 8, 3, []uint16{1, 2, 3})
+	_, exception := WriteHoldingRegisters(s, &frame)
+	if exception != &IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+	if got := s.Slaves[1].HoldingRegisters[8:11]; !slices.Equal(got, []uint16{0, 0, 0}) {
+		t.Errorf("expected no partial write, got %v", got)
+	}
+
+	SetDataWithRegisterAndNumberAndValues(&frame, 5, 3, []uint16{1, 2, 3})
+	if _, exception = WriteHoldingRegisters(s, &frame); exception != &Success {
+		t.Errorf("expected Success, got %v", exception.String())
+	}
+}
+
+func TestWriteRuleLimits(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	minimum, maximum := -10, 100
+	s.AddWriteRule(1, WriteRule{Table: TableHoldingRegisters, Address: 0, Quantity: 2, Min: &minimum, Max: &maximum, Signed: true})
+
+	var frame TCPFrame
+	frame.Device = 1
+	frame.Function = 6
+	for value, expect := range map[uint16]*Exception{
+		100:            &Success,
+		101:            &IllegalDataValue,
+		uint16(0xFFF6): &Success, // -10
+		uint16(0xFFF5): &IllegalDataValue,
+		uint16(0x8000): &IllegalDataValue,
+		50:             &Success,
+	} {
+		SetDataWithRegisterAndNumber(&frame, 1, value)
+		if _, exception := WriteHoldingRegister(s, &frame); exception != expect {
+			t.Errorf("value %d: expected %v, got %v", value, expect.String(), exception.String())
+		}
+	}
+}
+
+func TestWriteRuleAllowedAndValidator(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.AddWriteRule(1, WriteRule{Table: TableHoldingRegisters, Address: 0, Quantity: 1, Allowed: []uint16{1, 2, 4}})
+	s.AddWriteRule(1, WriteRule{Table: TableCoils, Address: 3, Quantity: 1, Validator: func(address uint16, value uint16) error {
+		if value == 0 {
+			return errors.New("coil can't be reset")
+		}
+		return nil
+	}})
+
+	var frame TCPFrame
+	frame.Device = 1
+	SetDataWithRegisterAndNumberAndValues(&frame, 0, 2, []uint16{3, 7})
+	if _, exception := WriteHoldingRegisters(s, &frame); exception != &IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+	SetDataWithRegisterAndNumberAndValues(&frame, 0, 2, []uint16{4, 7})
+	if _, exception := WriteHoldingRegisters(s, &frame); exception != &Success {
+		t.Errorf("expected Success, got %v", exception.String())
+	}
+
+	// Coils 0..4 = 1, 1, 1, 0, 1: coil 3 refused, nothing is written.
+	SetDataWithRegisterAndNumberAndBytes(&frame, 0, 5, []byte{0x17})
+	if _, exception := WriteMultipleCoils(s, &frame); exception != &IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+	if got := s.Slaves[1].Coils[0:5]; !slices.Equal(got, []byte{0, 0, 0, 0, 0}) {
+		t.Errorf("expected no partial write, got %v", got)
+	}
+	SetDataWithRegisterAndNumberAndBytes(&frame, 0, 5, []byte{0x1F})
+	if _, exception := WriteMultipleCoils(s, &frame); exception != &Success {
+		t.Errorf("expected Success, got %v", exception.String())
+	}
+	if got := s.Slaves[1].Coils[0:5]; !slices.Equal(got, []byte{1, 1, 1, 1, 1}) {
+		t.Errorf("expected %v, got %v", []byte{1, 1, 1, 1, 1}, got)
+	}
+}
+
+func TestAddWriteRuleErrors(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	minimum, maximum := 10, 0
+	for _, rule := range []WriteRule{
+		{Table: TableInputRegisters, Address: 0, Quantity: 1},
+		{Table: TableCoils, Address: 65535, Quantity: 2},
+		{Table: TableHoldingRegisters, Address: 0, Quantity: 0},
+		{Table: TableHoldingRegisters, Address: 0, Quantity: 1, Min: &minimum, Max: &maximum},
+	} {
+		if err := s.AddWriteRule(1, rule); err == nil {
+			t.Errorf("expected error for rule %+v, got nil", rule)
+		}
+	}
+	if err := s.AddWriteRule(2, WriteRule{Table: TableCoils, Quantity: 1}); err == nil {
+		t.Errorf("expected error for unknown slave, got nil")
+	}
+}
+
+func TestWriteChecked(t *testing.T) {
+	s := NewServer(slog.Logger{})
+	s.InitSlave(1)
+	s.AddWriteRule(1, WriteRule{Table: TableHoldingRegisters, Address: 0, Quantity: 1, Allowed: []uint16{1, 2}})
+	if err := s.WriteChecked(1, TableHoldingRegisters, 0, []uint16{3}); err != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", err)
+	}
+	if err := s.WriteChecked(1, TableHoldingRegisters, 0, []uint16{2, 7}); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if got, _ := s.ReadTable(1, TableHoldingRegisters, 0, 2); !slices.Equal(got, []uint16{2, 7}) {
+		t.Errorf("expected [2 7], got %v", got)
+	}
+	if err := s.WriteChecked(1, TableInputRegisters, 0, []uint16{1}); err == nil {
+		t.Errorf("expected error for input registers, got nil")
+	}
+	if err := s.WriteChecked(2, TableCoils, 0, []uint16{1}); err == nil {
+		t.Errorf("expected error for unknown slave, got nil")
+	}
+}
@@ -0,0 +1,281 @@
+package modbusserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+type (
+	// WASMConfig limits the execution of a WebAssembly plug-in.
+	WASMConfig struct {
+		// Timeout limits the execution of one request, 100 ms if zero.
+		Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+		// MemoryPages limits the memory of the module in 64 KiB pages, 16 (1 MiB) if zero.
+		MemoryPages uint32 `json:"memory_pages,omitempty" yaml:"memory_pages,omitempty"`
+		// ReadOnly denies writes to slave memory.
+		ReadOnly bool `json:"read_only,omitempty" yaml:"read_only,omitempty"`
+	}
+	// WASMPlugin handles Modbus functions with a WebAssembly module run in a sandbox. The module must
+	// export:
+	//   - memory;
+	//   - buffer() i32: the address of a buffer of at least 253 bytes for request and response data;
+	//   - handle(slave, function, length i32) i32: handles the request data written to the buffer and
+	//     returns the length of the response data written to the buffer, or a negative exception code.
+	//
+	// The module may import from the "modbus" module, with values stored as little-endian u16, one per
+	// coil or register:
+	//   - read(table, address, count, pointer i32) i32: reads the memory of the requested slave;
+	//   - write(table, address, count, pointer i32) i32: writes the memory of the requested slave;
+	//   - log(pointer, length i32): writes a debug message.
+	//
+	// read and write return 0 or an exception code. Only coils and holding registers can be written.
+	// Writes are checked with the write rules of the slave and applied only when handle succeeds. Tables
+	// are numbered as Table. WASI is available without access to files, and a new instance of the module
+	// handles every request. The module runs without locking the memory, so Modbus requests for other
+	// slaves are served meanwhile.
+	WASMPlugin struct {
+		runtime  wazero.Runtime
+		compiled wazero.CompiledModule
+		config   WASMConfig
+	}
+	// wasmCall is the state of a request handled by a plug-in, passed to host functions in the context.
+	wasmCall struct {
+		server *Server
+		slave  uint8
+		tx     *Transaction
+		config WASMConfig
+	}
+	wasmCallKey struct{}
+)
+
+// wasmBufferSize is the maximum data length of a Modbus PDU.
+const wasmBufferSize = 253
+
+// LoadWASMPluginFile compiles the WebAssembly module read from the file with LoadWASMPlugin.
+func LoadWASMPluginFile(path string, config WASMConfig) (*WASMPlugin, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plugin, err := LoadWASMPlugin(code, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plugin, nil
+}
+
+// LoadWASMPlugin compiles a WebAssembly module. Register Handle for the function codes of the plug-in
+// with RegisterFunctionHandler, and Close the plug-in when it isn't used anymore.
+func LoadWASMPlugin(code []byte, config WASMConfig) (plugin *WASMPlugin, err error) {
+	if config.Timeout <= 0 {
+		config.Timeout = Duration(100 * time.Millisecond)
+	}
+	if config.MemoryPages == 0 {
+		config.MemoryPages = 16
+	}
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(config.MemoryPages))
+	defer func() {
+		if err != nil {
+			runtime.Close(ctx)
+		}
+	}()
+	if _, err = wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		return
+	}
+	i32 := api.ValueTypeI32
+	_, err = runtime.NewHostModuleBuilder("modbus").
+		NewFunctionBuilder().WithGoModuleFunction(api.GoModuleFunc(wasmRead), []api.ValueType{i32, i32, i32, i32}, []api.ValueType{i32}).Export("read").
+		NewFunctionBuilder().WithGoModuleFunction(api.GoModuleFunc(wasmWrite), []api.ValueType{i32, i32, i32, i32}, []api.ValueType{i32}).Export("write").
+		NewFunctionBuilder().WithGoModuleFunction(api.GoModuleFunc(wasmLog), []api.ValueType{i32, i32}, nil).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		return
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		return
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return nil, errors.New("module doesn't export memory")
+	}
+	functions := compiled.ExportedFunctions()
+	for name, signature := range map[string][2]int{"buffer": {0, 1}, "handle": {3, 1}} {
+		function, ok := functions[name]
+		if !ok {
+			return nil, fmt.Errorf("module doesn't export function %s", name)
+		}
+		if len(function.ParamTypes()) != signature[0] || len(function.ResultTypes()) != signature[1] {
+			return nil, fmt.Errorf("function %s has a wrong signature", name)
+		}
+	}
+	return &WASMPlugin{runtime: runtime, compiled: compiled, config: config}, nil
+}
+
+// Close releases the runtime of the plug-in.
+func (p *WASMPlugin) Close() error {
+	return p.runtime.Close(context.Background())
+}
+
+// Handle is the function handler running the plug-in. A trap, a timeout or an invalid result is
+// answered with SlaveDeviceFailure.
+func (p *WASMPlugin) Handle(s *Server, frame Framer) ([]byte, *Exception) {
+	// The plug-in runs without the memory lock: its reads lock the memory briefly, and its writes are
+	// buffered in a transaction that is never finished and committed once the plug-in returns.
+	call := &wasmCall{
+		server: s,
+		slave:  frame.GetSlaveId(),
+		tx:     &Transaction{server: s, slaves: []uint8{frame.GetSlaveId()}, writable: true},
+		config: p.config,
+	}
+	data, code, err := p.run(call, frame.GetFunction(), frame.GetData())
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("Slave %d: plug-in for function %d failed: %s", call.slave, frame.GetFunction(), err.Error()))
+		return []byte{}, &SlaveDeviceFailure
+	}
+	if code != Success {
+		return []byte{}, &code
+	}
+	s.memoryMutex.Lock()
+	err = s.commitEvents(call.tx.writes)
+	s.memoryMutex.Unlock()
+	if err != nil {
+		if errors.Is(err, errReadOnlyAlias) {
+			return []byte{}, &IllegalDataAddress
+		}
+		s.logger.Error(fmt.Sprintf("Slave %d: plug-in writes canceled: %s", call.slave, err.Error()))
+		return []byte{}, &SlaveDeviceFailure
+	}
+	return data, &Success
+}
+
+// run instantiates the module and calls handle with the request data.
+func (p *WASMPlugin) run(call *wasmCall, function uint8, request []byte) (response []byte, code Exception, err error) {
+	if len(request) > wasmBufferSize {
+		return nil, Success, fmt.Errorf("request data too long: %d bytes", len(request))
+	}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), wasmCallKey{}, call), time.Duration(p.config.Timeout))
+	defer cancel()
+	module, err := p.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return
+	}
+	defer module.Close(context.Background())
+	results, err := module.ExportedFunction("buffer").Call(ctx)
+	if err != nil {
+		return
+	}
+	buffer := api.DecodeU32(results[0])
+	if !module.Memory().Write(buffer, request) {
+		return nil, Success, fmt.Errorf("buffer %d out of memory", buffer)
+	}
+	results, err = module.ExportedFunction("handle").Call(ctx, uint64(call.slave), uint64(function), uint64(len(request)))
+	if err != nil {
+		return
+	}
+	length := api.DecodeI32(results[0])
+	switch {
+	case length < -255:
+		return nil, Success, fmt.Errorf("invalid exception code %d", -length)
+	case length < 0:
+		return nil, Exception(-length), nil
+	case length > wasmBufferSize:
+		return nil, Success, fmt.Errorf("response data too long: %d bytes", length)
+	}
+	data, ok := module.Memory().Read(buffer, uint32(length))
+	if !ok {
+		return nil, Success, fmt.Errorf("response out of memory")
+	}
+	return append([]byte{}, data...), Success, nil
+}
+
+// wasmRead implements read(table, address, count, pointer i32) i32.
+func wasmRead(ctx context.Context, module api.Module, stack []uint64) {
+	call, table, address, count, pointer, code := wasmAccess(ctx, stack)
+	if code == Success {
+		call.server.memoryMutex.RLock()
+		values, err := call.tx.ReadTable(call.slave, table, address, count)
+		call.server.memoryMutex.RUnlock()
+		if err != nil {
+			code = IllegalDataAddress
+		} else {
+			data := make([]byte, len(values)*2)
+			for i, value := range values {
+				binary.LittleEndian.PutUint16(data[i*2:], value)
+			}
+			if !module.Memory().Write(pointer, data) {
+				code = SlaveDeviceFailure
+			}
+		}
+	}
+	stack[0] = api.EncodeI32(int32(code))
+}
+
+// wasmWrite implements write(table, address, count, pointer i32) i32.
+func wasmWrite(ctx context.Context, module api.Module, stack []uint64) {
+	call, table, address, count, pointer, code := wasmAccess(ctx, stack)
+	// Discrete inputs and input registers can't be written by Modbus functions.
+	if code == Success && (call.config.ReadOnly || table == TableDiscreteInputs || table == TableInputRegisters) {
+		code = IllegalDataAddress
+	}
+	if code == Success {
+		code = func() Exception {
+			data, ok := module.Memory().Read(pointer, uint32(count)*2)
+			if !ok {
+				return SlaveDeviceFailure
+			}
+			values := make([]uint16, count)
+			for i := range values {
+				values[i] = binary.LittleEndian.Uint16(data[i*2:])
+			}
+			if exception := call.server.ValidateWrite(call.slave, table, int(address), values); exception != &Success {
+				return *exception
+			}
+			call.server.memoryMutex.RLock()
+			defer call.server.memoryMutex.RUnlock()
+			if err := call.tx.WriteTable(call.slave, table, address, values); err != nil {
+				return IllegalDataAddress
+			}
+			return Success
+		}()
+	}
+	stack[0] = api.EncodeI32(int32(code))
+}
+
+// wasmAccess decodes the arguments of read and write and checks the range.
+func wasmAccess(ctx context.Context, stack []uint64) (call *wasmCall, table Table, address uint16, count int, pointer uint32, code Exception) {
+	call, ok := ctx.Value(wasmCallKey{}).(*wasmCall)
+	if !ok {
+		code = SlaveDeviceFailure
+		return
+	}
+	rawTable, rawAddress, rawCount := api.DecodeI32(stack[0]), api.DecodeI32(stack[1]), api.DecodeI32(stack[2])
+	if rawTable < 0 || rawTable > int32(TableInputRegisters) || rawAddress < 0 || rawCount <= 0 || int(rawAddress)+int(rawCount) > 65536 {
+		code = IllegalDataAddress
+		return
+	}
+	return call, Table(rawTable), uint16(rawAddress), int(rawCount), api.DecodeU32(stack[3]), Success
+}
+
+// wasmLog implements log(pointer, length i32).
+func wasmLog(ctx context.Context, module api.Module, stack []uint64) {
+	call, ok := ctx.Value(wasmCallKey{}).(*wasmCall)
+	if !ok {
+		return
+	}
+	if message, ok := module.Memory().Read(api.DecodeU32(stack[0]), api.DecodeU32(stack[1])); ok {
+		call.server.logger.Debug(fmt.Sprintf("Slave %d: plug-in: %s", call.slave, message))
+	}
+}
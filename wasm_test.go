@@ -0,0 +1,237 @@
+package modbusserver
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+// wasm opcodes used by the test modules.
+const (
+	wasmEnd      = 0x0b
+	wasmIf       = 0x04
+	wasmLoop     = 0x03
+	wasmBr       = 0x0c
+	wasmReturn   = 0x0f
+	wasmCallOp   = 0x10
+	wasmLocalGet = 0x20
+	wasmLocalTee = 0x22
+	wasmLoad8    = 0x2d
+	wasmLoad16   = 0x2f
+	wasmStore8   = 0x3a
+	wasmStore16  = 0x3b
+	wasmConst    = 0x41
+	wasmEqz      = 0x45
+	wasmEq       = 0x46
+	wasmAdd      = 0x6a
+	wasmSub      = 0x6b
+	wasmI32      = 0x7f
+	wasmVoid     = 0x40
+)
+
+func wasmLEB(value int64, signed bool) (bytes []byte) {
+	for {
+		current := byte(value & 0x7f)
+		value >>= 7
+		if (!signed && value == 0) || (signed && ((value == 0 && current&0x40 == 0) || (value == -1 && current&0x40 != 0))) {
+			return append(bytes, current)
+		}
+		bytes = append(bytes, current|0x80)
+	}
+}
+
+func wasmI32Const(value int32) []byte {
+	return append([]byte{wasmConst}, wasmLEB(int64(value), true)...)
+}
+
+func wasmSection(id byte, count int, content ...[]byte) []byte {
+	body := wasmLEB(int64(count), false)
+	for _, part := range content {
+		body = append(body, part...)
+	}
+	return append(append([]byte{id}, wasmLEB(int64(len(body)), false)...), body...)
+}
+
+func wasmName(name string) []byte {
+	return append(wasmLEB(int64(len(name)), false), name...)
+}
+
+// buildWASMModule assembles a plug-in module importing modbus.read and modbus.write, with the buffer at
+// 0 and the given body of handle using one extra i32 local.
+func buildWASMModule(memoryPages int, handle []byte) []byte {
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, wasmSection(1, 3,
+		[]byte{0x60, 4, wasmI32, wasmI32, wasmI32, wasmI32, 1, wasmI32},
+		[]byte{0x60, 0, 1, wasmI32},
+		[]byte{0x60, 3, wasmI32, wasmI32, wasmI32, 1, wasmI32},
+	)...)
+	module = append(module, wasmSection(2, 2,
+		append(append(wasmName("modbus"), wasmName("read")...), 0x00, 0),
+		append(append(wasmName("modbus"), wasmName("write")...), 0x00, 0),
+	)...)
+	module = append(module, wasmSection(3, 2, []byte{1, 2})...)
+	module = append(module, wasmSection(5, 1, append([]byte{0x00}, wasmLEB(int64(memoryPages), false)...))...)
+	module = append(module, wasmSection(7, 3,
+		append(wasmName("memory"), 0x02, 0),
+		append(wasmName("buffer"), 0x00, 2),
+		append(wasmName("handle"), 0x00, 3),
+	)...)
+	buffer := append([]byte{0}, append(wasmI32Const(0), wasmEnd)...)
+	handle = append([]byte{1, 1, wasmI32}, handle...)
+	module = append(module, wasmSection(10, 2,
+		append(wasmLEB(int64(len(buffer)), false), buffer...),
+		append(wasmLEB(int64(len(handle)), false), handle...),
+	)...)
+	return module
+}
+
+// wasmCallOrFail calls read or write on register 0 of the table with the value at 1024 and returns the
+// negated exception code on failure.
+func wasmCallOrFail(function byte, table Table) (code []byte) {
+	for _, argument := range []int32{int32(table), 0, 1, 1024} {
+		code = append(code, wasmI32Const(argument)...)
+	}
+	code = append(code, wasmCallOp, function, wasmLocalTee, 3, wasmIf, wasmVoid)
+	code = append(code, wasmI32Const(0)...)
+	return append(code, wasmLocalGet, 3, wasmSub, wasmReturn, wasmEnd)
+}
+
+// counterModule adds the first request byte to holding register 0 and returns its new value. Function
+// 66 fails after writing.
+func counterModule(memoryPages int) []byte {
+	var code []byte
+	code = append(code, wasmLocalGet, 2, wasmEqz, wasmIf, wasmVoid)
+	code = append(append(code, wasmI32Const(-int32(IllegalDataValue))...), wasmReturn, wasmEnd)
+	code = append(code, wasmCallOrFail(0, TableHoldingRegisters)...)
+	code = append(code, wasmI32Const(1024)...)
+	code = append(code, wasmI32Const(1024)...)
+	code = append(code, wasmLoad16, 1, 0)
+	code = append(code, wasmI32Const(0)...)
+	code = append(code, wasmLoad8, 0, 0, wasmAdd, wasmStore16, 1, 0)
+	code = append(code, wasmCallOrFail(1, TableHoldingRegisters)...)
+	code = append(code, wasmLocalGet, 1)
+	code = append(code, wasmI32Const(66)...)
+	code = append(code, wasmEq, wasmIf, wasmVoid)
+	code = append(append(code, wasmI32Const(-int32(SlaveDeviceFailure))...), wasmReturn, wasmEnd)
+	code = append(code, wasmI32Const(0)...)
+	code = append(code, wasmI32Const(1025)...)
+	code = append(code, wasmLoad8, 0, 0, wasmStore8, 0, 0)
+	code = append(code, wasmI32Const(1)...)
+	code = append(code, wasmI32Const(1024)...)
+	code = append(code, wasmLoad8, 0, 0, wasmStore8, 0, 0)
+	code = append(code, wasmI32Const(2)...)
+	return buildWASMModule(memoryPages, append(code, wasmEnd))
+}
+
+// writeModule writes register 0 of the table and returns no data.
+func writeModule(table Table) []byte {
+	code := wasmCallOrFail(1, table)
+	code = append(code, wasmI32Const(0)...)
+	return buildWASMModule(1, append(code, wasmEnd))
+}
+
+func wasmFrame(function uint8, data ...byte) *TCPFrame {
+	frame := &TCPFrame{Device: 1, Function: function}
+	frame.SetData(data)
+	return frame
+}
+
+func TestWASMPlugin(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	s.Slaves[1].HoldingRegisters[0] = 0x01fe
+	plugin, err := LoadWASMPlugin(counterModule(1), WASMConfig{})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer plugin.Close()
+
+	data, exception := plugin.Handle(s, wasmFrame(65, 3))
+	if exception != &Success || !slices.Equal(data, []byte{0x02, 0x01}) {
+		t.Errorf("expected [2 1], Success, got %v, %v", data, exception)
+	}
+	if got := s.Slaves[1].HoldingRegisters[0]; got != 0x0201 {
+		t.Errorf("expected %04x, got %04x", 0x0201, got)
+	}
+	if _, exception = plugin.Handle(s, wasmFrame(65)); *exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception)
+	}
+	if _, exception = plugin.Handle(s, wasmFrame(66, 3)); *exception != SlaveDeviceFailure || s.Slaves[1].HoldingRegisters[0] != 0x0201 {
+		t.Errorf("expected SlaveDeviceFailure without write, got %v, %04x", exception, s.Slaves[1].HoldingRegisters[0])
+	}
+
+	limit := 0x0201
+	s.AddWriteRule(1, WriteRule{Table: TableHoldingRegisters, Address: 0, Quantity: 1, Max: &limit})
+	if _, exception = plugin.Handle(s, wasmFrame(65, 3)); *exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue from write rule, got %v", exception)
+	}
+	s.ClearWriteRules(1)
+
+	readOnly, _ := LoadWASMPlugin(counterModule(1), WASMConfig{ReadOnly: true})
+	defer readOnly.Close()
+	if _, exception = readOnly.Handle(s, wasmFrame(65, 3)); *exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception)
+	}
+
+	for _, table := range []Table{TableCoils, TableDiscreteInputs, TableHoldingRegisters, TableInputRegisters} {
+		writer, err := LoadWASMPlugin(writeModule(table), WASMConfig{})
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		defer writer.Close()
+		expected := &Success
+		if table == TableDiscreteInputs || table == TableInputRegisters {
+			expected = &IllegalDataAddress
+		}
+		if _, exception = writer.Handle(s, wasmFrame(65)); *exception != *expected {
+			t.Errorf("%s: expected %v, got %v", table, *expected, *exception)
+		}
+	}
+}
+
+func TestWASMPluginLimits(t *testing.T) {
+	s := NewServer(*slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.InitSlave(1)
+	if _, err := LoadWASMPlugin(counterModule(32), WASMConfig{MemoryPages: 16}); err == nil {
+		t.Errorf("expected memory limit error, got nil")
+	}
+	if _, err := LoadWASMPlugin([]byte("not wasm"), WASMConfig{}); err == nil {
+		t.Errorf("expected compile error, got nil")
+	}
+
+	spin := []byte{wasmLoop, wasmVoid, wasmBr, 0, wasmEnd}
+	spin = append(append(spin, wasmI32Const(0)...), wasmEnd)
+	plugin, err := LoadWASMPlugin(buildWASMModule(1, spin), WASMConfig{Timeout: Duration(20 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer plugin.Close()
+	start := time.Now()
+	if _, exception := plugin.Handle(s, wasmFrame(65)); *exception != SlaveDeviceFailure {
+		t.Errorf("expected SlaveDeviceFailure, got %v", exception)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the plug-in to be stopped after the timeout, took %v", elapsed)
+	}
+
+	// The memory isn't locked while the plug-in runs.
+	slow, err := LoadWASMPlugin(buildWASMModule(1, spin), WASMConfig{Timeout: Duration(500 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer slow.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		slow.Handle(s, wasmFrame(65))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start = time.Now()
+	s.WriteTable(1, TableHoldingRegisters, 0, []uint16{1})
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected writes not to wait for the plug-in, took %v", elapsed)
+	}
+	<-done
+}